@@ -11,15 +11,33 @@ import (
 )
 
 const (
-	schemaVersion string = "1.0.0"
+	schemaVersion string = "1.1.0"
+	// schemaVersion100 configurations are migrated when they are loaded.
+	schemaVersion100 string = "1.0.0"
 	// if values are not defined in the configuration file, these are the defaults.
-	defaultParallelRequests uint16        = 16
-	defaultProtocol         string        = "tcp"
-	defaultMaxHops          uint16        = 60
-	defaultNumberQueries    uint16        = 3
-	defaultTracePort        int           = 80
-	defaultInterval         time.Duration = 60 * time.Second
-	defaultTimeout          time.Duration = 5 * time.Second
+	defaultParallelRequests    uint16        = 16
+	defaultProbeBudget         uint16        = 64
+	defaultJitter              float64       = 0.1
+	defaultProtocol            string        = "tcp"
+	defaultFamily              string        = "4"
+	defaultMultipathConfidence float64       = 0.95
+	defaultMaxHops             uint16        = 60
+	defaultNumberQueries       uint16        = 3
+	defaultTracePort           int           = 80
+	defaultInterval            time.Duration = 60 * time.Second
+	defaultTimeout             time.Duration = 5 * time.Second
+	defaultResolveTimeout      time.Duration = 2 * time.Second
+	defaultMetricsPath         string        = "/metrics"
+	defaultHistorySize         int           = 10
+	defaultCompression         string        = "none"
+	defaultOtelTracesPath      string        = "/v1/traces"
+	defaultOtelMetricsPath     string        = "/v1/metrics"
+	defaultSpanLayout          string        = "probe"
+	defaultSinkFileMaxSize     int64         = 100 * 1024 * 1024
+	defaultSinkFileMaxBackups  int           = 5
+	defaultSinkSyslogTag       string        = "traceroute"
+	defaultSinkTimeout         time.Duration = 5 * time.Second
+	defaultSinkNATSSubject     string        = "traceroute.results"
 )
 
 var (
@@ -27,34 +45,105 @@ var (
 )
 
 type TraceConfig struct {
-	SchemaVersion           string                 `yaml:"schema-version" validate:"semver,required"`
-	TraceConfigDestinations []string               `yaml:"destinations" validate:"dive,fqdn"`
-	TraceConfigGlobal       TraceConfigGlobal      `yaml:"globals"`
-	TraceConfigOtel         TraceConfigOtel        `yaml:"opentelemetry"`
-	TraceConfigHealthCheck  TraceConfigHealthCheck `yaml:"healthcheck"`
+	SchemaVersion           string                   `yaml:"schema-version" validate:"semver,required"`
+	TraceConfigDestinations []TraceConfigDestination `yaml:"destinations" validate:"dive"`
+	TraceConfigGlobal       TraceConfigGlobal        `yaml:"globals"`
+	TraceConfigOtel         TraceConfigOtel          `yaml:"opentelemetry"`
+	TraceConfigHealthCheck  TraceConfigHealthCheck   `yaml:"healthcheck"`
+	TraceConfigEnrichment   TraceConfigEnrichment    `yaml:"enrichment"`
+	TraceConfigHistory      TraceConfigHistory       `yaml:"history"`
+	TraceConfigSinks        TraceConfigSinks         `yaml:"sinks"`
 }
 
 type TraceConfigGlobal struct {
-	Protocol         string        `yaml:"protocol" validate:"oneof=udp tcp"`
-	MaxHops          uint16        `yaml:"max-hops"`
-	NQueries         uint16        `yaml:"number-queries"`
-	ParallelRequests uint16        `yaml:"parallel-requests"`
-	Timeout          time.Duration `yaml:"timeout"`
-	TraceRoutePort   int           `yaml:"source-port"`
-	Interval         time.Duration `yaml:"interval"`
+	Protocol            string        `yaml:"protocol" validate:"oneof=udp tcp icmp"`
+	Family              string        `yaml:"family" validate:"omitempty,oneof=4 6 both"`
+	MaxHops             uint16        `yaml:"max-hops"`
+	NQueries            uint16        `yaml:"number-queries"`
+	ParallelRequests    uint16        `yaml:"parallel-requests"`
+	Timeout             time.Duration `yaml:"timeout"`
+	TraceRoutePort      int           `yaml:"source-port"`
+	Interval            time.Duration `yaml:"interval"`
+	Paris               bool          `yaml:"paris"`
+	Multipath           bool          `yaml:"multipath"`
+	MultipathConfidence float64       `yaml:"multipath-confidence" validate:"gte=0,lt=1"`
+	ProbeBudget         uint16        `yaml:"probe-budget"`
+	Jitter              float64       `yaml:"jitter" validate:"gte=0,lte=1"`
 }
 
 type TraceConfigOtel struct {
-	Destination string `yaml:"destination" validate:"required"`
-	TLS         bool   `yaml:"tls"`
-	Port        int    `yaml:"port"`
-	GRPC        bool   `yaml:"grpc"`
+	Destination        string            `yaml:"destination" validate:"required"`
+	TLS                bool              `yaml:"tls"`
+	Port               int               `yaml:"port"`
+	GRPC               bool              `yaml:"grpc"`
+	Metrics            bool              `yaml:"metrics"`
+	CAFile             string            `yaml:"ca-file,omitempty" validate:"omitempty,file"`
+	CertFile           string            `yaml:"cert-file,omitempty" validate:"omitempty,file"`
+	KeyFile            string            `yaml:"key-file,omitempty" validate:"omitempty,file"`
+	ServerName         string            `yaml:"server-name,omitempty"`
+	InsecureSkipVerify bool              `yaml:"insecure-skip-verify"`
+	Headers            map[string]string `yaml:"headers,omitempty"`
+	Compression        string            `yaml:"compression" validate:"omitempty,oneof=none gzip"`
+	TracesPath         string            `yaml:"traces-path"`
+	MetricsPath        string            `yaml:"metrics-path"`
+	SpanLayout         string            `yaml:"span-layout" validate:"omitempty,oneof=probe hop"`
 }
 
 type TraceConfigHealthCheck struct {
-	Path    string `yaml:"path"`
+	Path        string `yaml:"path"`
+	MetricsPath string `yaml:"metrics-path"`
+	Enabled     bool   `yaml:"enabled"`
+	Port        int    `yaml:"port"`
+}
+
+type TraceConfigEnrichment struct {
+	ReverseDNS bool          `yaml:"reverse-dns"`
+	Timeout    time.Duration `yaml:"timeout"`
+	ASNTable   string        `yaml:"asn-table" validate:"omitempty,file"`
+}
+
+type TraceConfigHistory struct {
+	Size      int    `yaml:"size" validate:"gte=0"`
+	Directory string `yaml:"directory"`
+}
+
+// TraceConfigSinks are the destinations the results are sent to in addition to OpenTelemetry, each one is
+// enabled independently.
+type TraceConfigSinks struct {
+	File    TraceConfigSinkFile    `yaml:"file"`
+	Syslog  TraceConfigSinkSyslog  `yaml:"syslog"`
+	Webhook TraceConfigSinkWebhook `yaml:"webhook"`
+	NATS    TraceConfigSinkNATS    `yaml:"nats"`
+}
+
+// TraceConfigSinkFile is the file sink, MaxSize is nil when it is not defined in the configuration file and a
+// size of 0 never rotates the file.
+type TraceConfigSinkFile struct {
+	Enabled    bool   `yaml:"enabled"`
+	Path       string `yaml:"path" validate:"required_if=Enabled true"`
+	MaxSize    *int64 `yaml:"max-size" validate:"omitempty,gte=0"`
+	MaxBackups int    `yaml:"max-backups" validate:"gte=0"`
+}
+
+type TraceConfigSinkSyslog struct {
 	Enabled bool   `yaml:"enabled"`
-	Port    int    `yaml:"port"`
+	Network string `yaml:"network" validate:"omitempty,oneof=udp tcp unix unixgram"`
+	Address string `yaml:"address"`
+	Tag     string `yaml:"tag"`
+}
+
+type TraceConfigSinkWebhook struct {
+	Enabled bool              `yaml:"enabled"`
+	URL     string            `yaml:"url" validate:"required_if=Enabled true,omitempty,url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Timeout time.Duration     `yaml:"timeout"`
+}
+
+type TraceConfigSinkNATS struct {
+	Enabled bool          `yaml:"enabled"`
+	Address string        `yaml:"address" validate:"required_if=Enabled true,omitempty,hostname_port"`
+	Subject string        `yaml:"subject"`
+	Timeout time.Duration `yaml:"timeout"`
 }
 
 type CLI struct{}
@@ -110,8 +199,12 @@ func LoadConfigFromFile(filename string) (*TraceConfig, error) {
 }
 
 func (tc *TraceConfig) CheckandSetValues() error {
-	if tc.SchemaVersion != schemaVersion {
-		return fmt.Errorf("unknown schema version %s", tc.SchemaVersion)
+	err := tc.migrate()
+	if err != nil {
+		return err
+	}
+	if tc.TraceConfigGlobal.Family == "" {
+		tc.TraceConfigGlobal.Family = defaultFamily
 	}
 	if tc.TraceConfigGlobal.MaxHops == 0 {
 		tc.TraceConfigGlobal.MaxHops = defaultMaxHops
@@ -125,40 +218,160 @@ func (tc *TraceConfig) CheckandSetValues() error {
 	if tc.TraceConfigGlobal.ParallelRequests == 0 {
 		tc.TraceConfigGlobal.ParallelRequests = defaultParallelRequests
 	}
+	if tc.TraceConfigGlobal.ProbeBudget == 0 {
+		tc.TraceConfigGlobal.ProbeBudget = defaultProbeBudget
+	}
 	if tc.TraceConfigGlobal.Timeout == 0 {
 		tc.TraceConfigGlobal.Timeout = defaultTimeout
 	}
+	if tc.TraceConfigGlobal.MultipathConfidence == 0 {
+		tc.TraceConfigGlobal.MultipathConfidence = defaultMultipathConfidence
+	}
+	if tc.TraceConfigHealthCheck.MetricsPath == "" {
+		tc.TraceConfigHealthCheck.MetricsPath = defaultMetricsPath
+	}
+	if tc.TraceConfigEnrichment.Timeout == 0 {
+		tc.TraceConfigEnrichment.Timeout = defaultResolveTimeout
+	}
+	if tc.TraceConfigOtel.Compression == "" {
+		tc.TraceConfigOtel.Compression = defaultCompression
+	}
+	if tc.TraceConfigOtel.TracesPath == "" {
+		tc.TraceConfigOtel.TracesPath = defaultOtelTracesPath
+	}
+	if tc.TraceConfigOtel.MetricsPath == "" {
+		tc.TraceConfigOtel.MetricsPath = defaultOtelMetricsPath
+	}
+	if tc.TraceConfigOtel.SpanLayout == "" {
+		tc.TraceConfigOtel.SpanLayout = defaultSpanLayout
+	}
+	if tc.TraceConfigHistory.Size == 0 {
+		tc.TraceConfigHistory.Size = defaultHistorySize
+	}
+	tc.TraceConfigSinks.SetDefaults()
+	for i := range tc.TraceConfigDestinations {
+		tc.TraceConfigDestinations[i].inherit(tc.TraceConfigGlobal)
+	}
 	return nil
 }
 
+// SetDefaults sets the values of the sinks that are not defined in the configuration file.
+func (sinks *TraceConfigSinks) SetDefaults() {
+	if sinks.File.MaxSize == nil {
+		maxSize := defaultSinkFileMaxSize
+		sinks.File.MaxSize = &maxSize
+	}
+	if sinks.File.MaxBackups == 0 {
+		sinks.File.MaxBackups = defaultSinkFileMaxBackups
+	}
+	if sinks.Syslog.Tag == "" {
+		sinks.Syslog.Tag = defaultSinkSyslogTag
+	}
+	if sinks.Webhook.Timeout == 0 {
+		sinks.Webhook.Timeout = defaultSinkTimeout
+	}
+	if sinks.NATS.Subject == "" {
+		sinks.NATS.Subject = defaultSinkNATSSubject
+	}
+	if sinks.NATS.Timeout == 0 {
+		sinks.NATS.Timeout = defaultSinkTimeout
+	}
+}
+
+// LoadSinksFromFile loads only the sinks section of a configuration file, the traceroute command uses it to
+// send its results to the same sinks as the service.
+func LoadSinksFromFile(filename string) (*TraceConfigSinks, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := struct {
+		TraceConfigSinks TraceConfigSinks `yaml:"sinks"`
+	}{}
+	err = yaml.NewDecoder(f).Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	validate = validator.New()
+	err = validate.Struct(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.TraceConfigSinks.SetDefaults()
+	return &cfg.TraceConfigSinks, nil
+}
+
 // PrintEmptyConfiguration is used to generate an empty configuration to stdout
 func PrintEmptyConfiguration() error {
+	maxSize := defaultSinkFileMaxSize
 	emptyConfig := TraceConfig{
 		SchemaVersion: schemaVersion,
-		TraceConfigDestinations: []string{
-			"first-test-domain.org",
-			"second-test-domain.org",
-			"third-test-domain.net",
+		TraceConfigDestinations: []TraceConfigDestination{
+			{Destination: "first-test-domain.org"},
+			{Destination: "second-test-domain.org"},
+			{
+				Destination: "third-test-domain.net",
+				Protocol:    "udp",
+				Port:        53,
+				Interval:    10 * time.Second,
+				Tags:        map[string]string{"service": "dns"},
+			},
 		},
 		TraceConfigGlobal: TraceConfigGlobal{
-			Protocol:         defaultProtocol,
-			MaxHops:          defaultMaxHops,
-			NQueries:         defaultNumberQueries,
-			ParallelRequests: defaultParallelRequests,
-			Timeout:          defaultTimeout,
-			TraceRoutePort:   defaultTracePort,
-			Interval:         defaultInterval,
+			Protocol:            defaultProtocol,
+			Family:              defaultFamily,
+			MaxHops:             defaultMaxHops,
+			NQueries:            defaultNumberQueries,
+			ParallelRequests:    defaultParallelRequests,
+			Timeout:             defaultTimeout,
+			TraceRoutePort:      defaultTracePort,
+			Interval:            defaultInterval,
+			MultipathConfidence: defaultMultipathConfidence,
+			ProbeBudget:         defaultProbeBudget,
+			Jitter:              defaultJitter,
 		},
 		TraceConfigOtel: TraceConfigOtel{
 			Destination: "192.168.0.183",
 			TLS:         false,
 			Port:        4317,
 			GRPC:        true,
+			Metrics:     true,
+			Compression: defaultCompression,
+			TracesPath:  defaultOtelTracesPath,
+			MetricsPath: defaultOtelMetricsPath,
+			SpanLayout:  defaultSpanLayout,
 		},
 		TraceConfigHealthCheck: TraceConfigHealthCheck{
-			Path:    "/_healthcheck",
-			Enabled: true,
-			Port:    8080,
+			Path:        "/_healthcheck",
+			MetricsPath: defaultMetricsPath,
+			Enabled:     true,
+			Port:        8080,
+		},
+		TraceConfigEnrichment: TraceConfigEnrichment{
+			ReverseDNS: true,
+			Timeout:    defaultResolveTimeout,
+		},
+		TraceConfigHistory: TraceConfigHistory{
+			Size: defaultHistorySize,
+		},
+		TraceConfigSinks: TraceConfigSinks{
+			File: TraceConfigSinkFile{
+				Path:       "/var/log/traceroute/results.jsonl",
+				MaxSize:    &maxSize,
+				MaxBackups: defaultSinkFileMaxBackups,
+			},
+			Syslog: TraceConfigSinkSyslog{
+				Tag: defaultSinkSyslogTag,
+			},
+			Webhook: TraceConfigSinkWebhook{
+				Timeout: defaultSinkTimeout,
+			},
+			NATS: TraceConfigSinkNATS{
+				Subject: defaultSinkNATSSubject,
+				Timeout: defaultSinkTimeout,
+			},
 		},
 	}
 
@@ -1,13 +1,20 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestTraceConfigCheckandSetValues(t *testing.T) {
 	type fields struct {
 		SchemaVersion           string
-		TraceConfigDestinations []string
+		TraceConfigDestinations []TraceConfigDestination
 		TraceConfigGlobal       TraceConfigGlobal
 		TraceConfigOtel         TraceConfigOtel
 		TraceConfigHealthCheck  TraceConfigHealthCheck
@@ -32,6 +39,13 @@ func TestTraceConfigCheckandSetValues(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "unknown schema version",
+			fields: fields{
+				SchemaVersion: "2.0.0",
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -48,3 +62,206 @@ func TestTraceConfigCheckandSetValues(t *testing.T) {
 		})
 	}
 }
+
+func TestTraceConfigDestinations(t *testing.T) {
+	global := "globals:\n  protocol: icmp\n  source-port: 33434\n  interval: 30s\n"
+	tests := []struct {
+		name    string
+		yaml    string
+		want    []TraceConfigDestination
+		wantErr bool
+	}{
+		{
+			name: "strings inherit the globals",
+			yaml: "schema-version: 1.1.0\n" + global + "destinations:\n  - example.com\n",
+			want: []TraceConfigDestination{
+				{Destination: "example.com", Protocol: "icmp", Port: 33434, Family: defaultFamily, MaxHops: defaultMaxHops, NQueries: defaultNumberQueries, Interval: 30 * time.Second, Timeout: defaultTimeout},
+			},
+		},
+		{
+			name: "objects override the globals",
+			yaml: "schema-version: 1.1.0\n" + global + "destinations:\n  - destination: 192.0.2.1\n    protocol: udp\n    port: 53\n" +
+				"    interval: 10s\n    tags:\n      service: dns\n",
+			want: []TraceConfigDestination{
+				{Destination: "192.0.2.1", Protocol: "udp", Port: 53, Family: defaultFamily, MaxHops: defaultMaxHops, NQueries: defaultNumberQueries, Interval: 10 * time.Second, Timeout: defaultTimeout, Tags: map[string]string{"service": "dns"}, object: true},
+			},
+		},
+		{
+			name: "schema 1.0.0 is migrated",
+			yaml: "schema-version: 1.0.0\n" + global + "destinations:\n  - example.com\n",
+			want: []TraceConfigDestination{
+				{Destination: "example.com", Protocol: "icmp", Port: 33434, Family: defaultFamily, MaxHops: defaultMaxHops, NQueries: defaultNumberQueries, Interval: 30 * time.Second, Timeout: defaultTimeout},
+			},
+		},
+		{
+			name:    "schema 1.0.0 does not allow objects",
+			yaml:    "schema-version: 1.0.0\n" + global + "destinations:\n  - destination: example.com\n    protocol: udp\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := new(TraceConfig)
+			err := tc.LoadConfig(strings.NewReader(tt.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tc.CheckandSetValues()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TraceConfig.CheckandSetValues() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tc.SchemaVersion != schemaVersion {
+				t.Errorf("SchemaVersion = %s, want %s", tc.SchemaVersion, schemaVersion)
+			}
+			if !reflect.DeepEqual(tc.TraceConfigDestinations, tt.want) {
+				t.Errorf("TraceConfigDestinations = %+v, want %+v", tc.TraceConfigDestinations, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFromFile(t *testing.T) {
+	tests := []struct {
+		file string
+		want []string
+	}{
+		{file: "test-data/valid-config-1.0.0.yaml", want: []string{"google.com", "apple.com"}},
+		{file: "test-data/valid-config.yaml", want: []string{"google.com", "apple.com", "1.1.1.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			tc, err := LoadConfigFromFile(tt.file)
+			if err != nil {
+				t.Fatalf("LoadConfigFromFile() error = %v", err)
+			}
+			if tc.SchemaVersion != schemaVersion {
+				t.Errorf("SchemaVersion = %s, want %s", tc.SchemaVersion, schemaVersion)
+			}
+			var got []string
+			for _, d := range tc.TraceConfigDestinations {
+				got = append(got, d.Destination)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TraceConfigDestinations = %v, want %v", got, tt.want)
+			}
+			// the destinations written as strings inherit the globals of the file.
+			if d := tc.TraceConfigDestinations[0]; d.Protocol != "udp" || d.MaxHops != 5 || d.Interval != time.Minute {
+				t.Errorf("TraceConfigDestinations[0] = %+v, want the globals", d)
+			}
+		})
+	}
+}
+
+func TestTraceConfigDestinationMarshalYAML(t *testing.T) {
+	destinations := []TraceConfigDestination{
+		{Destination: "example.com"},
+		{Destination: "192.0.2.1", Protocol: "udp", Port: 53},
+	}
+	got, err := yaml.Marshal(destinations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "- example.com\n- destination: 192.0.2.1\n  protocol: udp\n  port: 53\n"
+	if string(got) != want {
+		t.Errorf("yaml.Marshal() = %q, want %q", got, want)
+	}
+}
+
+func TestTraceConfigDestinationEqual(t *testing.T) {
+	d := TraceConfigDestination{Destination: "example.com", Protocol: "udp", Port: 53, Tags: map[string]string{"service": "dns"}}
+	tests := []struct {
+		name  string
+		other TraceConfigDestination
+		want  bool
+	}{
+		{
+			name:  "written as an object",
+			other: TraceConfigDestination{Destination: "example.com", Protocol: "udp", Port: 53, Tags: map[string]string{"service": "dns"}, object: true},
+			want:  true,
+		},
+		{
+			name:  "different port",
+			other: TraceConfigDestination{Destination: "example.com", Protocol: "udp", Port: 5353, Tags: map[string]string{"service": "dns"}},
+		},
+		{
+			name:  "different tags",
+			other: TraceConfigDestination{Destination: "example.com", Protocol: "udp", Port: 53},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.Equal(tt.other); got != tt.want {
+				t.Errorf("TraceConfigDestination.Equal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSinksFromFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		maxSize int64
+		wantErr bool
+	}{
+		{
+			name:    "no sinks",
+			yaml:    "schema-version: 1.0.0\n",
+			maxSize: defaultSinkFileMaxSize,
+		},
+		{
+			name:    "file sink that is never rotated",
+			yaml:    "sinks:\n  file:\n    enabled: true\n    path: /tmp/results.jsonl\n    max-size: 0\n",
+			maxSize: 0,
+		},
+		{
+			name:    "file sink with a negative size",
+			yaml:    "sinks:\n  file:\n    enabled: true\n    path: /tmp/results.jsonl\n    max-size: -1\n",
+			wantErr: true,
+		},
+		{
+			name: "enabled sinks",
+			yaml: "sinks:\n  file:\n    enabled: true\n    path: /tmp/results.jsonl\n" +
+				"  webhook:\n    enabled: true\n    url: https://example.com/hook\n" +
+				"  nats:\n    enabled: true\n    address: localhost:4222\n",
+			maxSize: defaultSinkFileMaxSize,
+		},
+		{
+			name:    "file sink without a path",
+			yaml:    "sinks:\n  file:\n    enabled: true\n",
+			wantErr: true,
+		},
+		{
+			name:    "webhook sink with an invalid url",
+			yaml:    "sinks:\n  webhook:\n    enabled: true\n    url: not a url\n",
+			wantErr: true,
+		},
+		{
+			name:    "nats sink without a port",
+			yaml:    "sinks:\n  nats:\n    enabled: true\n    address: localhost\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config.yaml")
+			err := os.WriteFile(file, []byte(tt.yaml), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadSinksFromFile(file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadSinksFromFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (got.NATS.Subject != defaultSinkNATSSubject || got.Webhook.Timeout != defaultSinkTimeout) {
+				t.Errorf("LoadSinksFromFile() did not set the defaults: %+v", got)
+			}
+			if err == nil && *got.File.MaxSize != tt.maxSize {
+				t.Errorf("LoadSinksFromFile() max size = %d, want %d", *got.File.MaxSize, tt.maxSize)
+			}
+		})
+	}
+}
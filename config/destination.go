@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TraceConfigDestination is a destination and the settings that override the globals for it, in the
+// configuration file it is either the destination as a string or an object.
+type TraceConfigDestination struct {
+	Destination string            `yaml:"destination" validate:"required,fqdn|ip"`
+	Protocol    string            `yaml:"protocol,omitempty" validate:"omitempty,oneof=udp tcp icmp"`
+	Port        int               `yaml:"port,omitempty" validate:"gte=0,lte=65535"`
+	Family      string            `yaml:"family,omitempty" validate:"omitempty,oneof=4 6 both"`
+	MaxHops     uint16            `yaml:"max-hops,omitempty"`
+	NQueries    uint16            `yaml:"number-queries,omitempty"`
+	Interval    time.Duration     `yaml:"interval,omitempty" validate:"gte=0"`
+	Timeout     time.Duration     `yaml:"timeout,omitempty" validate:"gte=0"`
+	Tags        map[string]string `yaml:"tags,omitempty"`
+	// object is true when the destination was written as an object, schema 1.0.0 only allows strings.
+	object bool
+}
+
+// traceConfigDestination has the same fields without the YAML methods so it can be decoded as an object.
+type traceConfigDestination TraceConfigDestination
+
+// UnmarshalYAML accepts the destination as a string or as an object.
+func (d *TraceConfigDestination) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*d = TraceConfigDestination{Destination: value.Value}
+		return nil
+	}
+	var dst traceConfigDestination
+	err := value.Decode(&dst)
+	if err != nil {
+		return err
+	}
+	*d = TraceConfigDestination(dst)
+	d.object = true
+	return nil
+}
+
+// MarshalYAML writes the destination as a string when it does not override any of the globals.
+func (d TraceConfigDestination) MarshalYAML() (interface{}, error) {
+	if d.Protocol == "" && d.Port == 0 && d.Family == "" && d.MaxHops == 0 && d.NQueries == 0 &&
+		d.Interval == 0 && d.Timeout == 0 && len(d.Tags) == 0 {
+		return d.Destination, nil
+	}
+	return traceConfigDestination(d), nil
+}
+
+// inherit sets the settings that the destination does not override from the globals.
+func (d *TraceConfigDestination) inherit(global TraceConfigGlobal) {
+	if d.Protocol == "" {
+		d.Protocol = global.Protocol
+	}
+	if d.Port == 0 {
+		d.Port = global.TraceRoutePort
+	}
+	if d.Family == "" {
+		d.Family = global.Family
+	}
+	if d.MaxHops == 0 {
+		d.MaxHops = global.MaxHops
+	}
+	if d.NQueries == 0 {
+		d.NQueries = global.NQueries
+	}
+	if d.Interval == 0 {
+		d.Interval = global.Interval
+	}
+	if d.Timeout == 0 {
+		d.Timeout = global.Timeout
+	}
+}
+
+// migrate upgrades a configuration written for an older schema to the current schema.
+func (tc *TraceConfig) migrate() error {
+	switch tc.SchemaVersion {
+	case schemaVersion:
+		return nil
+	case schemaVersion100:
+		// 1.0.0 only differs by the destinations being a list of strings.
+		for _, d := range tc.TraceConfigDestinations {
+			if d.object {
+				return fmt.Errorf("destination %s is an object, this requires schema version %s", d.Destination, schemaVersion)
+			}
+		}
+		tc.SchemaVersion = schemaVersion
+		return nil
+	}
+	return fmt.Errorf("unknown schema version %s", tc.SchemaVersion)
+}
+
+// Equal reports whether the destinations are traced with the same settings.
+func (d TraceConfigDestination) Equal(other TraceConfigDestination) bool {
+	return d.Destination == other.Destination && d.Protocol == other.Protocol && d.Port == other.Port &&
+		d.Family == other.Family && d.MaxHops == other.MaxHops && d.NQueries == other.NQueries &&
+		d.Interval == other.Interval && d.Timeout == other.Timeout && maps.Equal(d.Tags, other.Tags)
+}
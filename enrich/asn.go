@@ -0,0 +1,153 @@
+package enrich
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+// Table maps prefixes to the autonomous system that originates them, lookups return the longest matching prefix.
+type Table struct {
+	// prefixes are indexed by prefix length, lengths is sorted longest first.
+	prefixes map[int]map[netip.Prefix]methods.ASInfo
+	lengths  []int
+}
+
+// LoadTable reads the ASN table from a file, see ParseTable for the supported formats.
+func LoadTable(filename string) (*Table, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTable(f)
+}
+
+// ParseTable reads an ASN table, each line is either a CSV record of prefix,asn[,org] or a CAIDA
+// pfx2as record of address, prefix length and ASN separated by whitespace. Empty lines, lines starting
+// with # and a CSV header are skipped. For multi-origin (1_2) and AS set (1,2) pfx2as records the first
+// ASN is used.
+func ParseTable(r io.Reader) (*Table, error) {
+	t := &Table{prefixes: map[int]map[netip.Prefix]methods.ASInfo{}}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		as, err := parseRecord(text)
+		if err != nil {
+			if line == 1 {
+				// the first line of a CSV file may be a header.
+				continue
+			}
+			return nil, fmt.Errorf("asn table line %d: %w", line, err)
+		}
+		t.add(as)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(t.lengths)))
+	return t, nil
+}
+
+// parseRecord parses a single CSV or pfx2as record.
+func parseRecord(text string) (methods.ASInfo, error) {
+	fields, err := csv.NewReader(strings.NewReader(text)).Read()
+	if err == nil && len(fields) >= 2 && strings.Contains(fields[0], "/") {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(fields[0]))
+		if err != nil {
+			return methods.ASInfo{}, err
+		}
+		asn, err := parseASN(fields[1])
+		if err != nil {
+			return methods.ASInfo{}, err
+		}
+		as := methods.ASInfo{ASN: asn, Prefix: prefix.Masked().String()}
+		if len(fields) > 2 {
+			as.Org = strings.TrimSpace(fields[2])
+		}
+		return as, nil
+	}
+
+	fields = strings.Fields(text)
+	if len(fields) < 3 {
+		return methods.ASInfo{}, fmt.Errorf("expected address, prefix length and asn in %q", text)
+	}
+	prefix, err := netip.ParsePrefix(fields[0] + "/" + fields[1])
+	if err != nil {
+		return methods.ASInfo{}, err
+	}
+	origins := strings.FieldsFunc(fields[2], func(r rune) bool { return r == '_' || r == ',' })
+	if len(origins) == 0 {
+		return methods.ASInfo{}, fmt.Errorf("invalid asn %q", fields[2])
+	}
+	asn, err := parseASN(origins[0])
+	if err != nil {
+		return methods.ASInfo{}, err
+	}
+	return methods.ASInfo{ASN: asn, Prefix: prefix.Masked().String()}, nil
+}
+
+// parseASN parses an AS number with or without the AS prefix.
+func parseASN(s string) (uint32, error) {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "AS")
+	asn, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid asn %q", s)
+	}
+	return uint32(asn), nil
+}
+
+func (t *Table) add(as methods.ASInfo) {
+	prefix := netip.MustParsePrefix(as.Prefix)
+	bits := prefix.Bits()
+	if _, ok := t.prefixes[bits]; !ok {
+		t.prefixes[bits] = map[netip.Prefix]methods.ASInfo{}
+		t.lengths = append(t.lengths, bits)
+	}
+	t.prefixes[bits][prefix] = as
+}
+
+// Lookup returns the autonomous system of the longest prefix that contains the address.
+func (t *Table) Lookup(ip net.IP) (methods.ASInfo, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return methods.ASInfo{}, false
+	}
+	addr = addr.Unmap()
+	for _, bits := range t.lengths {
+		if bits > addr.BitLen() {
+			continue
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if as, ok := t.prefixes[bits][prefix]; ok {
+			return as, true
+		}
+	}
+	return methods.ASInfo{}, false
+}
+
+// Len returns the number of prefixes in the table.
+func (t *Table) Len() int {
+	n := 0
+	for _, prefixes := range t.prefixes {
+		n += len(prefixes)
+	}
+	return n
+}
@@ -0,0 +1,61 @@
+package enrich
+
+import (
+	"context"
+
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+// Enricher adds the reverse name and autonomous system of the hop addresses to a traceroute result.
+type Enricher struct {
+	ptr   *PTR
+	table *Table
+}
+
+// New returns an Enricher, reverse lookups are disabled when ptr is nil and AS lookups when table is nil.
+func New(ptr *PTR, table *Table) *Enricher {
+	return &Enricher{
+		ptr:   ptr,
+		table: table,
+	}
+}
+
+// Enrich looks up every distinct hop address once and sets the details on each probe answered by the address.
+func (e *Enricher) Enrich(ctx context.Context, res *methods.TraceResult) {
+	addrs := []string{}
+	seen := map[string]bool{}
+	for _, hop := range res.Hops {
+		for _, probe := range hop.Probes {
+			if !probe.Success || probe.Address == nil {
+				continue
+			}
+			addr := methods.AddrIP(probe.Address).String()
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	names := map[string]string{}
+	if e.ptr != nil {
+		names = e.ptr.LookupAll(ctx, addrs)
+	}
+
+	for i := range res.Hops {
+		for j := range res.Hops[i].Probes {
+			probe := &res.Hops[i].Probes[j]
+			if !probe.Success || probe.Address == nil {
+				continue
+			}
+			ip := methods.AddrIP(probe.Address)
+			probe.Hostname = names[ip.String()]
+			if e.table == nil {
+				continue
+			}
+			if as, ok := e.table.Lookup(ip); ok {
+				probe.AS = &as
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package enrich
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+// resolver returns the names from a map and counts the lookups, the lookups of the failing addresses time out and
+// the other addresses do not exist.
+type resolver struct {
+	mu      sync.Mutex
+	names   map[string]string
+	failing map[string]bool
+	lookups map[string]int
+}
+
+func (r *resolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups[addr]++
+	if r.failing[addr] {
+		return nil, &net.DNSError{Err: "i/o timeout", Name: addr, IsTimeout: true}
+	}
+	name, ok := r.names[addr]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return []string{name + "."}, nil
+}
+
+const table = `prefix,asn,org
+# comments and blank lines are skipped
+
+10.0.0.0/8,64500,Example Backbone
+10.1.0.0/16,AS64501,"Example Transit, Inc"
+2001:db8::/32,64502,Example IPv6
+192.0.2.0	24	64503_64504
+198.51.100.0	24	64505,64506
+`
+
+func TestParseTable(t *testing.T) {
+	tbl, err := ParseTable(strings.NewReader(table))
+	if err != nil {
+		t.Fatalf("ParseTable() error = %v", err)
+	}
+	if tbl.Len() != 5 {
+		t.Errorf("Table.Len() = %d, want %d", tbl.Len(), 5)
+	}
+	tests := []struct {
+		name   string
+		ip     string
+		want   methods.ASInfo
+		wantOK bool
+	}{
+		{name: "shorter prefix", ip: "10.2.0.1", want: methods.ASInfo{ASN: 64500, Prefix: "10.0.0.0/8", Org: "Example Backbone"}, wantOK: true},
+		{name: "longest prefix", ip: "10.1.2.3", want: methods.ASInfo{ASN: 64501, Prefix: "10.1.0.0/16", Org: "Example Transit, Inc"}, wantOK: true},
+		{name: "ipv6", ip: "2001:db8::1", want: methods.ASInfo{ASN: 64502, Prefix: "2001:db8::/32", Org: "Example IPv6"}, wantOK: true},
+		{name: "pfx2as multi origin", ip: "192.0.2.1", want: methods.ASInfo{ASN: 64503, Prefix: "192.0.2.0/24"}, wantOK: true},
+		{name: "pfx2as as set", ip: "198.51.100.1", want: methods.ASInfo{ASN: 64505, Prefix: "198.51.100.0/24"}, wantOK: true},
+		{name: "no match", ip: "203.0.113.1", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tbl.Lookup(net.ParseIP(tt.ip))
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("Table.Lookup() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseTableError(t *testing.T) {
+	_, err := ParseTable(strings.NewReader("10.0.0.0/8,64500\n10.1.0.0/16,not-an-asn\n"))
+	if err == nil {
+		t.Errorf("ParseTable() error = nil, want an error for an invalid asn")
+	}
+}
+
+func TestPTRCache(t *testing.T) {
+	r := &resolver{
+		names:   map[string]string{"10.0.0.1": "router.example.net"},
+		failing: map[string]bool{"10.0.0.3": true},
+		lookups: map[string]int{},
+	}
+	ptr := NewPTR(r, time.Second)
+	now := time.Now()
+	ptr.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		got := ptr.LookupAll(context.Background(), []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"})
+		want := map[string]string{"10.0.0.1": "router.example.net", "10.0.0.2": "", "10.0.0.3": ""}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("PTR.LookupAll() = %v, want %v", got, want)
+		}
+	}
+	// the address without a name is cached, the lookup that timed out is not.
+	if want := map[string]int{"10.0.0.1": 1, "10.0.0.2": 1, "10.0.0.3": 2}; !reflect.DeepEqual(r.lookups, want) {
+		t.Errorf("PTR.LookupAll() lookups = %v, want %v", r.lookups, want)
+	}
+
+	now = now.Add(2 * defaultNegativeTTL)
+	ptr.LookupAll(context.Background(), []string{"10.0.0.1", "10.0.0.2"})
+	if r.lookups["10.0.0.1"] != 1 || r.lookups["10.0.0.2"] != 2 {
+		t.Errorf("PTR.LookupAll() lookups = %v, want the address without a name to be looked up again", r.lookups)
+	}
+
+	now = now.Add(2 * defaultCacheTTL)
+	ptr.Lookup(context.Background(), "10.0.0.1")
+	if r.lookups["10.0.0.1"] != 2 {
+		t.Errorf("PTR.Lookup() lookups = %d, want the expired name to be looked up again", r.lookups["10.0.0.1"])
+	}
+}
+
+func TestPTRCacheEviction(t *testing.T) {
+	r := &resolver{
+		names:   map[string]string{"10.0.0.1": "a.example.net", "10.0.0.2": "b.example.net", "10.0.0.3": "c.example.net"},
+		lookups: map[string]int{},
+	}
+	ptr := NewPTR(r, time.Second)
+	ptr.size = 2
+	now := time.Now()
+	ptr.now = func() time.Time { return now }
+
+	// the full cache evicts the entry that expires first.
+	ptr.Lookup(context.Background(), "10.0.0.1")
+	now = now.Add(time.Minute)
+	ptr.Lookup(context.Background(), "10.0.0.2")
+	ptr.Lookup(context.Background(), "10.0.0.3")
+	if _, ok := ptr.cache["10.0.0.1"]; ok || len(ptr.cache) != 2 {
+		t.Errorf("PTR.Lookup() cache = %v, want 10.0.0.1 evicted", ptr.cache)
+	}
+
+	// the expired entries are evicted before the others.
+	now = now.Add(2 * defaultCacheTTL)
+	ptr.Lookup(context.Background(), "10.0.0.4")
+	if len(ptr.cache) != 1 {
+		t.Errorf("PTR.Lookup() cache = %v, want the expired entries evicted", ptr.cache)
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	tbl, err := ParseTable(strings.NewReader(table))
+	if err != nil {
+		t.Fatalf("ParseTable() error = %v", err)
+	}
+	r := &resolver{names: map[string]string{"10.1.0.1": "core1.example.net"}, lookups: map[string]int{}}
+	rtt := time.Millisecond
+	res := methods.NewTraceResult("example.com", net.ParseIP("192.0.2.1"), "udp", 33434)
+	res.Hops = []methods.TraceHop{
+		{TTL: 1, Probes: []methods.TracerouteHop{
+			{Success: true, Address: &net.IPAddr{IP: net.ParseIP("10.1.0.1")}, TTL: 1, RTT: &rtt},
+			{Success: false, TTL: 1},
+			{Success: true, Address: &net.IPAddr{IP: net.ParseIP("10.1.0.1")}, TTL: 1, RTT: &rtt},
+		}},
+		{TTL: 2, Probes: []methods.TracerouteHop{
+			{Success: true, Address: &net.IPAddr{IP: net.ParseIP("203.0.113.1")}, TTL: 2, RTT: &rtt},
+		}},
+	}
+
+	New(NewPTR(r, time.Second), tbl).Enrich(context.Background(), res)
+
+	first := res.Hops[0].Probes[0]
+	if first.Hostname != "core1.example.net" || first.AS == nil || first.AS.ASN != 64501 {
+		t.Errorf("Enricher.Enrich() = %+v, want the hostname and AS of 10.1.0.1", first)
+	}
+	if res.Hops[0].Probes[1].AS != nil || res.Hops[0].Probes[2].Hostname != "core1.example.net" {
+		t.Errorf("Enricher.Enrich() probes = %+v", res.Hops[0].Probes)
+	}
+	if res.Hops[1].Probes[0].Hostname != "" || res.Hops[1].Probes[0].AS != nil {
+		t.Errorf("Enricher.Enrich() = %+v, want no details for an unknown address", res.Hops[1].Probes[0])
+	}
+	if r.lookups["10.1.0.1"] != 1 {
+		t.Errorf("Enricher.Enrich() lookups = %d, want %d", r.lookups["10.1.0.1"], 1)
+	}
+}
@@ -0,0 +1,130 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/parallel_limiter"
+)
+
+const (
+	// router interfaces are rarely renamed so the names are cached for much longer than the service interval.
+	defaultCacheTTL time.Duration = time.Hour
+	// addresses without a name are looked up again sooner in case the name is added.
+	defaultNegativeTTL time.Duration = 5 * time.Minute
+	// defaultCacheSize bounds the number of cached addresses, the expired entries are evicted when it is reached.
+	defaultCacheSize int = 4096
+	// maximum number of reverse lookups in flight for a single result.
+	defaultParallelLookups int = 8
+)
+
+// Resolver looks up the reverse names of an address, it is satisfied by *net.Resolver.
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// PTR performs reverse lookups with a timeout and caches the names. An address that has no name is cached as an
+// empty name for a shorter time, the lookups that fail or time out are not cached.
+type PTR struct {
+	resolver    Resolver
+	timeout     time.Duration
+	ttl         time.Duration
+	negativeTTL time.Duration
+	size        int
+	now         func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]ptrEntry
+}
+
+type ptrEntry struct {
+	name    string
+	expires time.Time
+}
+
+// NewPTR returns a PTR resolver, each lookup is cancelled after timeout.
+func NewPTR(resolver Resolver, timeout time.Duration) *PTR {
+	return &PTR{
+		resolver:    resolver,
+		timeout:     timeout,
+		ttl:         defaultCacheTTL,
+		negativeTTL: defaultNegativeTTL,
+		size:        defaultCacheSize,
+		now:         time.Now,
+		cache:       map[string]ptrEntry{},
+	}
+}
+
+// Lookup returns the first reverse name of the address without the trailing dot, or an empty string.
+func (p *PTR) Lookup(ctx context.Context, addr string) string {
+	p.mu.Lock()
+	entry, ok := p.cache[addr]
+	p.mu.Unlock()
+	if ok && p.now().Before(entry.expires) {
+		return entry.name
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	names, err := p.resolver.LookupAddr(ctx, addr)
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil && len(names) > 0:
+		name := strings.TrimSuffix(names[0], ".")
+		p.store(addr, name, p.ttl)
+		return name
+	case err == nil, errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		p.store(addr, "", p.negativeTTL)
+	}
+	return ""
+}
+
+// store caches the name of the address for ttl. When the cache is full the expired entries are evicted, or the
+// entry that expires first when none has expired.
+func (p *PTR) store(addr, name string, ttl time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	if _, ok := p.cache[addr]; !ok && len(p.cache) >= p.size {
+		var first string
+		for key, entry := range p.cache {
+			if !now.Before(entry.expires) {
+				delete(p.cache, key)
+				continue
+			}
+			if first == "" || entry.expires.Before(p.cache[first].expires) {
+				first = key
+			}
+		}
+		if len(p.cache) >= p.size {
+			delete(p.cache, first)
+		}
+	}
+	p.cache[addr] = ptrEntry{name: name, expires: now.Add(ttl)}
+}
+
+// LookupAll looks up the addresses concurrently and returns the names indexed by address.
+func (p *PTR) LookupAll(ctx context.Context, addrs []string) map[string]string {
+	limiter := parallel_limiter.New(defaultParallelLookups)
+	wg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+	names := make(map[string]string, len(addrs))
+	for _, addr := range addrs {
+		<-limiter.Start()
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			defer limiter.Finished()
+			name := p.Lookup(ctx, addr)
+			mu.Lock()
+			defer mu.Unlock()
+			names[addr] = name
+		}(addr)
+	}
+	wg.Wait()
+	return names
+}
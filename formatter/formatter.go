@@ -0,0 +1,218 @@
+package formatter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+const (
+	// output formats supported by New.
+	Text  string = "text"
+	JSON  string = "json"
+	JSONL string = "jsonl"
+	CSV   string = "csv"
+)
+
+// csvHeader is written before the first record, there is one record per probe.
+var csvHeader = []string{
+	"destination", "ip", "protocol", "port", "start_time", "ttl", "probe",
+	"address", "hostname", "rtt_ms", "icmp_type", "icmp_code", "marker",
+	"asn", "prefix", "as_org",
+	"hop_loss_pct", "hop_min_ms", "hop_avg_ms", "hop_median_ms", "hop_max_ms", "hop_stddev_ms", "hop_jitter_ms",
+	"hop_responders",
+}
+
+// Formatter writes traceroute results to the output.
+type Formatter interface {
+	Format(res *methods.TraceResult) error
+}
+
+// New returns the formatter for the output format, results are written to w.
+func New(format string, w io.Writer) (Formatter, error) {
+	switch format {
+	case Text, "":
+		return &text{w: w}, nil
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return &jsonFormatter{enc: enc}, nil
+	case JSONL:
+		return &jsonFormatter{enc: json.NewEncoder(w)}, nil
+	case CSV:
+		return &csvFormatter{w: csv.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("output format %s not understood", format)
+}
+
+// text renders the result in the same layout as the classic traceroute.
+type text struct {
+	w io.Writer
+}
+
+func (t *text) Format(res *methods.TraceResult) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "traceroute to %s (%s), %s", res.Destination, res.IP, res.Protocol)
+	// icmp probes do not have a port.
+	if res.Port != 0 {
+		fmt.Fprintf(&b, " port %d", res.Port)
+	}
+	b.WriteString("\n")
+	for _, hop := range res.Hops {
+		fmt.Fprintf(&b, "%2d ", hop.TTL)
+		var last string
+		var mpls []methods.MPLSLabel
+		for _, probe := range hop.Probes {
+			if !probe.Success {
+				b.WriteString(" *")
+				continue
+			}
+			// the address is only repeated when the probes of a hop were answered by different interfaces.
+			addr := methods.AddrIP(probe.Address).String()
+			if addr != last {
+				name := probe.Hostname
+				if name == "" {
+					name = addr
+				}
+				fmt.Fprintf(&b, " %s (%s)", name, addr)
+				if probe.AS != nil {
+					fmt.Fprintf(&b, " [AS%d]", probe.AS.ASN)
+				}
+				last = addr
+			}
+			fmt.Fprintf(&b, "  %s", milliseconds(*probe.RTT))
+			if marker := probe.Marker(); marker != "" {
+				fmt.Fprintf(&b, " %s", marker)
+			}
+			if probe.ICMP != nil && mpls == nil {
+				mpls = probe.ICMP.MPLS
+			}
+		}
+		b.WriteString("\n")
+		for _, label := range mpls {
+			fmt.Fprintf(&b, "     [MPLS: %s]\n", label)
+		}
+		writeStats(&b, hop.Stats)
+	}
+	if res.Multipath != nil {
+		writeLinks(&b, res.Multipath)
+	}
+	if res.Error != "" {
+		fmt.Fprintf(&b, "error: %s\n", res.Error)
+	}
+	_, err := io.WriteString(t.w, b.String())
+	return err
+}
+
+// writeStats writes the statistics of a hop below its probes, the RTTs are left out when no probe was answered.
+func writeStats(b *strings.Builder, stats methods.HopStats) {
+	if stats.Sent == 0 {
+		return
+	}
+	fmt.Fprintf(b, "     loss %.1f%%", stats.Loss)
+	if stats.Received > 0 {
+		fmt.Fprintf(b, ", rtt min/avg/median/max/stddev %.3f/%.3f/%.3f/%.3f/%.3f ms, jitter %.3f ms",
+			methods.Milliseconds(stats.Min), methods.Milliseconds(stats.Avg), methods.Milliseconds(stats.Median),
+			methods.Milliseconds(stats.Max), methods.Milliseconds(stats.StdDev), methods.Milliseconds(stats.Jitter))
+	}
+	b.WriteString("\n")
+}
+
+// writeLinks writes the links between the interfaces found by the multipath detection algorithm after the hops.
+func writeLinks(b *strings.Builder, mp *methods.MultipathResult) {
+	for _, link := range mp.Links {
+		fmt.Fprintf(b, "%2d %s -> %s\n", link.TTL, link.From, link.To)
+	}
+	fmt.Fprintf(b, "%d probes sent\n", mp.ProbesSent)
+}
+
+// milliseconds formats the RTT the same as the classic traceroute.
+func milliseconds(rtt time.Duration) string {
+	return fmt.Sprintf("%.3f ms", float64(rtt)/float64(time.Millisecond))
+}
+
+// jsonFormatter writes each result as a JSON document, JSONL is the same without indentation.
+type jsonFormatter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonFormatter) Format(res *methods.TraceResult) error {
+	return j.enc.Encode(res)
+}
+
+// csvFormatter writes a record per probe so the output can be loaded without flattening the hops.
+type csvFormatter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvFormatter) Format(res *methods.TraceResult) error {
+	if !c.header {
+		err := c.w.Write(csvHeader)
+		if err != nil {
+			return err
+		}
+		c.header = true
+	}
+	for _, hop := range res.Hops {
+		for i, probe := range hop.Probes {
+			err := c.w.Write(append(csvRecord(res, hop.TTL, i, probe), csvStats(hop.Stats)...))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// csvRecord returns the record for the i-th probe of the hop, the reply columns are empty for timeouts.
+func csvRecord(res *methods.TraceResult, ttl uint16, i int, probe methods.TracerouteHop) []string {
+	record := []string{
+		res.Destination,
+		res.IP.String(),
+		res.Protocol,
+		strconv.Itoa(res.Port),
+		res.StartTime.Format(time.RFC3339Nano),
+		strconv.Itoa(int(ttl)),
+		strconv.Itoa(i + 1),
+		"", "", "", "", "", "",
+		"", "", "",
+	}
+	if !probe.Success {
+		return record
+	}
+	record[7] = methods.AddrIP(probe.Address).String()
+	record[8] = probe.Hostname
+	record[9] = strconv.FormatFloat(float64(*probe.RTT)/float64(time.Millisecond), 'f', 3, 64)
+	if probe.ICMP != nil {
+		record[10] = strconv.Itoa(int(probe.ICMP.Type))
+		record[11] = strconv.Itoa(int(probe.ICMP.Code))
+		record[12] = probe.Marker()
+	}
+	if probe.AS != nil {
+		record[13] = strconv.FormatUint(uint64(probe.AS.ASN), 10)
+		record[14] = probe.AS.Prefix
+		record[15] = probe.AS.Org
+	}
+	return record
+}
+
+// csvStats returns the columns of the hop statistics that are repeated on every probe of the hop, the responders
+// are joined by a semicolon.
+func csvStats(stats methods.HopStats) []string {
+	record := []string{strconv.FormatFloat(stats.Loss, 'f', 1, 64), "", "", "", "", "", "", strings.Join(stats.Responders, ";")}
+	if stats.Received == 0 {
+		return record
+	}
+	for i, rtt := range []time.Duration{stats.Min, stats.Avg, stats.Median, stats.Max, stats.StdDev, stats.Jitter} {
+		record[i+1] = strconv.FormatFloat(methods.Milliseconds(rtt), 'f', 3, 64)
+	}
+	return record
+}
@@ -0,0 +1,140 @@
+package formatter
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+func testResult() *methods.TraceResult {
+	rtt := 1500 * time.Microsecond
+	as := &methods.ASInfo{ASN: 64500, Prefix: "10.0.0.0/8", Org: "Example Backbone"}
+	res := methods.NewTraceResult("example.com", net.ParseIP("192.0.2.1"), "udp", 33434)
+	res.StartTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	res.Hops = []methods.TraceHop{
+		{TTL: 1, Probes: []methods.TracerouteHop{
+			{Success: true, Address: &net.IPAddr{IP: net.ParseIP("10.0.0.1")}, Hostname: "router.example.net", TTL: 1, RTT: &rtt, ICMP: &methods.ICMPReply{Type: 11}, AS: as},
+			{Success: true, Address: &net.IPAddr{IP: net.ParseIP("10.0.0.1")}, Hostname: "router.example.net", TTL: 1, RTT: &rtt, ICMP: &methods.ICMPReply{Type: 11}, AS: as},
+			{Success: false, TTL: 1},
+		}},
+		{TTL: 2, Probes: []methods.TracerouteHop{
+			{Success: false, TTL: 2},
+			{Success: false, TTL: 2},
+			{Success: false, TTL: 2},
+		}},
+		{TTL: 3, Probes: []methods.TracerouteHop{
+			{Success: true, Address: &net.IPAddr{IP: net.ParseIP("10.0.2.1")}, TTL: 3, RTT: &rtt, ICMP: &methods.ICMPReply{Type: 3, Code: 1}},
+		}},
+	}
+	for i := range res.Hops {
+		res.Hops[i].Stats = methods.NewHopStats(res.Hops[i].Probes)
+	}
+	return res
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{
+			name:   "text",
+			format: Text,
+			want: "traceroute to example.com (192.0.2.1), udp port 33434\n" +
+				" 1  router.example.net (10.0.0.1) [AS64500]  1.500 ms  1.500 ms *\n" +
+				"     loss 33.3%, rtt min/avg/median/max/stddev 1.500/1.500/1.500/1.500/0.000 ms, jitter 0.000 ms\n" +
+				" 2  * * *\n" +
+				"     loss 100.0%\n" +
+				" 3  10.0.2.1 (10.0.2.1)  1.500 ms !H\n" +
+				"     loss 0.0%, rtt min/avg/median/max/stddev 1.500/1.500/1.500/1.500/0.000 ms, jitter 0.000 ms\n",
+		},
+		{
+			name:   "csv",
+			format: CSV,
+			want: "destination,ip,protocol,port,start_time,ttl,probe,address,hostname,rtt_ms,icmp_type,icmp_code,marker,asn,prefix,as_org," +
+				"hop_loss_pct,hop_min_ms,hop_avg_ms,hop_median_ms,hop_max_ms,hop_stddev_ms,hop_jitter_ms,hop_responders\n" +
+				"example.com,192.0.2.1,udp,33434,2024-01-02T03:04:05Z,1,1,10.0.0.1,router.example.net,1.500,11,0,,64500,10.0.0.0/8,Example Backbone,33.3,1.500,1.500,1.500,1.500,0.000,0.000,10.0.0.1\n" +
+				"example.com,192.0.2.1,udp,33434,2024-01-02T03:04:05Z,1,2,10.0.0.1,router.example.net,1.500,11,0,,64500,10.0.0.0/8,Example Backbone,33.3,1.500,1.500,1.500,1.500,0.000,0.000,10.0.0.1\n" +
+				"example.com,192.0.2.1,udp,33434,2024-01-02T03:04:05Z,1,3,,,,,,,,,,33.3,1.500,1.500,1.500,1.500,0.000,0.000,10.0.0.1\n" +
+				"example.com,192.0.2.1,udp,33434,2024-01-02T03:04:05Z,2,1,,,,,,,,,,100.0,,,,,,,\n" +
+				"example.com,192.0.2.1,udp,33434,2024-01-02T03:04:05Z,2,2,,,,,,,,,,100.0,,,,,,,\n" +
+				"example.com,192.0.2.1,udp,33434,2024-01-02T03:04:05Z,2,3,,,,,,,,,,100.0,,,,,,,\n" +
+				"example.com,192.0.2.1,udp,33434,2024-01-02T03:04:05Z,3,1,10.0.2.1,,1.500,3,1,!H,,,,0.0,1.500,1.500,1.500,1.500,0.000,0.000,10.0.2.1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := testResult()
+			var buf bytes.Buffer
+			f, err := New(tt.format, &buf)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			err = f.Format(res)
+			if err != nil {
+				t.Fatalf("Formatter.Format() error = %v", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("Formatter.Format() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatMultipath(t *testing.T) {
+	res := testResult()
+	res.Hops = res.Hops[:1]
+	res.Multipath = &methods.MultipathResult{
+		Links: []methods.MultipathLink{
+			{TTL: 1, From: net.ParseIP("10.0.0.1"), To: net.ParseIP("10.0.1.1")},
+			{TTL: 1, From: net.ParseIP("10.0.0.1"), To: net.ParseIP("10.0.1.2")},
+		},
+		ProbesSent: 12,
+	}
+	var buf bytes.Buffer
+	if err := (&text{w: &buf}).Format(res); err != nil {
+		t.Fatalf("text.Format() error = %v", err)
+	}
+	want := "traceroute to example.com (192.0.2.1), udp port 33434\n" +
+		" 1  router.example.net (10.0.0.1) [AS64500]  1.500 ms  1.500 ms *\n" +
+		"     loss 33.3%, rtt min/avg/median/max/stddev 1.500/1.500/1.500/1.500/0.000 ms, jitter 0.000 ms\n" +
+		" 1 10.0.0.1 -> 10.0.1.1\n" +
+		" 1 10.0.0.1 -> 10.0.1.2\n" +
+		"12 probes sent\n"
+	if got := buf.String(); got != want {
+		t.Errorf("text.Format() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatJSONL(t *testing.T) {
+	var buf bytes.Buffer
+	f, err := New(JSONL, &buf)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		err = f.Format(testResult())
+		if err != nil {
+			t.Fatalf("Formatter.Format() error = %v", err)
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Formatter.Format() lines = %d, want %d", len(lines), 2)
+	}
+	if !strings.HasPrefix(lines[0], `{"destination":"example.com","ip":"192.0.2.1"`) {
+		t.Errorf("Formatter.Format() = %s", lines[0])
+	}
+}
+
+func TestNewUnknownFormat(t *testing.T) {
+	_, err := New("xml", &bytes.Buffer{})
+	if err == nil {
+		t.Errorf("New() error = nil, want an error for an unknown format")
+	}
+}
@@ -0,0 +1,147 @@
+package formatter
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+// Live draws the hops of a traceroute as the probes complete, in the same layout as mtr.
+type Live struct {
+	w io.Writer
+	// redraw moves the cursor back over the previous table, it is only set when w is a terminal.
+	redraw bool
+
+	mu          sync.Mutex
+	destination string
+	ip          net.IP
+	protocol    string
+	hops        map[uint16]*liveHop
+	// last is the TTL of the first hop that replied from the destination or reported it unreachable.
+	last  uint16
+	lines int
+}
+
+// liveHop is the probes sent with a TTL so far, the statistics are computed from them when the table is drawn.
+type liveHop struct {
+	probes []methods.TracerouteHop
+	// last is the RTT of the latest reply.
+	last   time.Duration
+	marker string
+}
+
+// NewLive returns a live display writing to w, the table is redrawn after every probe when redraw is set,
+// otherwise it is written once when the traceroute finishes.
+func NewLive(w io.Writer, redraw bool) *Live {
+	return &Live{w: w, redraw: redraw}
+}
+
+// Start resets the display for the traceroute to the destination.
+func (l *Live) Start(destination string, ip net.IP, protocol string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.destination = destination
+	l.ip = ip
+	l.protocol = protocol
+	l.hops = map[uint16]*liveHop{}
+	l.last = 0
+	l.lines = 0
+}
+
+// Probe adds the result of a probe to its hop and redraws the table, it is used as the OnProbe callback of the
+// traceroute config.
+func (l *Live) Probe(probe methods.TracerouteHop) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hop, ok := l.hops[probe.TTL]
+	if !ok {
+		hop = &liveHop{}
+		l.hops[probe.TTL] = hop
+	}
+	hop.add(probe)
+	if probe.Success && (methods.AddrIP(probe.Address).Equal(l.ip) || probe.Fatal()) && (l.last == 0 || probe.TTL < l.last) {
+		l.last = probe.TTL
+	}
+	if l.redraw {
+		l.draw()
+	}
+}
+
+// Finish draws the final table of the traceroute.
+func (l *Live) Finish() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.draw()
+}
+
+func (h *liveHop) add(probe methods.TracerouteHop) {
+	h.probes = append(h.probes, probe)
+	if !probe.Success {
+		return
+	}
+	if probe.RTT != nil {
+		h.last = *probe.RTT
+	}
+	if marker := probe.Marker(); marker != "" {
+		h.marker = marker
+	}
+}
+
+// draw writes the table, the previous table is overwritten when the display redraws.
+func (l *Live) draw() {
+	var b strings.Builder
+	// the cursor is moved to the start of the previous table and the screen below it is cleared, the table
+	// gets shorter when the destination replies to a lower TTL.
+	if l.redraw && l.lines > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA\x1b[J", l.lines)
+	}
+	lines := 0
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format, args...)
+		lines++
+	}
+	line("traceroute to %s (%s), %s\n", l.destination, l.ip, l.protocol)
+	line("%-3s %-40s %6s %4s %7s %7s %7s %7s %7s\n", "", "Host", "Loss%", "Snt", "Last", "Avg", "Best", "Wrst", "StDev")
+
+	ttls := make([]int, 0, len(l.hops))
+	for ttl := range l.hops {
+		// the hops after the destination only echo its replies.
+		if l.last != 0 && ttl > l.last {
+			continue
+		}
+		ttls = append(ttls, int(ttl))
+	}
+	sort.Ints(ttls)
+	for _, ttl := range ttls {
+		hop := l.hops[uint16(ttl)]
+		stats := methods.NewHopStats(hop.probes)
+		if stats.Received == 0 {
+			line("%2d. %-40s %5.1f%% %4d\n", ttl, "???", 100.0, stats.Sent)
+			continue
+		}
+		host := stats.Responders[0]
+		if hop.marker != "" {
+			host = fmt.Sprintf("%s %s", host, hop.marker)
+		}
+		line("%2d. %-40s %5.1f%% %4d %7s %7s %7s %7s %7s\n", ttl, host, stats.Loss, stats.Sent,
+			liveMs(hop.last), liveMs(stats.Avg), liveMs(stats.Min), liveMs(stats.Max), liveMs(stats.StdDev))
+		// the other interfaces that answered the hop are listed below the first one.
+		for _, addr := range stats.Responders[1:] {
+			line("    %s\n", addr)
+		}
+	}
+	l.lines = lines
+	// the display is best effort, a failed write is not worth stopping the traceroute for.
+	_, _ = io.WriteString(l.w, b.String())
+}
+
+// liveMs formats the RTT with one decimal as mtr does.
+func liveMs(rtt time.Duration) string {
+	return fmt.Sprintf("%.1f", float64(rtt)/float64(time.Millisecond))
+}
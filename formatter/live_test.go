@@ -0,0 +1,79 @@
+package formatter
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+func TestLive(t *testing.T) {
+	fast := 1 * time.Millisecond
+	slow := 3 * time.Millisecond
+	router := &net.IPAddr{IP: net.ParseIP("10.0.0.1")}
+	dest := &net.IPAddr{IP: net.ParseIP("192.0.2.1")}
+	probes := []methods.TracerouteHop{
+		{Success: true, Address: router, TTL: 1, RTT: &fast, ICMP: &methods.ICMPReply{Type: 11}},
+		{Success: false, TTL: 2},
+		{Success: true, Address: dest, TTL: 3, RTT: &slow, ICMP: &methods.ICMPReply{Type: 3, Code: 3}},
+		// the TTL after the destination is not drawn.
+		{Success: true, Address: dest, TTL: 4, RTT: &slow, ICMP: &methods.ICMPReply{Type: 3, Code: 3}},
+		{Success: true, Address: router, TTL: 1, RTT: &slow, ICMP: &methods.ICMPReply{Type: 11}},
+		{Success: false, TTL: 1},
+	}
+	want := "traceroute to example.com (192.0.2.1), udp\n" +
+		"    Host                                      Loss%  Snt    Last     Avg    Best    Wrst   StDev\n" +
+		" 1. 10.0.0.1                                  33.3%    3     3.0     2.0     1.0     3.0     1.0\n" +
+		" 2. ???                                      100.0%    1\n" +
+		" 3. 192.0.2.1                                  0.0%    1     3.0     3.0     3.0     3.0     0.0\n"
+
+	t.Run("finish", func(t *testing.T) {
+		var b bytes.Buffer
+		live := NewLive(&b, false)
+		live.Start("example.com", net.ParseIP("192.0.2.1"), "udp")
+		for _, probe := range probes {
+			live.Probe(probe)
+		}
+		if b.Len() != 0 {
+			t.Errorf("Live.Probe() wrote %q without redraw", b.String())
+		}
+		live.Finish()
+		if got := b.String(); got != want {
+			t.Errorf("Live.Finish() = \n%s\nwant\n%s", got, want)
+		}
+	})
+
+	t.Run("redraw", func(t *testing.T) {
+		var b bytes.Buffer
+		live := NewLive(&b, true)
+		live.Start("example.com", net.ParseIP("192.0.2.1"), "udp")
+		for _, probe := range probes {
+			live.Probe(probe)
+		}
+		live.Finish()
+		// the first table is drawn as is, every later one clears the previous table.
+		if got := strings.Count(b.String(), "\x1b[J"); got != len(probes) {
+			t.Errorf("Live cleared %d tables, want %d", got, len(probes))
+		}
+		// the last table moves the cursor back over the five lines of the previous one.
+		if got := b.String(); !strings.HasSuffix(got, "\x1b[5A\x1b[J"+want) {
+			t.Errorf("Live.Finish() = %q, want the previous table overwritten", got)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		var b bytes.Buffer
+		live := NewLive(&b, false)
+		live.Start("example.com", net.ParseIP("192.0.2.1"), "udp")
+		live.Probe(methods.TracerouteHop{Success: true, Address: router, TTL: 2, RTT: &fast, ICMP: &methods.ICMPReply{Type: 3, Code: 1}})
+		live.Probe(methods.TracerouteHop{Success: false, TTL: 3})
+		live.Finish()
+		got := b.String()
+		if !strings.Contains(got, " 2. 10.0.0.1 !H") || strings.Contains(got, " 3. ") {
+			t.Errorf("Live.Finish() = \n%s\nwant the hops to stop at the unreachable", got)
+		}
+	})
+}
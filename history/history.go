@@ -0,0 +1,155 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+const (
+	// fileSuffix is the extension of the files holding the results of each destination.
+	fileSuffix string = ".json"
+	// fileMode is used for the results written to disk.
+	fileMode os.FileMode = 0o600
+	dirMode  os.FileMode = 0o750
+)
+
+// fileNameReplacer removes the separator of the key and the IPv6 colons from the file names.
+var fileNameReplacer = strings.NewReplacer("/", "_", ":", "_")
+
+// Store keeps the last results of the traceroutes to each destination IP and protocol, when a directory
+// is set the results are also written to disk so they survive a restart.
+type Store struct {
+	mutex   sync.Mutex
+	size    int
+	dir     string
+	results map[string][]*methods.TraceResult
+	changed func(*methods.PathChange)
+}
+
+// New returns a store that keeps size results per destination, the results already in dir are loaded.
+// changed is called for every path change, dir and changed are optional.
+func New(size int, dir string, changed func(*methods.PathChange)) (*Store, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("history size %d must be at least 1", size)
+	}
+	s := &Store{
+		size:    size,
+		dir:     dir,
+		results: map[string][]*methods.TraceResult{},
+		changed: changed,
+	}
+	if dir == "" {
+		return s, nil
+	}
+	err := os.MkdirAll(dir, dirMode)
+	if err != nil {
+		return nil, err
+	}
+	err = s.load()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Key returns the key of the results to the same destination IP with the same protocol.
+func Key(res *methods.TraceResult) string {
+	return fmt.Sprintf("%s/%s", res.Protocol, res.IP)
+}
+
+// Record adds the result and compares its path with the last traceroute to the destination that did not
+// fail, it returns nil when the path did not change. The change is returned even if writing to disk failed.
+func (s *Store) Record(res *methods.TraceResult) (*methods.PathChange, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := Key(res)
+	var change *methods.PathChange
+	if previous := last(s.results[key]); previous != nil && hasPath(res) {
+		change = methods.DiffPath(previous, res)
+	}
+	results := append(s.results[key], res)
+	if len(results) > s.size {
+		results = results[len(results)-s.size:]
+	}
+	s.results[key] = results
+
+	if change != nil && s.changed != nil {
+		s.changed(change)
+	}
+	return change, s.save(key, results)
+}
+
+// Results returns the stored results to the destination, the oldest first.
+func (s *Store) Results(key string) []*methods.TraceResult {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*methods.TraceResult{}, s.results[key]...)
+}
+
+// hasPath returns true when the result has a path that can be compared.
+func hasPath(res *methods.TraceResult) bool {
+	return res.Error == "" && len(res.Hops) > 0
+}
+
+// last returns the most recent result that has a path.
+func last(results []*methods.TraceResult) *methods.TraceResult {
+	for i := len(results) - 1; i >= 0; i-- {
+		if hasPath(results[i]) {
+			return results[i]
+		}
+	}
+	return nil
+}
+
+// save replaces the file of the destination with the results, the file is renamed into place so a crash
+// does not leave it truncated.
+func (s *Store) save(key string, results []*methods.TraceResult) error {
+	if s.dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(s.dir, fileNameReplacer.Replace(key)+fileSuffix)
+	tmp := file + ".tmp"
+	err = os.WriteFile(tmp, data, fileMode)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// load reads the results of every destination in the directory.
+func (s *Store) load() error {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+fileSuffix))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var results []*methods.TraceResult
+		err = json.Unmarshal(data, &results)
+		if err != nil {
+			return fmt.Errorf("unable to load history %s: %w", file, err)
+		}
+		if len(results) == 0 {
+			continue
+		}
+		if len(results) > s.size {
+			results = results[len(results)-s.size:]
+		}
+		s.results[Key(results[0])] = results
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package history
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+func testResult(start time.Time, ip string, hops ...string) *methods.TraceResult {
+	res := &methods.TraceResult{Destination: "example.com", IP: net.ParseIP(ip), Protocol: "icmp", StartTime: start}
+	rtt := time.Millisecond
+	for i, addr := range hops {
+		res.Hops = append(res.Hops, methods.TraceHop{
+			TTL: uint16(i + 1),
+			Probes: []methods.TracerouteHop{{
+				Success: true,
+				Address: &net.IPAddr{IP: net.ParseIP(addr)},
+				TTL:     uint16(i + 1),
+				RTT:     &rtt,
+			}},
+		})
+	}
+	return res
+}
+
+func TestStoreRecord(t *testing.T) {
+	var changes []*methods.PathChange
+	s, err := New(2, "", func(change *methods.PathChange) {
+		changes = append(changes, change)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	failed := testResult(start.Add(2*time.Minute), "2001:db8::1")
+	failed.Error = "timeout"
+
+	steps := []struct {
+		res     *methods.TraceResult
+		changed bool
+	}{
+		{res: testResult(start, "2001:db8::1", "2001:db8:1::1", "2001:db8::1")},
+		{res: testResult(start.Add(time.Minute), "2001:db8::1", "2001:db8:1::1", "2001:db8::1")},
+		// a failed traceroute is neither compared nor used for the next comparison.
+		{res: failed},
+		{res: testResult(start.Add(3*time.Minute), "2001:db8::1", "2001:db8:2::1", "2001:db8::1"), changed: true},
+		// other destinations have their own history.
+		{res: testResult(start.Add(4*time.Minute), "192.0.2.1", "10.0.0.1", "192.0.2.1")},
+	}
+	for i, step := range steps {
+		change, err := s.Record(step.res)
+		if err != nil {
+			t.Fatalf("step %d: Store.Record() error = %v", i, err)
+		}
+		if (change != nil) != step.changed {
+			t.Fatalf("step %d: Store.Record() = %+v, want changed %v", i, change, step.changed)
+		}
+	}
+	if len(changes) != 1 {
+		t.Fatalf("changed was called %d times, want 1", len(changes))
+	}
+	if want := start.Add(time.Minute); !changes[0].LastSeen.Equal(want) {
+		t.Errorf("PathChange.LastSeen = %v, want %v", changes[0].LastSeen, want)
+	}
+	if got := s.Results("icmp/2001:db8::1"); len(got) != 2 || got[0] != failed {
+		t.Errorf("Store.Results() kept %d results, want the last 2", len(got))
+	}
+}
+
+func TestStoreDirectory(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s, err := New(5, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Record(testResult(start, "2001:db8::1", "2001:db8:1::1", "2001:db8::1"))
+	if err != nil {
+		t.Fatalf("Store.Record() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "icmp_2001_db8__1.json")); err != nil {
+		t.Fatalf("history was not written to disk: %v", err)
+	}
+
+	// a new store picks up where the previous one stopped.
+	s, err = New(5, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change, err := s.Record(testResult(start.Add(time.Minute), "2001:db8::1", "2001:db8:2::1", "2001:db8::1"))
+	if err != nil {
+		t.Fatalf("Store.Record() error = %v", err)
+	}
+	if change == nil || len(change.Hops) != 1 || change.Hops[0].Previous != "2001:db8:1::1" {
+		t.Errorf("Store.Record() after reload = %+v, want hop 1 changed", change)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(5, dir, nil); err == nil {
+		t.Errorf("New() with a corrupt history file did not return an error")
+	}
+}
+
+func TestNewSize(t *testing.T) {
+	if _, err := New(0, "", nil); err == nil {
+		t.Errorf("New() with size 0 did not return an error")
+	}
+}
@@ -13,6 +13,8 @@ type ReceivedMessage struct {
 	Peer net.Addr
 	Msg  []byte
 	Err  error
+	// Received is the time the message was read from the connection.
+	Received time.Time
 }
 
 type ListenerChannel struct {
@@ -40,21 +42,30 @@ func (l *ListenerChannel) Start() {
 		reply := make([]byte, 1500)
 		err := l.Conn.SetReadDeadline(time.Now().Add(2 * time.Second))
 		if err != nil {
-			l.Messages <- ReceivedMessage{Err: err}
+			l.send(ReceivedMessage{Err: err})
 			continue
 		}
 
 		n, peer, err := l.Conn.ReadFrom(reply)
 		if err != nil {
-			l.Messages <- ReceivedMessage{Err: err}
+			l.send(ReceivedMessage{Err: err})
 			continue
 		}
-		l.Messages <- ReceivedMessage{
-			N:    &n,
-			Peer: peer,
-			Err:  nil,
-			Msg:  reply,
-		}
+		l.send(ReceivedMessage{
+			N:        &n,
+			Peer:     peer,
+			Err:      nil,
+			Msg:      reply,
+			Received: time.Now(),
+		})
+	}
+}
+
+// send does not block once the listener is stopped and nothing reads the messages anymore.
+func (l *ListenerChannel) send(msg ReceivedMessage) {
+	select {
+	case l.Messages <- msg:
+	case <-l.ctx.Done():
 	}
 }
 
@@ -13,6 +13,7 @@ import (
 var cli struct {
 	UDP      trace.CLI   `cmd:"" help:"UDP traceroute."`
 	TCP      trace.CLI   `cmd:"" help:"TCP traceroute"`
+	ICMP     trace.CLI   `cmd:"" help:"ICMP Echo traceroute"`
 	Service  service.CLI `cmd:"" help:"Run as a service"`
 	Generate config.CLI  `cmd:"" help:"Generate a configuration file and print to stdout to run this as a service"`
 }
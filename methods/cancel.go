@@ -0,0 +1,32 @@
+package methods
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StatusCancelled is the status description of the spans of the traceroutes and probes stopped by their context.
+const StatusCancelled string = "cancelled"
+
+// Cancelled reports whether the error is the cancellation or deadline of a context.
+func Cancelled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// SetSpanStatus sets the status of a span from the error that stopped the traceroute or probe, the spans stopped
+// by their context are marked as cancelled.
+func SetSpanStatus(span trace.Span, err error) {
+	switch {
+	case err == nil:
+		span.SetStatus(codes.Ok, "success")
+	case Cancelled(err):
+		span.RecordError(err)
+		span.SetStatus(codes.Error, StatusCancelled)
+	default:
+		span.SetStatus(codes.Error, fmt.Sprintf("%s", err))
+	}
+}
@@ -0,0 +1,59 @@
+package methods
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetSpanStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantCode      codes.Code
+		wantStatus    string
+		wantEvents    int
+		wantCancelled bool
+	}{
+		{name: "success", err: nil, wantCode: codes.Ok, wantStatus: ""},
+		{name: "failure", err: errors.New("network is unreachable"), wantCode: codes.Error, wantStatus: "network is unreachable"},
+		{name: "cancelled", err: context.Canceled, wantCode: codes.Error, wantStatus: StatusCancelled, wantEvents: 1, wantCancelled: true},
+		{
+			name:          "deadline",
+			err:           fmt.Errorf("tracing: %w", context.DeadlineExceeded),
+			wantCode:      codes.Error,
+			wantStatus:    StatusCancelled,
+			wantEvents:    1,
+			wantCancelled: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			_, span := provider.Tracer("test").Start(context.Background(), "traceroute")
+			SetSpanStatus(span, tt.err)
+			span.End()
+
+			if got := Cancelled(tt.err); got != tt.wantCancelled {
+				t.Errorf("Cancelled() = %v, want %v", got, tt.wantCancelled)
+			}
+			ended := recorder.Ended()
+			if len(ended) != 1 {
+				t.Fatalf("%d spans ended, want 1", len(ended))
+			}
+			// the description of an Ok status is dropped by the SDK.
+			if got := ended[0].Status(); got.Code != tt.wantCode || got.Description != tt.wantStatus {
+				t.Errorf("SetSpanStatus() status = %+v, want %v %q", got, tt.wantCode, tt.wantStatus)
+			}
+			if got := len(ended[0].Events()); got != tt.wantEvents {
+				t.Errorf("SetSpanStatus() recorded %d events, want %d", got, tt.wantEvents)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+// Package demux shares one raw ICMP socket per address family between the traceroutes that run at the same time.
+// Each ICMP message is read and parsed once and handed to the traceroute that registered the key of the probe.
+package demux
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/jimmystewpot/traceroute/listener_channel"
+	"github.com/jimmystewpot/traceroute/methods"
+	"golang.org/x/net/icmp"
+)
+
+const (
+	// transportHeaderLength is the part of the transport header of the probe that is quoted by every ICMP error.
+	transportHeaderLength int = 8
+	minIPHeaderLength     int = 20
+)
+
+// ErrRegistered is returned when the key of a probe is already registered by another probe.
+var ErrRegistered = errors.New("the probe key is already registered")
+
+// Protocol is the protocol of the probes.
+type Protocol uint8
+
+const (
+	UDP  Protocol = Protocol(layers.IPProtocolUDP)
+	TCP  Protocol = Protocol(layers.IPProtocolTCP)
+	ICMP Protocol = Protocol(layers.IPProtocolICMPv4)
+)
+
+// Key identifies the probes an ICMP message is a reply to.
+type Key struct {
+	Protocol Protocol
+	// ID is the source port of a UDP probe, the sequence number of a TCP probe or the identifier of an ICMP
+	// echo request.
+	ID uint32
+}
+
+// UDPKey returns the key of the UDP probes sent from the source port.
+func UDPKey(srcPort uint16) Key {
+	return Key{Protocol: UDP, ID: uint32(srcPort)}
+}
+
+// TCPKey returns the key of the TCP probe with the sequence number.
+func TCPKey(seq uint32) Key {
+	return Key{Protocol: TCP, ID: seq}
+}
+
+// EchoKey returns the key of the ICMP echo requests with the identifier.
+func EchoKey(id uint16) Key {
+	return Key{Protocol: ICMP, ID: uint32(id)}
+}
+
+// Message is an ICMP message received in reply to a registered probe.
+type Message struct {
+	Peer net.Addr
+	ICMP *icmp.Message
+	// Header is the transport header of the probe quoted by an ICMP error or the header of an echo reply, it
+	// holds at least 8 bytes.
+	Header []byte
+	// Dst is the destination of the probe quoted by an ICMP error, it is nil for an echo reply.
+	Dst net.IP
+	// Received is the time the message was read from the socket.
+	Received time.Time
+}
+
+// Handler is called with the messages for the key it is registered for, it is called by the receiver so it
+// must not block.
+type Handler func(msg Message)
+
+// Receiver reads the ICMP messages of an address family and dispatches them to the handlers of the probes.
+type Receiver struct {
+	key   receiverKey
+	conn  net.PacketConn
+	proto int
+	lc    *listener_channel.ListenerChannel
+	stop  chan struct{}
+	// refs is the number of traceroutes using the receiver, it is protected by the receivers lock.
+	refs int
+
+	handlersMu sync.RWMutex
+	handlers   map[Key]Handler
+
+	// sendMu keeps the TTL set on the socket until the message is sent.
+	sendMu sync.Mutex
+}
+
+// receiverKey identifies the receiver of an address family on a network.
+type receiverKey struct {
+	network methods.Network
+	family  int
+}
+
+var (
+	receiversMu sync.Mutex
+	receivers   = map[receiverKey]*Receiver{}
+)
+
+// Listen returns the receiver for the address family of the destination on the network, the receiver is opened
+// by the first traceroute and closed when the last traceroute releases it.
+func Listen(network methods.Network, destIP net.IP) (*Receiver, error) {
+	key := receiverKey{network: network, family: 4}
+	if methods.IsIPv6(destIP) {
+		key.family = 6
+	}
+	receiversMu.Lock()
+	defer receiversMu.Unlock()
+	if r, ok := receivers[key]; ok {
+		r.refs++
+		return r, nil
+	}
+	conn, proto, err := network.ListenICMP(destIP)
+	if err != nil {
+		return nil, err
+	}
+	r := newReceiver(key, conn, proto)
+	r.refs = 1
+	r.lc = listener_channel.New(conn)
+	go r.lc.Start()
+	go r.receive()
+	receivers[key] = r
+	return r, nil
+}
+
+func newReceiver(key receiverKey, conn net.PacketConn, proto int) *Receiver {
+	return &Receiver{
+		key:      key,
+		conn:     conn,
+		proto:    proto,
+		stop:     make(chan struct{}),
+		handlers: map[Key]Handler{},
+	}
+}
+
+// Release is called by a traceroute when it no longer uses the receiver, the socket is closed when it was the
+// last traceroute.
+func (r *Receiver) Release() {
+	receiversMu.Lock()
+	defer receiversMu.Unlock()
+	r.refs--
+	if r.refs > 0 {
+		return
+	}
+	delete(receivers, r.key)
+	close(r.stop)
+	r.lc.Stop()
+	r.conn.Close()
+}
+
+// Register dispatches the messages for the key to the handler until the key is unregistered, it returns
+// ErrRegistered when the key is used by another probe.
+func (r *Receiver) Register(key Key, handler Handler) error {
+	r.handlersMu.Lock()
+	defer r.handlersMu.Unlock()
+	if _, ok := r.handlers[key]; ok {
+		return ErrRegistered
+	}
+	r.handlers[key] = handler
+	return nil
+}
+
+// Unregister stops dispatching the messages for the key.
+func (r *Receiver) Unregister(key Key) {
+	r.handlersMu.Lock()
+	defer r.handlersMu.Unlock()
+	delete(r.handlers, key)
+}
+
+// Send sets the TTL on the socket of the receiver and calls send to write the probe, the traceroutes sharing
+// the socket send one at a time so the TTL is not changed before the probe is written.
+func (r *Receiver) Send(destIP net.IP, ttl int, send func(conn net.PacketConn) error) error {
+	r.sendMu.Lock()
+	defer r.sendMu.Unlock()
+	err := methods.SetTTL(r.conn, destIP, ttl)
+	if err != nil {
+		return err
+	}
+	return send(r.conn)
+}
+
+func (r *Receiver) receive() {
+	for {
+		select {
+		case <-r.stop:
+			return
+		case msg := <-r.lc.Messages:
+			if msg.N == nil {
+				continue
+			}
+			r.dispatch(msg)
+		}
+	}
+}
+
+// dispatch parses the message and calls the handler registered for the key of the probe, the messages that
+// are not a reply to a registered probe are dropped.
+func (r *Receiver) dispatch(received listener_channel.ReceivedMessage) {
+	data := received.Msg[:*received.N]
+	rm, err := icmp.ParseMessage(r.proto, data)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	msg := Message{
+		Peer:     received.Peer,
+		ICMP:     rm,
+		Received: received.Received,
+	}
+	var (
+		key Key
+		ok  bool
+	)
+	switch {
+	case methods.IsEchoReply(rm.Type):
+		key, msg.Header, ok = echoKey(data)
+	case methods.IsTimeExceeded(rm.Type):
+		key, msg.Header, msg.Dst, ok = quotedKey(rm.Body.(*icmp.TimeExceeded).Data)
+	case methods.IsDestinationUnreachable(rm.Type):
+		key, msg.Header, msg.Dst, ok = quotedKey(rm.Body.(*icmp.DstUnreach).Data)
+	}
+	if !ok {
+		return
+	}
+
+	r.handlersMu.RLock()
+	handler, ok := r.handlers[key]
+	r.handlersMu.RUnlock()
+	if ok {
+		handler(msg)
+	}
+}
+
+// echoKey returns the key of an echo reply, the header is the echo reply header.
+func echoKey(data []byte) (Key, []byte, bool) {
+	if len(data) < transportHeaderLength {
+		return Key{}, nil, false
+	}
+	return EchoKey(binary.BigEndian.Uint16(data[4:6])), data, true
+}
+
+// quotedKey returns the key, the transport header and the destination of the probe from the IP header and the
+// transport header quoted by an ICMP error.
+func quotedKey(data []byte) (Key, []byte, net.IP, bool) {
+	header, err := methods.GetICMPResponsePayload(data)
+	if err != nil || len(data)-len(header) < minIPHeaderLength || len(header) < transportHeaderLength {
+		return Key{}, nil, nil, false
+	}
+	// the protocol is the IPv4 protocol or the next header of the IPv6 header, extension headers are not used by
+	// the probes.
+	protocol := layers.IPProtocol(data[9])
+	dst := net.IP(data[16:20])
+	if data[0]>>4 == 6 {
+		protocol = layers.IPProtocol(data[6])
+		dst = net.IP(data[24:40])
+	}
+	switch protocol {
+	case layers.IPProtocolUDP:
+		return UDPKey(methods.GetUDPSrcPort(header)), header, dst, true
+	case layers.IPProtocolTCP:
+		return TCPKey(methods.GetTCPSeq(header)), header, dst, true
+	case layers.IPProtocolICMPv4, layers.IPProtocolICMPv6:
+		id, _, _ := methods.GetICMPEchoIDSeq(header)
+		return EchoKey(id), header, dst, true
+	}
+	return Key{}, nil, nil, false
+}
@@ -0,0 +1,150 @@
+package demux
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/jimmystewpot/traceroute/listener_channel"
+	"github.com/jimmystewpot/traceroute/methods"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// quote returns an IPv4 header followed by the transport header of a probe to 192.0.2.1, as quoted by an ICMP
+// error.
+func quote(protocol layers.IPProtocol, transport []byte) []byte {
+	header := make([]byte, 20, 20+len(transport))
+	header[0] = 0x45
+	header[9] = byte(protocol)
+	copy(header[16:20], net.ParseIP("192.0.2.1").To4())
+	return append(header, transport...)
+}
+
+func received(t *testing.T, msg icmp.Message) listener_channel.ReceivedMessage {
+	t.Helper()
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len(data)
+	return listener_channel.ReceivedMessage{
+		N:        &n,
+		Peer:     &net.IPAddr{IP: net.ParseIP("192.0.2.1")},
+		Msg:      data,
+		Received: time.Now(),
+	}
+}
+
+func TestReceiverDispatch(t *testing.T) {
+	udp := make([]byte, 8)
+	binary.BigEndian.PutUint16(udp[0:2], 40000)
+	tcp := make([]byte, 8)
+	binary.BigEndian.PutUint32(tcp[4:8], 123456789)
+	echo := make([]byte, 8)
+	binary.BigEndian.PutUint16(echo[4:6], 77)
+	binary.BigEndian.PutUint16(echo[6:8], 3)
+
+	tests := []struct {
+		name    string
+		msg     icmp.Message
+		want    Key
+		wantSeq uint16
+	}{
+		{
+			name: "time exceeded udp",
+			msg:  icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: quote(layers.IPProtocolUDP, udp)}},
+			want: UDPKey(40000),
+		},
+		{
+			name: "port unreachable tcp",
+			msg:  icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 3, Body: &icmp.DstUnreach{Data: quote(layers.IPProtocolTCP, tcp)}},
+			want: TCPKey(123456789),
+		},
+		{
+			name:    "time exceeded echo request",
+			msg:     icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: quote(layers.IPProtocolICMPv4, echo)}},
+			want:    EchoKey(77),
+			wantSeq: 3,
+		},
+		{
+			name:    "echo reply",
+			msg:     icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 77, Seq: 3}},
+			want:    EchoKey(77),
+			wantSeq: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReceiver(receiverKey{network: methods.System, family: 4}, nil, methods.ProtocolICMP)
+			got := map[Key]Message{}
+			for _, key := range []Key{UDPKey(40000), TCPKey(123456789), EchoKey(77)} {
+				key := key
+				if err := r.Register(key, func(msg Message) { got[key] = msg }); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r.dispatch(received(t, tt.msg))
+
+			if len(got) != 1 {
+				t.Fatalf("Receiver.dispatch() called %d handlers, want 1", len(got))
+			}
+			msg, ok := got[tt.want]
+			if !ok {
+				t.Fatalf("Receiver.dispatch() did not call the handler of %+v", tt.want)
+			}
+			if len(msg.Header) < transportHeaderLength {
+				t.Fatalf("Receiver.dispatch() header = %v", msg.Header)
+			}
+			wantDst := net.ParseIP("192.0.2.1")
+			// an echo reply does not quote the destination.
+			if methods.IsEchoReply(tt.msg.Type) {
+				wantDst = nil
+			}
+			if !msg.Dst.Equal(wantDst) {
+				t.Errorf("Receiver.dispatch() destination = %v, want %v", msg.Dst, wantDst)
+			}
+			if tt.want.Protocol == ICMP {
+				if _, seq, _ := methods.GetICMPEchoIDSeq(msg.Header); seq != tt.wantSeq {
+					t.Errorf("Receiver.dispatch() echo sequence = %d, want %d", seq, tt.wantSeq)
+				}
+			}
+		})
+	}
+}
+
+func TestReceiverRegister(t *testing.T) {
+	r := newReceiver(receiverKey{network: methods.System, family: 4}, nil, methods.ProtocolICMP)
+	calls := 0
+	handler := func(Message) { calls++ }
+	if err := r.Register(UDPKey(40000), handler); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(UDPKey(40000), handler); !errors.Is(err, ErrRegistered) {
+		t.Errorf("Receiver.Register() error = %v, want %v", err, ErrRegistered)
+	}
+	// the same number is a different key for another protocol.
+	if err := r.Register(TCPKey(40000), handler); err != nil {
+		t.Errorf("Receiver.Register() error = %v", err)
+	}
+
+	udp := make([]byte, 8)
+	binary.BigEndian.PutUint16(udp[0:2], 40000)
+	msg := received(t, icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: quote(layers.IPProtocolUDP, udp)}})
+	r.dispatch(msg)
+	r.Unregister(UDPKey(40000))
+	r.dispatch(msg)
+	if calls != 1 {
+		t.Errorf("the handler was called %d times, want 1", calls)
+	}
+
+	// a truncated quote is dropped.
+	short := received(t, icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: quote(layers.IPProtocolTCP, udp[:4])}})
+	r.dispatch(short)
+	if calls != 1 {
+		t.Errorf("the handler was called for a truncated quote")
+	}
+}
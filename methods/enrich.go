@@ -0,0 +1,56 @@
+package methods
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ASInfo is the autonomous system that originates the prefix covering a hop address.
+type ASInfo struct {
+	ASN    uint32 `json:"asn"`
+	Prefix string `json:"prefix"`
+	Org    string `json:"org,omitempty"`
+}
+
+// Enricher adds details about the hop addresses to the result once the probes are reduced to the final hops.
+type Enricher interface {
+	Enrich(ctx context.Context, res *TraceResult)
+}
+
+// EnrichResult runs the enricher on the result and adds an event to the span for every responding address of each hop.
+func EnrichResult(ctx context.Context, enricher Enricher, res *TraceResult, span trace.Span) {
+	if enricher == nil {
+		return
+	}
+	enricher.Enrich(ctx, res)
+	for _, hop := range res.Hops {
+		seen := map[string]bool{}
+		for _, probe := range hop.Probes {
+			if !probe.Success || probe.Address == nil {
+				continue
+			}
+			addr := AddrIP(probe.Address).String()
+			if seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			attrs := []attribute.KeyValue{
+				attribute.Int64("ttl", int64(hop.TTL)),
+				attribute.String("hop", addr),
+			}
+			if probe.Hostname != "" {
+				attrs = append(attrs, attribute.String("hostname", probe.Hostname))
+			}
+			if probe.AS != nil {
+				attrs = append(attrs,
+					attribute.Int64("asn", int64(probe.AS.ASN)),
+					attribute.String("prefix", probe.AS.Prefix),
+					attribute.String("as_org", probe.AS.Org),
+				)
+			}
+			span.AddEvent("hop", trace.WithAttributes(attrs...))
+		}
+	}
+}
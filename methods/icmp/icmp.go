@@ -0,0 +1,427 @@
+package icmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+	"github.com/jimmystewpot/traceroute/methods/demux"
+	"github.com/jimmystewpot/traceroute/parallel_limiter"
+	"github.com/jimmystewpot/traceroute/signal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const protocol string = "icmp"
+
+var payload = []byte("jimmystewpot/traceroute icmp echo")
+
+type inflightData struct {
+	start     time.Time
+	ttl       uint16
+	childSpan trace.Span
+	// reply receives the reply or timeout of the probe, it is waited for by the goroutine that sent the probe.
+	reply chan<- methods.TracerouteHop
+}
+
+type results struct {
+	inflightRequests sync.Map
+
+	results   map[uint16][]methods.TracerouteHop
+	resultsMu sync.Mutex
+	// err is the first error of the probes, it is set under resultsMu.
+	err error
+	// tracker counts the replies that are not recorded.
+	tracker *methods.ProbeTracker
+
+	concurrentRequests *parallel_limiter.ParallelLimiter
+	reachedFinalHop    *signal.Signal
+}
+
+type Traceroute struct {
+	opConfig    opConfig
+	trcrtConfig methods.TracerouteConfig
+	results     results
+}
+
+type opConfig struct {
+	// receiver sends the echo requests and dispatches the replies, it is shared with the other traceroutes.
+	receiver *demux.Receiver
+
+	destIP net.IP
+
+	// id identifies the echo requests of this traceroute, seq identifies each probe.
+	id    uint16
+	seq   uint16
+	seqMu sync.Mutex
+
+	wg *sync.WaitGroup
+
+	// spans starts the spans of the probes in the layout of the config.
+	spans *methods.ProbeSpans
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// stop ends the timeout loop once every probe has completed.
+	stop chan struct{}
+}
+
+//nolint:gocritic // config is large and required
+func New(destIP net.IP, config methods.TracerouteConfig) *Traceroute {
+	if config.Network == nil {
+		config.Network = methods.System
+	}
+	return &Traceroute{
+		opConfig: opConfig{
+			destIP: destIP,
+		},
+		trcrtConfig: config,
+	}
+}
+
+func (tr *Traceroute) Start() (*methods.TraceResult, error) {
+	return tr.StartContext(context.Background())
+}
+
+// StartContext runs the traceroute until it completes or the context is done. Once the context is done no more
+// probes are sent, the probes in flight are cancelled and the partial result is returned with the context error.
+func (tr *Traceroute) StartContext(ctx context.Context) (*methods.TraceResult, error) {
+	tr.opConfig.ctx, tr.opConfig.cancel = context.WithCancel(ctx)
+	tr.opConfig.stop = make(chan struct{})
+
+	var err error
+	tr.opConfig.receiver, err = demux.Listen(tr.trcrtConfig.Network, tr.opConfig.destIP)
+	if err != nil {
+		return nil, err
+	}
+
+	// the identifier is drawn again when another traceroute sharing the receiver uses it.
+	for {
+		//nolint:gosec // not cryptographic, only needs to differ between concurrent traceroutes.
+		tr.opConfig.id = uint16(rand.Intn(0xffff))
+		if tr.opConfig.receiver.Register(demux.EchoKey(tr.opConfig.id), tr.handleMessage) == nil {
+			break
+		}
+	}
+
+	var wg sync.WaitGroup
+	tr.opConfig.wg = &wg
+
+	tr.results = results{
+		inflightRequests:   sync.Map{},
+		concurrentRequests: parallel_limiter.New(int(tr.trcrtConfig.ParallelRequests)),
+		reachedFinalHop:    signal.New(),
+		tracker:            methods.NewProbeTracker(),
+
+		results: map[uint16][]methods.TracerouteHop{},
+	}
+
+	return tr.start(ctx)
+}
+
+func (tr *Traceroute) timeoutLoop() {
+	ticker := time.NewTicker(tr.trcrtConfig.Timeout / 4)
+	defer ticker.Stop()
+	done := tr.opConfig.ctx.Done()
+	for {
+		select {
+		case <-tr.opConfig.stop:
+			return
+		case <-done:
+			// the probes in flight are cancelled now, one stored while they are cancelled is on the next tick.
+			done = nil
+		case <-ticker.C:
+		}
+		tr.expireInflight()
+	}
+}
+
+// expireInflight completes the probes that timed out, every probe is cancelled once the context is done.
+func (tr *Traceroute) expireInflight() {
+	err := tr.opConfig.ctx.Err()
+	tr.results.inflightRequests.Range(func(key, value interface{}) bool {
+		request := value.(inflightData)
+		if err == nil && time.Since(request.start) <= tr.trcrtConfig.Timeout {
+			return true
+		}
+		if _, ok := tr.results.inflightRequests.LoadAndDelete(key); !ok {
+			return true
+		}
+		// a cancelled probe is not recorded as a timeout.
+		if err == nil {
+			tr.results.tracker.Complete(uint32(key.(uint16)), false)
+		}
+		request.reply <- methods.TracerouteHop{
+			Success: false,
+			TTL:     request.ttl,
+		}
+		return true
+	})
+}
+
+func (tr *Traceroute) addToResult(ttl uint16, hop methods.TracerouteHop) {
+	tr.results.resultsMu.Lock()
+	defer tr.results.resultsMu.Unlock()
+	if tr.results.results[ttl] == nil {
+		tr.results.results[ttl] = []methods.TracerouteHop{}
+	}
+
+	tr.results.results[ttl] = append(tr.results.results[ttl], hop)
+	if tr.trcrtConfig.OnProbe != nil {
+		tr.trcrtConfig.OnProbe(hop)
+	}
+}
+
+// nextSeq returns the sequence number for the next echo request.
+func (tr *Traceroute) nextSeq() uint16 {
+	tr.opConfig.seqMu.Lock()
+	defer tr.opConfig.seqMu.Unlock()
+	tr.opConfig.seq++
+	return tr.opConfig.seq
+}
+
+// handleMessage matches an echo reply or ICMP error to the inflight echo request by sequence number, the replies
+// from or quoting another destination or that arrive after their probe completed are counted and dropped.
+func (tr *Traceroute) handleMessage(msg demux.Message) {
+	_, seq, err := methods.GetICMPEchoIDSeq(msg.Header)
+	if err != nil {
+		return
+	}
+	// an ICMP error quotes the destination of the echo request, an echo reply comes from it.
+	dst := msg.Dst
+	if dst == nil {
+		dst = methods.AddrIP(msg.Peer)
+	}
+	if !dst.Equal(tr.opConfig.destIP) {
+		tr.results.tracker.Mismatched()
+		return
+	}
+	val, ok := tr.results.inflightRequests.LoadAndDelete(seq)
+	if !ok {
+		if !tr.results.tracker.Unmatched(uint32(seq)) {
+			tr.results.tracker.Mismatched()
+		}
+		return
+	}
+	tr.results.tracker.Complete(uint32(seq), true)
+	request := val.(inflightData)
+	elapsed := msg.Received.Sub(request.start)
+	// the probe was removed from the requests in flight so the buffered reply channel never blocks the shared receiver.
+	request.reply <- methods.TracerouteHop{
+		Success: true,
+		Address: msg.Peer,
+		TTL:     request.ttl,
+		RTT:     &elapsed,
+		ICMP:    methods.NewICMPReply(msg.ICMP),
+	}
+}
+
+// record adds the reply or timeout of a probe to the result and ends its span, the probes in flight when the
+// context was done are not recorded.
+func (tr *Traceroute) record(request inflightData, hop methods.TracerouteHop) {
+	if err := tr.opConfig.ctx.Err(); err != nil && !hop.Success {
+		methods.SetSpanStatus(request.childSpan, err)
+		tr.results.concurrentRequests.Finished()
+		tr.opConfig.wg.Done()
+		request.childSpan.End()
+		return
+	}
+	if hop.Success && (hop.Address.String() == tr.opConfig.destIP.String() || hop.Fatal()) {
+		tr.results.reachedFinalHop.Signal()
+	}
+	tr.addToResult(request.ttl, hop)
+	// the probe layout keeps the attributes of the earlier releases.
+	if !tr.opConfig.spans.RecordProbe(request.childSpan, hop) {
+		if hop.Success {
+			request.childSpan.SetAttributes(
+				attribute.Int64("ttl", int64(request.ttl)),
+				attribute.String("hop", hop.Address.String()),
+				attribute.String("rtt", hop.RTT.String()),
+			)
+			request.childSpan.SetAttributes(hop.ICMPAttributes()...)
+			request.childSpan.SetStatus(codes.Ok, "success")
+		} else {
+			request.childSpan.SetAttributes(
+				attribute.Int64("ttl", int64(request.ttl)),
+				attribute.String("hop", "null"),
+				attribute.String("rtt", time.Since(request.start).String()),
+			)
+			request.childSpan.SetStatus(codes.Error, "timeout")
+		}
+	}
+
+	tr.results.concurrentRequests.Finished()
+	tr.opConfig.wg.Done()
+	request.childSpan.End()
+}
+
+// echoRequest returns the marshalled echo request, the checksum is calculated by the kernel for ICMPv6.
+func (tr *Traceroute) echoRequest(seq uint16) ([]byte, error) {
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	if methods.IsIPv6(tr.opConfig.destIP) {
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+	data := payload
+	if tr.trcrtConfig.Paris {
+		data = parisData(seq)
+	}
+	msg := icmp.Message{
+		Type: typ,
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:   int(tr.opConfig.id),
+			Seq:  int(seq),
+			Data: data,
+		},
+	}
+	return msg.Marshal(nil)
+}
+
+// parisData appends the one's complement of the sequence number to the payload, the sum of the
+// sequence number and the appended word is constant so every probe carries the same checksum
+// and ECMP routers hashing on the first word of the ICMP header forward them along the same path.
+func parisData(seq uint16) []byte {
+	data := make([]byte, len(payload), len(payload)+3)
+	copy(data, payload)
+	// the appended word must be aligned to be summed with the sequence number.
+	if len(data)%2 != 0 {
+		data = append(data, 0)
+	}
+	return binary.BigEndian.AppendUint16(data, ^seq)
+}
+
+func (tr *Traceroute) sendMessage(ttl uint16) {
+	childSpan := tr.opConfig.spans.Start(
+		ttl,
+		tr.returnTraceAttributes(),
+		trace.WithAttributes(attribute.Int64("ttl", int64(ttl))),
+		trace.WithSpanKind(trace.SpanKindClient),
+	)
+
+	seq := tr.nextSeq()
+	msg, err := tr.echoRequest(seq)
+	if err != nil {
+		tr.sendFailed(childSpan, err)
+		return
+	}
+
+	reply := make(chan methods.TracerouteHop, 1)
+	request := inflightData{ttl: ttl, childSpan: childSpan, reply: reply}
+	err = tr.opConfig.receiver.Send(tr.opConfig.destIP, int(ttl), func(conn net.PacketConn) error {
+		// the request is stored before sending as the reply can arrive before WriteTo returns.
+		request.start = time.Now()
+		tr.results.inflightRequests.Store(seq, request)
+		if _, werr := conn.WriteTo(msg, &net.IPAddr{IP: tr.opConfig.destIP}); werr != nil {
+			tr.results.inflightRequests.Delete(seq)
+			return werr
+		}
+		return nil
+	})
+	if err != nil {
+		tr.sendFailed(childSpan, err)
+		return
+	}
+	// the reply is recorded here as OnProbe can block and the ICMP receiver is shared with the other traceroutes.
+	tr.record(request, <-reply)
+}
+
+// fail keeps the first error, the probes sent in parallel may fail after it.
+func (r *results) fail(err error) {
+	r.resultsMu.Lock()
+	defer r.resultsMu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// sendFailed records the error and stops the traceroute.
+func (tr *Traceroute) sendFailed(childSpan trace.Span, err error) {
+	tr.results.fail(err)
+	childSpan.SetStatus(codes.Error, "failure")
+	tr.opConfig.cancel()
+	childSpan.End()
+	tr.results.concurrentRequests.Finished()
+	tr.opConfig.wg.Done()
+}
+
+func (tr *Traceroute) sendLoop() {
+	defer tr.opConfig.wg.Done()
+
+	for ttl := uint16(1); ttl <= tr.trcrtConfig.MaxHops; ttl++ {
+		select {
+		case <-tr.results.reachedFinalHop.Chan():
+			return
+		default:
+		}
+		for i := 0; i < int(tr.trcrtConfig.NumMeasurements); i++ {
+			select {
+			case <-tr.opConfig.ctx.Done():
+				return
+			case <-tr.results.concurrentRequests.Start():
+				tr.opConfig.wg.Add(1)
+				go tr.sendMessage(ttl)
+			}
+		}
+	}
+}
+
+func (tr *Traceroute) start(ctx context.Context) (*methods.TraceResult, error) {
+	result := methods.NewTraceResult(tr.trcrtConfig.DestinationHostname, tr.opConfig.destIP, protocol, 0)
+	result.Tags = tr.trcrtConfig.Tags
+
+	spanName := fmt.Sprintf("%s/traceroute/%s", tr.trcrtConfig.LocalHostname, tr.opConfig.destIP)
+	parentctx, parentSpan := tr.trcrtConfig.Tracer.Start(
+		tr.trcrtConfig.TraceCtx,
+		spanName,
+		tr.returnTraceAttributes(),
+		trace.WithSpanKind(trace.SpanKindClient),
+	)
+	defer parentSpan.End()
+	tr.opConfig.spans = methods.NewProbeSpans(parentctx, &tr.trcrtConfig, spanName, protocol, tr.opConfig.destIP)
+
+	go tr.timeoutLoop()
+
+	tr.opConfig.wg.Add(1)
+	go tr.sendLoop()
+
+	tr.opConfig.wg.Wait()
+	tr.opConfig.cancel()
+	close(tr.opConfig.stop)
+	tr.opConfig.receiver.Unregister(demux.EchoKey(tr.opConfig.id))
+	tr.opConfig.receiver.Release()
+	if tr.results.err == nil {
+		tr.results.err = ctx.Err()
+	}
+
+	result.Finish(tr.results.results, tr.trcrtConfig.MaxHops, tr.results.err)
+	result.Discarded = tr.results.tracker.Discarded()
+	methods.EnrichResult(parentctx, tr.trcrtConfig.Enricher, result, parentSpan)
+	methods.RecordPath(tr.trcrtConfig.PathRecorder, result, parentSpan)
+	tr.opConfig.spans.End(result)
+	methods.SetSpanStatus(parentSpan, tr.results.err)
+
+	return result, tr.results.err
+}
+
+func (tr *Traceroute) returnTraceAttributes() trace.SpanStartEventOption {
+	attrs := []attribute.KeyValue{
+		attribute.String("source", tr.trcrtConfig.LocalHostname),
+		attribute.String("destination_hostname", tr.trcrtConfig.DestinationHostname),
+		attribute.Int64("max_ttl", int64(tr.trcrtConfig.MaxHops)),
+		attribute.String("protocol", protocol),
+		attribute.String("xid", tr.trcrtConfig.Xid.String()),
+	}
+	if tr.trcrtConfig.Cycle > 0 {
+		attrs = append(attrs, attribute.Int("cycle", tr.trcrtConfig.Cycle))
+	}
+	return trace.WithAttributes(append(attrs, methods.TagAttributes(tr.trcrtConfig.Tags)...)...)
+}
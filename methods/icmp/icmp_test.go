@@ -0,0 +1,381 @@
+package icmp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+	"github.com/jimmystewpot/traceroute/methods/demux"
+	"github.com/jimmystewpot/traceroute/methods/simnet"
+	"github.com/jimmystewpot/traceroute/parallel_limiter"
+	"github.com/jimmystewpot/traceroute/signal"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func TestTracerouteStart(t *testing.T) {
+	tests := []struct {
+		name    string
+		dest    net.IP
+		routers []simnet.Router
+		want    []string
+		// discarded are the replies that are not recorded.
+		discarded methods.DiscardedReplies
+	}{
+		{
+			name: "reaches the destination",
+			dest: net.ParseIP("192.0.2.1"),
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), Delay: time.Millisecond},
+				{Addr: net.ParseIP("10.0.1.1"), Delay: 2 * time.Millisecond},
+				{Addr: net.ParseIP("192.0.2.1"), Delay: 3 * time.Millisecond},
+			},
+			want: []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 10.0.1.1 10.0.1.1 10.0.1.1", "3 192.0.2.1 192.0.2.1 192.0.2.1"},
+		},
+		{
+			name: "ipv6",
+			dest: net.ParseIP("2001:db8::1"),
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("2001:db8:1::1")},
+				{Addr: net.ParseIP("2001:db8::1")},
+			},
+			want: []string{"1 2001:db8:1::1 2001:db8:1::1 2001:db8:1::1", "2 2001:db8::1 2001:db8::1 2001:db8::1"},
+		},
+		{
+			name: "loss",
+			dest: net.ParseIP("192.0.2.1"),
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), Loss: 1},
+				{Addr: net.ParseIP("10.0.1.1"), Loss: 0.5},
+				{Addr: net.ParseIP("192.0.2.1")},
+			},
+			want: []string{"1 * * *", "2 10.0.1.1 10.0.1.1 *", "3 192.0.2.1 192.0.2.1 192.0.2.1"},
+		},
+		{
+			name: "unreachable",
+			dest: net.ParseIP("192.0.2.1"),
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), Unreachable: true, Code: 0},
+				{Addr: net.ParseIP("192.0.2.1")},
+			},
+			want: []string{"1 10.0.0.1 !N 10.0.0.1 !N 10.0.0.1 !N"},
+		},
+		{
+			name: "duplicate replies",
+			dest: net.ParseIP("192.0.2.1"),
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), Duplicate: true},
+				{Addr: net.ParseIP("10.0.1.1"), Silent: true},
+				{Addr: net.ParseIP("192.0.2.1")},
+			},
+			want:      []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 * * *", "3 192.0.2.1 192.0.2.1 192.0.2.1"},
+			discarded: methods.DiscardedReplies{Duplicate: 3},
+		},
+		{
+			name: "mismatched destination",
+			dest: net.ParseIP("192.0.2.1"),
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), QuoteDst: net.ParseIP("192.0.2.99")},
+				{Addr: net.ParseIP("192.0.2.1")},
+			},
+			want:      []string{"1 * * *", "2 192.0.2.1 192.0.2.1 192.0.2.1"},
+			discarded: methods.DiscardedReplies{Mismatched: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := net.ParseIP("198.51.100.1")
+			if methods.IsIPv6(tt.dest) {
+				source = net.ParseIP("2001:db8:ffff::1")
+			}
+			cfg := methods.TracerouteConfig{
+				MaxHops:          5,
+				NumMeasurements:  3,
+				ParallelRequests: 6,
+				Timeout:          200 * time.Millisecond,
+				Tracer:           otel.Tracer("test"),
+				TraceCtx:         context.Background(),
+				Network:          simnet.New(source, tt.routers...),
+			}
+			res, err := New(tt.dest, cfg).Start()
+			if err != nil {
+				t.Fatalf("Traceroute.Start() error = %v", err)
+			}
+			if got := simnet.Summary(res); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Traceroute.Start() = %q, want %q", got, tt.want)
+			}
+			if res.Discarded != tt.discarded {
+				t.Errorf("Traceroute.Start() discarded = %+v, want %+v", res.Discarded, tt.discarded)
+			}
+		})
+	}
+}
+
+func TestTracerouteSendFailed(t *testing.T) {
+	dest := net.ParseIP("192.0.2.1")
+	network := simnet.New(net.ParseIP("198.51.100.1"), simnet.Router{Addr: net.ParseIP("10.0.0.1")}, simnet.Router{Addr: dest})
+	network.SendErr = errors.New("network is unreachable")
+	// the probes sent in parallel all fail, the first error is returned.
+	cfg := methods.TracerouteConfig{
+		MaxHops:          5,
+		NumMeasurements:  3,
+		ParallelRequests: 6,
+		Timeout:          200 * time.Millisecond,
+		Tracer:           otel.Tracer("test"),
+		TraceCtx:         context.Background(),
+		Network:          network,
+	}
+	res, err := New(dest, cfg).Start()
+	if !errors.Is(err, network.SendErr) {
+		t.Fatalf("Traceroute.Start() error = %v, want %v", err, network.SendErr)
+	}
+	if res == nil || len(res.Path()) != 0 {
+		t.Errorf("Traceroute.Start() = %+v, want no replies", res)
+	}
+}
+
+func TestTracerouteBlockingOnProbe(t *testing.T) {
+	dest := net.ParseIP("192.0.2.1")
+	network := simnet.New(net.ParseIP("198.51.100.1"),
+		simnet.Router{Addr: net.ParseIP("10.0.0.1")},
+		simnet.Router{Addr: dest},
+	)
+	cfg := methods.TracerouteConfig{
+		MaxHops:          5,
+		NumMeasurements:  3,
+		ParallelRequests: 6,
+		Timeout:          200 * time.Millisecond,
+		Tracer:           otel.Tracer("test"),
+		TraceCtx:         context.Background(),
+		Network:          network,
+	}
+
+	// the first traceroute blocks in OnProbe until the second one, which shares the ICMP receiver, has completed.
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	first := cfg
+	first.OnProbe = func(methods.TracerouteHop) {
+		once.Do(func() { close(blocked) })
+		<-release
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := New(dest, first).Start()
+		done <- err
+	}()
+	<-blocked
+
+	res, err := New(dest, cfg).Start()
+	close(release)
+	if err != nil {
+		t.Fatalf("Traceroute.Start() error = %v", err)
+	}
+	want := []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 192.0.2.1 192.0.2.1 192.0.2.1"}
+	if got := simnet.Summary(res); !reflect.DeepEqual(got, want) {
+		t.Errorf("Traceroute.Start() with a blocked OnProbe = %q, want %q", got, want)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Traceroute.Start() blocked traceroute error = %v", err)
+	}
+}
+
+// newTestTraceroute returns a traceroute with the echo identifier that is ready to record replies.
+func newTestTraceroute(dest net.IP, id uint16) *Traceroute {
+	tr := New(dest, methods.TracerouteConfig{MaxHops: 5, ParallelRequests: 3, Tracer: otel.Tracer("test")})
+	tr.opConfig.id = id
+	tr.opConfig.ctx, tr.opConfig.cancel = context.WithCancel(context.Background())
+	tr.opConfig.spans = methods.NewProbeSpans(context.Background(), &tr.trcrtConfig, "test", protocol, dest)
+	tr.opConfig.wg = &sync.WaitGroup{}
+	tr.results = results{
+		concurrentRequests: parallel_limiter.New(int(tr.trcrtConfig.ParallelRequests)),
+		reachedFinalHop:    signal.New(),
+		tracker:            methods.NewProbeTracker(),
+		results:            map[uint16][]methods.TracerouteHop{},
+	}
+	return tr
+}
+
+// inflight stores the echo request as sent with the TTL, its reply is received on the channel.
+func inflight(tr *Traceroute, seq, ttl uint16) (inflightData, <-chan methods.TracerouteHop) {
+	<-tr.results.concurrentRequests.Start()
+	tr.opConfig.wg.Add(1)
+	reply := make(chan methods.TracerouteHop, 1)
+	request := inflightData{
+		start:     time.Now(),
+		ttl:       ttl,
+		childSpan: trace.SpanFromContext(context.Background()),
+		reply:     reply,
+	}
+	tr.results.inflightRequests.Store(seq, request)
+	return request, reply
+}
+
+func TestEchoRequest(t *testing.T) {
+	for _, dest := range []string{"192.0.2.1", "2001:db8::1"} {
+		t.Run(dest, func(t *testing.T) {
+			tr := newTestTraceroute(net.ParseIP(dest), 0x1234)
+			data, err := tr.echoRequest(7)
+			if err != nil {
+				t.Fatalf("Traceroute.echoRequest() error = %v", err)
+			}
+			// the identifier and sequence number are read back from the echo request quoted by an ICMP error.
+			id, seq, err := methods.GetICMPEchoIDSeq(data)
+			if err != nil || id != 0x1234 || seq != 7 {
+				t.Errorf("GetICMPEchoIDSeq() = %#x, %d, %v, want 0x1234, 7", id, seq, err)
+			}
+			proto := 1
+			if methods.IsIPv6(tr.opConfig.destIP) {
+				proto = 58
+			}
+			msg, err := icmp.ParseMessage(proto, data)
+			if err != nil {
+				t.Fatalf("icmp.ParseMessage() error = %v", err)
+			}
+			if echo, ok := msg.Body.(*icmp.Echo); !ok || echo.ID != 0x1234 || echo.Seq != 7 {
+				t.Errorf("Traceroute.echoRequest() body = %+v", msg.Body)
+			}
+		})
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	dest := net.ParseIP("192.0.2.1")
+	router := net.ParseIP("10.0.0.1")
+	tr := newTestTraceroute(dest, 0x1234)
+	probe, err := tr.echoRequest(5)
+	if err != nil {
+		t.Fatalf("Traceroute.echoRequest() error = %v", err)
+	}
+	tests := []struct {
+		name string
+		peer net.IP
+		rm   *icmp.Message
+		// dst is the destination quoted by an ICMP error, header is the quoted echo request or the echo reply.
+		dst    net.IP
+		header []byte
+		// recorded is true when the message is matched to the echo request in flight.
+		recorded bool
+		final    bool
+		// discarded are the message and its duplicate that are not recorded.
+		discarded methods.DiscardedReplies
+	}{
+		{
+			name:      "echo reply from the destination",
+			peer:      dest,
+			rm:        &icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 0x1234, Seq: 5}},
+			header:    []byte{0, 0, 0, 0, 0x12, 0x34, 0, 5},
+			recorded:  true,
+			final:     true,
+			discarded: methods.DiscardedReplies{Duplicate: 1},
+		},
+		{
+			name:      "time exceeded from a router",
+			peer:      router,
+			rm:        &icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{}},
+			dst:       dest,
+			header:    probe,
+			recorded:  true,
+			discarded: methods.DiscardedReplies{Duplicate: 1},
+		},
+		{
+			name:      "host unreachable from a router",
+			peer:      router,
+			rm:        &icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 1, Body: &icmp.DstUnreach{}},
+			dst:       dest,
+			header:    probe,
+			recorded:  true,
+			final:     true,
+			discarded: methods.DiscardedReplies{Duplicate: 1},
+		},
+		{
+			name:      "sequence not in flight",
+			peer:      router,
+			rm:        &icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{}},
+			dst:       dest,
+			header:    []byte{8, 0, 0, 0, 0x12, 0x34, 0, 6},
+			discarded: methods.DiscardedReplies{Mismatched: 2},
+		},
+		{
+			name:      "quotes another destination",
+			peer:      router,
+			rm:        &icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{}},
+			dst:       net.ParseIP("192.0.2.99"),
+			header:    probe,
+			discarded: methods.DiscardedReplies{Mismatched: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTraceroute(dest, 0x1234)
+			request, reply := inflight(tr, 5, 2)
+			msg := demux.Message{Peer: &net.IPAddr{IP: tt.peer}, ICMP: tt.rm, Header: tt.header, Dst: tt.dst, Received: time.Now()}
+			tr.handleMessage(msg)
+			// the reply is handed to the goroutine that sent the echo request, which records it.
+			select {
+			case hop := <-reply:
+				tr.record(request, hop)
+			default:
+			}
+
+			hops := tr.results.results[2]
+			if (len(hops) == 1) != tt.recorded {
+				t.Fatalf("Traceroute.handleMessage() hops = %+v, want recorded %v", hops, tt.recorded)
+			}
+			if tt.recorded && (!hops[0].Success || hops[0].Address.String() != tt.peer.String() || hops[0].RTT == nil) {
+				t.Errorf("Traceroute.handleMessage() hop = %+v, want a reply from %s", hops[0], tt.peer)
+			}
+			if got := len(tr.results.reachedFinalHop.Chan()) != 0; got != tt.final {
+				t.Errorf("Traceroute.handleMessage() reached the final hop = %v, want %v", got, tt.final)
+			}
+			// the request completed so a duplicate reply is not recorded.
+			tr.handleMessage(msg)
+			if len(tr.results.results[2]) > 1 {
+				t.Errorf("Traceroute.handleMessage() recorded a duplicate reply: %+v", tr.results.results[2])
+			}
+			if got := tr.results.tracker.Discarded(); got != tt.discarded {
+				t.Errorf("Traceroute.handleMessage() discarded = %+v, want %+v", got, tt.discarded)
+			}
+		})
+	}
+}
+
+func TestParisData(t *testing.T) {
+	tests := []struct {
+		name  string
+		paris bool
+	}{
+		{name: "paris", paris: true},
+		{name: "classic"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTraceroute(net.ParseIP("192.0.2.1"), 0x1234)
+			tr.trcrtConfig.Paris = tt.paris
+			checksums := map[uint16]bool{}
+			for _, seq := range []uint16{1, 2, 3, 0x00ff, 0x7fff, 0xfffe} {
+				data, err := tr.echoRequest(seq)
+				if err != nil {
+					t.Fatalf("Traceroute.echoRequest() error = %v", err)
+				}
+				// the type, code and checksum are the first word ECMP routers hash on, the identifier is fixed.
+				id, gotSeq, _ := methods.GetICMPEchoIDSeq(data)
+				if data[0] != 8 || data[1] != 0 || id != 0x1234 || gotSeq != seq {
+					t.Errorf("Traceroute.echoRequest(%d) header = % x", seq, data[:8])
+				}
+				checksums[binary.BigEndian.Uint16(data[2:4])] = true
+			}
+			if constant := len(checksums) == 1; constant != tt.paris {
+				t.Errorf("Traceroute.echoRequest() checksums = %v, want constant %v", checksums, tt.paris)
+			}
+		})
+	}
+}
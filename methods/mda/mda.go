@@ -0,0 +1,460 @@
+package mda
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/jimmystewpot/traceroute/methods"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultConfidence is used when the configured confidence is not between 0 and 1.
+	DefaultConfidence float64 = 0.95
+	// maxFlows bounds the number of flow identifiers used for a single traceroute.
+	maxFlows uint16 = 512
+	// noReply is the responder of a probe that timed out.
+	noReply string = "*"
+	// source is the responder at TTL 0, every flow passes through it.
+	source string = ""
+)
+
+// MDA implements the multipath detection algorithm, it varies the flow identifier to find every
+// next hop of each interface with the configured confidence.
+type MDA struct {
+	prober      methods.FlowProber
+	destIP      net.IP
+	protocol    string
+	trcrtConfig methods.TracerouteConfig
+	confidence  float64
+	// ctx stops the probing when it is done.
+	ctx context.Context
+
+	// replies holds the reply of every probe indexed by ttl and flow.
+	replies   map[uint16]map[uint16]methods.TracerouteHop
+	repliesMu sync.Mutex
+	nextFlow  uint16
+	probes    int
+}
+
+//nolint:gocritic // config is large and required
+func New(prober methods.FlowProber, destIP net.IP, protocol string, config methods.TracerouteConfig) *MDA {
+	confidence := config.MultipathConfidence
+	if confidence <= 0 || confidence >= 1 {
+		confidence = DefaultConfidence
+	}
+	return &MDA{
+		prober:      prober,
+		destIP:      destIP,
+		protocol:    protocol,
+		trcrtConfig: config,
+		confidence:  confidence,
+		ctx:         context.Background(),
+		replies:     map[uint16]map[uint16]methods.TracerouteHop{},
+	}
+}
+
+// StoppingPoint returns the number of probes that must reach an interface with k known next hops to
+// rule out a further next hop with the given confidence (Veitch et al, Failure Control in Multipath Route Tracing).
+func StoppingPoint(k int, confidence float64) int {
+	if k < 1 {
+		k = 1
+	}
+	alpha := 1 - confidence
+	n := math.Log(alpha/float64(k+1)) / math.Log(float64(k)/float64(k+1))
+	return int(math.Ceil(n))
+}
+
+// Start probes each TTL until the destination is reached or the maximum hops is exceeded.
+func (m *MDA) Start() (*methods.TraceResult, error) {
+	return m.StartContext(context.Background())
+}
+
+// StartContext probes each TTL until the destination is reached or every interface returned a fatal unreachable,
+// the maximum hops is exceeded or the context is done. The result holds every probe reduced to the hops and the interfaces and links in Multipath, when
+// probing fails or the context is done the interfaces found so far are returned with the error.
+func (m *MDA) StartContext(ctx context.Context) (*methods.TraceResult, error) {
+	m.ctx = ctx
+	res := methods.NewTraceResult(m.trcrtConfig.DestinationHostname, m.destIP, m.protocol, m.trcrtConfig.Port)
+	res.Tags = m.trcrtConfig.Tags
+	parentctx, parentSpan := m.trcrtConfig.Tracer.Start(
+		m.trcrtConfig.TraceCtx,
+		fmt.Sprintf("%s/traceroute/%s", m.trcrtConfig.LocalHostname, m.destIP),
+		m.returnTraceAttributes(),
+		trace.WithSpanKind(trace.SpanKindClient),
+	)
+	defer parentSpan.End()
+
+	result := &methods.MultipathResult{
+		Hops:  map[uint16][]methods.MultipathInterface{},
+		Links: []methods.MultipathLink{},
+	}
+	var err error
+	for ttl := uint16(1); ttl <= m.trcrtConfig.MaxHops; ttl++ {
+		err = m.exploreHop(ttl)
+		m.collectHop(result, ttl)
+		links := m.collectLinks(result, ttl)
+		m.addSpanEvents(parentSpan, result, ttl, links)
+		if err != nil || m.reachedFinalHop(ttl) {
+			break
+		}
+	}
+
+	res.Multipath = result
+	m.finish(parentctx, parentSpan, res, err)
+	return res, err
+}
+
+// finish reduces the probes of every flow to the hops of the result and records the number of probes sent, the
+// result is partial when err is set.
+func (m *MDA) finish(ctx context.Context, span trace.Span, res *methods.TraceResult, err error) {
+	m.repliesMu.Lock()
+	probes := make(map[uint16][]methods.TracerouteHop, len(m.replies))
+	for ttl, replies := range m.replies {
+		flows := make([]uint16, 0, len(replies))
+		for flow := range replies {
+			flows = append(flows, flow)
+		}
+		sort.Slice(flows, func(i, j int) bool { return flows[i] < flows[j] })
+		for _, flow := range flows {
+			probes[ttl] = append(probes[ttl], replies[flow])
+		}
+	}
+	m.repliesMu.Unlock()
+
+	res.Multipath.ProbesSent = m.probes
+	res.Finish(probes, m.trcrtConfig.MaxHops, err)
+	methods.EnrichResult(ctx, m.trcrtConfig.Enricher, res, span)
+	methods.RecordPath(m.trcrtConfig.PathRecorder, res, span)
+	span.SetAttributes(attribute.Int("probes_sent", m.probes))
+	methods.SetSpanStatus(span, err)
+}
+
+// exploreHop finds the next hops of every interface at the previous TTL, including the interfaces found
+// at the previous TTL while looking for flows that pass through an interface.
+func (m *MDA) exploreHop(ttl uint16) error {
+	explored := map[string]bool{}
+	for {
+		pending := []string{}
+		final := m.finalResponders(ttl - 1)
+		for _, pred := range m.responders(ttl - 1) {
+			if !explored[pred] && !final[pred] {
+				pending = append(pending, pred)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		for _, pred := range pending {
+			explored[pred] = true
+			err := m.exploreSuccessors(ttl, pred)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// exploreSuccessors probes flows through pred at ttl until the stopping rule is satisfied.
+func (m *MDA) exploreSuccessors(ttl uint16, pred string) error {
+	for {
+		through := m.flowsThrough(ttl-1, pred)
+		probed, unprobed := m.splitProbed(ttl, through)
+		need := StoppingPoint(m.successors(ttl, probed), m.confidence)
+		if len(probed) >= need {
+			return nil
+		}
+		missing := need - len(probed)
+		if len(unprobed) > 0 {
+			if len(unprobed) > missing {
+				unprobed = unprobed[:missing]
+			}
+			err := m.probeFlows(ttl, unprobed)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		// not enough flows are known to pass through pred, new flows are probed at the previous TTL
+		// to find out which interface they are forwarded through.
+		flows := m.newFlows(missing)
+		if len(flows) == 0 {
+			return nil
+		}
+		if ttl > 1 {
+			err := m.probeFlows(ttl-1, flows)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// probeFlows sends a probe for each flow at ttl, the probes are sent in parallel.
+func (m *MDA) probeFlows(ttl uint16, flows []uint16) error {
+	parallel := int(m.trcrtConfig.ParallelRequests)
+	if parallel < 1 {
+		parallel = 1
+	}
+	limiter := make(chan struct{}, parallel)
+
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+	// setErr keeps the first error, the probes still in flight may fail after it.
+	setErr := func(err error) {
+		errMu.Lock()
+		defer errMu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, flow := range flows {
+		if err := m.ctx.Err(); err != nil {
+			setErr(err)
+			break
+		}
+		wg.Add(1)
+		limiter <- struct{}{}
+		go func(flow uint16) {
+			defer wg.Done()
+			defer func() { <-limiter }()
+			hop, err := m.prober.ProbeFlow(flow, ttl)
+			if err != nil {
+				setErr(err)
+				return
+			}
+			m.repliesMu.Lock()
+			defer m.repliesMu.Unlock()
+			if m.replies[ttl] == nil {
+				m.replies[ttl] = map[uint16]methods.TracerouteHop{}
+			}
+			m.replies[ttl][flow] = hop
+			m.probes++
+			if m.trcrtConfig.OnProbe != nil {
+				m.trcrtConfig.OnProbe(hop)
+			}
+		}(flow)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// newFlows allocates up to count unused flow identifiers.
+func (m *MDA) newFlows(count int) []uint16 {
+	flows := []uint16{}
+	for i := 0; i < count && m.nextFlow < maxFlows; i++ {
+		flows = append(flows, m.nextFlow)
+		m.nextFlow++
+	}
+	return flows
+}
+
+// responder returns the key used to group the replies of a probe.
+func responder(hop methods.TracerouteHop) string {
+	if !hop.Success || hop.Address == nil {
+		return noReply
+	}
+	return hop.Address.(*net.IPAddr).IP.String()
+}
+
+// responders returns the distinct responders at ttl.
+func (m *MDA) responders(ttl uint16) []string {
+	if ttl == 0 {
+		return []string{source}
+	}
+	m.repliesMu.Lock()
+	defer m.repliesMu.Unlock()
+	seen := map[string]bool{}
+	for _, hop := range m.replies[ttl] {
+		seen[responder(hop)] = true
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// flowsThrough returns the flows that were forwarded through pred at ttl.
+func (m *MDA) flowsThrough(ttl uint16, pred string) []uint16 {
+	flows := []uint16{}
+	if ttl == 0 {
+		for flow := uint16(0); flow < m.nextFlow; flow++ {
+			flows = append(flows, flow)
+		}
+		return flows
+	}
+	m.repliesMu.Lock()
+	defer m.repliesMu.Unlock()
+	for flow, hop := range m.replies[ttl] {
+		if responder(hop) == pred {
+			flows = append(flows, flow)
+		}
+	}
+	sort.Slice(flows, func(i, j int) bool { return flows[i] < flows[j] })
+	return flows
+}
+
+// splitProbed separates the flows that have been probed at ttl from those that have not.
+func (m *MDA) splitProbed(ttl uint16, flows []uint16) (probed, unprobed []uint16) {
+	m.repliesMu.Lock()
+	defer m.repliesMu.Unlock()
+	for _, flow := range flows {
+		if _, ok := m.replies[ttl][flow]; ok {
+			probed = append(probed, flow)
+			continue
+		}
+		unprobed = append(unprobed, flow)
+	}
+	return probed, unprobed
+}
+
+// successors returns the number of distinct interfaces that replied at ttl for the flows.
+func (m *MDA) successors(ttl uint16, flows []uint16) int {
+	m.repliesMu.Lock()
+	defer m.repliesMu.Unlock()
+	seen := map[string]bool{}
+	for _, flow := range flows {
+		if key := responder(m.replies[ttl][flow]); key != noReply {
+			seen[key] = true
+		}
+	}
+	return len(seen)
+}
+
+// finalResponders returns the interfaces that replied at ttl, true for the destination and for an interface that
+// only returned fatal unreachables as the flows through them are not forwarded any further.
+func (m *MDA) finalResponders(ttl uint16) map[string]bool {
+	m.repliesMu.Lock()
+	defer m.repliesMu.Unlock()
+	final := map[string]bool{}
+	for _, hop := range m.replies[ttl] {
+		key := responder(hop)
+		if key == noReply {
+			continue
+		}
+		last := key == m.destIP.String() || hop.Fatal()
+		if seen, ok := final[key]; ok {
+			last = last && seen
+		}
+		final[key] = last
+	}
+	return final
+}
+
+// reachedFinalHop returns true when an interface replied at ttl and every interface that did is the destination
+// or returned a fatal unreachable.
+func (m *MDA) reachedFinalHop(ttl uint16) bool {
+	final := m.finalResponders(ttl)
+	for _, last := range final {
+		if !last {
+			return false
+		}
+	}
+	return len(final) > 0
+}
+
+// collectHop groups the replies at ttl by interface.
+func (m *MDA) collectHop(result *methods.MultipathResult, ttl uint16) {
+	m.repliesMu.Lock()
+	defer m.repliesMu.Unlock()
+	interfaces := map[string]*methods.MultipathInterface{}
+	for flow, hop := range m.replies[ttl] {
+		key := responder(hop)
+		if key == noReply {
+			continue
+		}
+		iface, ok := interfaces[key]
+		if !ok {
+			iface = &methods.MultipathInterface{
+				Address: hop.Address.(*net.IPAddr).IP,
+				TTL:     ttl,
+			}
+			interfaces[key] = iface
+		}
+		iface.Flows = append(iface.Flows, flow)
+		iface.RTT = append(iface.RTT, *hop.RTT)
+	}
+	hops := make([]methods.MultipathInterface, 0, len(interfaces))
+	for _, iface := range interfaces {
+		hops = append(hops, *iface)
+	}
+	sort.Slice(hops, func(i, j int) bool { return hops[i].Address.String() < hops[j].Address.String() })
+	result.Hops[ttl] = hops
+}
+
+// collectLinks adds the links between the interfaces at ttl-1 and ttl that share a flow.
+func (m *MDA) collectLinks(result *methods.MultipathResult, ttl uint16) []methods.MultipathLink {
+	links := []methods.MultipathLink{}
+	if ttl < 2 {
+		return links
+	}
+	m.repliesMu.Lock()
+	defer m.repliesMu.Unlock()
+	seen := map[string]bool{}
+	for flow, hop := range m.replies[ttl] {
+		prev, ok := m.replies[ttl-1][flow]
+		if !ok || responder(prev) == noReply || responder(hop) == noReply {
+			continue
+		}
+		key := responder(prev) + "-" + responder(hop)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		links = append(links, methods.MultipathLink{
+			TTL:  ttl - 1,
+			From: prev.Address.(*net.IPAddr).IP,
+			To:   hop.Address.(*net.IPAddr).IP,
+		})
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].From.String() == links[j].From.String() {
+			return links[i].To.String() < links[j].To.String()
+		}
+		return links[i].From.String() < links[j].From.String()
+	})
+	result.Links = append(result.Links, links...)
+	return links
+}
+
+// addSpanEvents records the interfaces and links discovered at ttl so the diamond is visible on the trace.
+func (m *MDA) addSpanEvents(span trace.Span, result *methods.MultipathResult, ttl uint16, links []methods.MultipathLink) {
+	span.AddEvent("mda.hop", trace.WithAttributes(
+		attribute.Int64("ttl", int64(ttl)),
+		attribute.StringSlice("interfaces", result.Interfaces(ttl)),
+		attribute.Int("interface_count", len(result.Hops[ttl])),
+	))
+	for _, link := range links {
+		span.AddEvent("mda.link", trace.WithAttributes(
+			attribute.Int64("ttl", int64(link.TTL)),
+			attribute.String("from", link.From.String()),
+			attribute.String("to", link.To.String()),
+		))
+	}
+}
+
+func (m *MDA) returnTraceAttributes() trace.SpanStartEventOption {
+	attrs := []attribute.KeyValue{
+		attribute.String("source", m.trcrtConfig.LocalHostname),
+		attribute.String("destination_hostname", m.trcrtConfig.DestinationHostname),
+		attribute.Int64("max_ttl", int64(m.trcrtConfig.MaxHops)),
+		attribute.String("protocol", m.protocol),
+		attribute.String("xid", m.trcrtConfig.Xid.String()),
+		attribute.String("mode", "mda"),
+		attribute.Float64("confidence", m.confidence),
+	}
+	if m.trcrtConfig.Cycle > 0 {
+		attrs = append(attrs, attribute.Int("cycle", m.trcrtConfig.Cycle))
+	}
+	return trace.WithAttributes(attrs...)
+}
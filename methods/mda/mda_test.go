@@ -0,0 +1,206 @@
+package mda
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+	"go.opentelemetry.io/otel"
+)
+
+// diamond is a FlowProber where the second and third hops are load balanced by the flow identifier.
+type diamond struct {
+	hops [][]string
+}
+
+func (d *diamond) ProbeFlow(flow uint16, ttl uint16) (methods.TracerouteHop, error) {
+	if int(ttl) > len(d.hops) {
+		ttl = uint16(len(d.hops))
+	}
+	branches := d.hops[ttl-1]
+	rtt := time.Millisecond
+	return methods.TracerouteHop{
+		Success: true,
+		Address: &net.IPAddr{IP: net.ParseIP(branches[int(flow)%len(branches)])},
+		TTL:     ttl,
+		RTT:     &rtt,
+	}, nil
+}
+
+func TestStoppingPoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		k          int
+		confidence float64
+		want       int
+	}{
+		{name: "one next hop", k: 1, confidence: 0.95, want: 6},
+		{name: "two next hops", k: 2, confidence: 0.95, want: 11},
+		{name: "three next hops", k: 3, confidence: 0.95, want: 16},
+		{name: "no next hops", k: 0, confidence: 0.95, want: 6},
+		{name: "one next hop higher confidence", k: 1, confidence: 0.99, want: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StoppingPoint(tt.k, tt.confidence); got != tt.want {
+				t.Errorf("StoppingPoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMDAStart(t *testing.T) {
+	prober := &diamond{
+		hops: [][]string{
+			{"10.0.0.1"},
+			{"10.0.1.1", "10.0.1.2"},
+			{"10.0.2.1", "10.0.2.2", "10.0.2.3"},
+			{"10.0.3.1"},
+			{"192.0.2.1"},
+		},
+	}
+	cfg := methods.TracerouteConfig{
+		MaxHops:          30,
+		ParallelRequests: 4,
+		Tracer:           otel.Tracer("test"),
+		TraceCtx:         context.Background(),
+	}
+	res, err := New(prober, net.ParseIP("192.0.2.1"), "udp", cfg).Start()
+	if err != nil {
+		t.Fatalf("MDA.Start() error = %v", err)
+	}
+	for ttl, want := range map[uint16][]string{
+		1: {"10.0.0.1"},
+		2: {"10.0.1.1", "10.0.1.2"},
+		3: {"10.0.2.1", "10.0.2.2", "10.0.2.3"},
+		4: {"10.0.3.1"},
+		5: {"192.0.2.1"},
+	} {
+		if got := res.Multipath.Interfaces(ttl); !reflect.DeepEqual(got, want) {
+			t.Errorf("MDA.Start() ttl %d interfaces = %v, want %v", ttl, got, want)
+		}
+	}
+	if _, ok := res.Multipath.Hops[6]; ok {
+		t.Errorf("MDA.Start() probed past the destination")
+	}
+	// the probes of every flow are reduced to the hops of the result.
+	if len(res.Hops) != 5 || !res.ReachedDestination || res.Hops[2].Stats.Sent != res.Hops[2].Stats.Received {
+		t.Errorf("MDA.Start() hops = %v, reached destination %v", res.Path(), res.ReachedDestination)
+	}
+	if got := res.Hops[2].Stats.Responders; len(got) != 3 {
+		t.Errorf("MDA.Start() ttl 3 responders = %v, want 3", got)
+	}
+	// flows alternate between the branches so every interface at ttl 2 links to every interface at ttl 3.
+	if len(res.Multipath.Links) != 2+6+3+1 {
+		t.Errorf("MDA.Start() links = %d, want %d", len(res.Multipath.Links), 12)
+	}
+}
+
+// unreachable is a diamond where the interfaces return a host unreachable.
+type unreachable struct {
+	diamond
+	addrs map[string]bool
+}
+
+func (u *unreachable) ProbeFlow(flow uint16, ttl uint16) (methods.TracerouteHop, error) {
+	hop, err := u.diamond.ProbeFlow(flow, ttl)
+	if u.addrs[hop.Address.String()] {
+		hop.ICMP = &methods.ICMPReply{Type: 3, Code: 1}
+	}
+	return hop, err
+}
+
+func TestMDAStartUnreachable(t *testing.T) {
+	hops := [][]string{
+		{"10.0.0.1"},
+		{"10.0.1.1", "10.0.1.2"},
+		{"10.0.2.1"},
+		{"192.0.2.1"},
+	}
+	tests := []struct {
+		name  string
+		addrs []string
+		// last is the last TTL that is probed.
+		last uint16
+	}{
+		{name: "every interface unreachable", addrs: []string{"10.0.1.1", "10.0.1.2"}, last: 2},
+		{name: "one interface unreachable", addrs: []string{"10.0.1.1"}, last: 4},
+		{name: "unreachable before the load balancer", addrs: []string{"10.0.0.1"}, last: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prober := &unreachable{diamond: diamond{hops: hops}, addrs: map[string]bool{}}
+			for _, addr := range tt.addrs {
+				prober.addrs[addr] = true
+			}
+			cfg := methods.TracerouteConfig{
+				MaxHops:          30,
+				ParallelRequests: 4,
+				Tracer:           otel.Tracer("test"),
+				TraceCtx:         context.Background(),
+			}
+			res, err := New(prober, net.ParseIP("192.0.2.1"), "udp", cfg).Start()
+			if err != nil {
+				t.Fatalf("MDA.Start() error = %v", err)
+			}
+			// the interfaces are probed at the next TTL unless every one of them returned a fatal unreachable.
+			if len(res.Multipath.Hops) != int(tt.last) || len(res.Multipath.Hops[tt.last]) == 0 {
+				t.Errorf("MDA.Start() probed %d TTLs, want %d", len(res.Multipath.Hops), tt.last)
+			}
+		})
+	}
+}
+
+// cancelling cancels the context of the traceroute when a probe reaches ttl.
+type cancelling struct {
+	diamond
+	ttl    uint16
+	cancel context.CancelFunc
+}
+
+func (c *cancelling) ProbeFlow(flow uint16, ttl uint16) (methods.TracerouteHop, error) {
+	if ttl >= c.ttl {
+		c.cancel()
+		return methods.TracerouteHop{}, context.Canceled
+	}
+	return c.diamond.ProbeFlow(flow, ttl)
+}
+
+func TestMDAStartContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prober := &cancelling{
+		diamond: diamond{
+			hops: [][]string{
+				{"10.0.0.1"},
+				{"10.0.1.1", "10.0.1.2"},
+				{"192.0.2.1"},
+			},
+		},
+		ttl:    3,
+		cancel: cancel,
+	}
+	cfg := methods.TracerouteConfig{
+		MaxHops:          30,
+		ParallelRequests: 1,
+		Tracer:           otel.Tracer("test"),
+		TraceCtx:         context.Background(),
+	}
+	res, err := New(prober, net.ParseIP("192.0.2.1"), "udp", cfg).StartContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("MDA.StartContext() error = %v, want %v", err, context.Canceled)
+	}
+	if res == nil {
+		t.Fatalf("MDA.StartContext() did not return the partial result")
+	}
+	if got := res.Multipath.Interfaces(2); !reflect.DeepEqual(got, []string{"10.0.1.1", "10.0.1.2"}) {
+		t.Errorf("MDA.StartContext() ttl 2 interfaces = %v", got)
+	}
+	if len(res.Multipath.Hops[3]) != 0 {
+		t.Errorf("MDA.StartContext() ttl 3 interfaces = %v, want none", res.Multipath.Interfaces(3))
+	}
+}
@@ -4,11 +4,25 @@ import (
 	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
 	"time"
 
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/rs/xid"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// ProtocolICMP and ProtocolIPv6ICMP are the IANA protocol numbers used to parse ICMP messages.
+	ProtocolICMP     int = 1
+	ProtocolIPv6ICMP int = 58
+
+	ipv6HeaderLength int = 40
 )
 
 // TracerouteHop type
@@ -17,6 +31,29 @@ type TracerouteHop struct {
 	Address net.Addr
 	TTL     uint16
 	RTT     *time.Duration
+	// ICMP is set when the reply to the probe was an ICMP message.
+	ICMP *ICMPReply
+	// Hostname is the reverse name of the address, it is only set when names are resolved.
+	Hostname string
+	// AS is the autonomous system of the address, it is only set when an ASN table is loaded.
+	AS *ASInfo
+}
+
+// String formats the probe the same way as the classic traceroute output.
+func (th TracerouteHop) String() string {
+	if !th.Success {
+		return "*"
+	}
+	hop := fmt.Sprintf("%s %s", AddrIP(th.Address), th.RTT)
+	if marker := th.Marker(); marker != "" {
+		hop = fmt.Sprintf("%s %s", hop, marker)
+	}
+	if th.ICMP != nil {
+		for _, label := range th.ICMP.MPLS {
+			hop = fmt.Sprintf("%s [MPLS: %s]", hop, label)
+		}
+	}
+	return hop
 }
 
 type TracerouteConfig struct {
@@ -27,16 +64,43 @@ type TracerouteConfig struct {
 	ParallelRequests    uint16
 	Port                int
 	Timeout             time.Duration
+	// Paris keeps the flow identifier constant for all probes of a traceroute.
+	Paris bool
+	// Multipath enumerates the load balanced paths with the multipath detection algorithm (MDA) and
+	// MultipathConfidence is the probability that all of the next hops of an interface are found.
+	Multipath           bool
+	MultipathConfidence float64
+	// Enricher adds the hostname and autonomous system of the hop addresses to the result, it is optional.
+	Enricher Enricher
+	// PathRecorder compares the result with the previous traceroute to the destination, it is optional.
+	PathRecorder PathRecorder
+	// Tags are added to the result and to the attributes of the spans.
+	Tags map[string]string
+	// OnProbe is called with the result of every probe as soon as it completes, before the results are reduced
+	// at the end of the traceroute. The calls are serialised, it is optional.
+	OnProbe func(hop TracerouteHop)
+	// Network opens the sockets of the traceroute, the System network is used when it is not set.
+	Network Network
+	// SpanLayout is how the probes are exported as spans, SpanLayoutProbe is used when it is not set.
+	SpanLayout SpanLayout
 	// added to support otel tracing.
 	Tracer   trace.Tracer
 	TraceCtx context.Context
 	Xid      xid.ID
+	// Cycle is the number of the run when the path is probed repeatedly with the same Xid, it is added to the
+	// spans when it is set.
+	Cycle int
 }
 
 func GetIPHeaderLength(data []byte) (int, error) {
 	if len(data) < 1 {
 		return 0, errors.New("received invalid IP header")
 	}
+	// the IPv6 header is a fixed length, the version is in the high nibble.
+	//nolint:gomnd  // irrelevant error
+	if data[0]>>4 == 6 {
+		return ipv6HeaderLength, nil
+	}
 	//nolint:gomnd  // irrelevant error
 	return int((data[0] & 0x0F) * 4), nil
 }
@@ -60,15 +124,28 @@ func GetUDPSrcPort(data []byte) uint16 {
 	return srcPort
 }
 
+func GetUDPChecksum(data []byte) uint16 {
+	return binary.BigEndian.Uint16(data[6:8])
+}
+
 func GetTCPSeq(data []byte) uint32 {
 	seqBytes := data[4:8]
 	return binary.BigEndian.Uint32(seqBytes)
 }
 
+// GetICMPEchoIDSeq returns the identifier and sequence number of a quoted ICMP echo request.
+func GetICMPEchoIDSeq(data []byte) (id, seq uint16, err error) {
+	//nolint:gomnd  // icmp echo header length
+	if len(data) < 8 {
+		return 0, 0, errors.New("length of icmp echo header too short")
+	}
+	return binary.BigEndian.Uint16(data[4:6]), binary.BigEndian.Uint16(data[6:8]), nil
+}
+
 func ReduceFinalResult(preliminary map[uint16][]TracerouteHop, maxHops uint16, destIP net.IP) map[uint16][]TracerouteHop {
 	// reduce the results to remove all hops after the first encounter to final destination
 	finalResults := map[uint16][]TracerouteHop{}
-	for i := uint16(1); i < maxHops; i++ {
+	for i := uint16(1); i <= maxHops; i++ {
 		foundFinal := false
 		probes := preliminary[i]
 		if probes == nil {
@@ -79,6 +156,10 @@ func ReduceFinalResult(preliminary map[uint16][]TracerouteHop, maxHops uint16, d
 			if probe.Success && probe.Address.String() == destIP.String() {
 				foundFinal = true
 			}
+			// a fatal destination unreachable ends the traceroute the same as reaching the destination.
+			if probe.Fatal() {
+				foundFinal = true
+			}
 			finalResults[i] = append(finalResults[i], probe)
 		}
 		if foundFinal {
@@ -87,3 +168,85 @@ func ReduceFinalResult(preliminary map[uint16][]TracerouteHop, maxHops uint16, d
 	}
 	return finalResults
 }
+
+// IsIPv6 returns true when the destination must be probed using IPv6.
+func IsIPv6(ip net.IP) bool {
+	return ip.To4() == nil
+}
+
+// ListenICMP opens the raw ICMP socket matching the address family of the destination and returns
+// the protocol number required to parse the received messages.
+func ListenICMP(destIP net.IP) (*icmp.PacketConn, int, error) {
+	if !IsIPv6(destIP) {
+		conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+		return conn, ProtocolICMP, err
+	}
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, ProtocolIPv6ICMP, err
+	}
+	// ICMPv6 carries neighbour discovery and other noise, only accept the messages we parse.
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeTimeExceeded)
+	filter.Accept(ipv6.ICMPTypeDestinationUnreachable)
+	filter.Accept(ipv6.ICMPTypeEchoReply)
+	err = conn.IPv6PacketConn().SetICMPFilter(&filter)
+	if err != nil {
+		conn.Close()
+		return nil, ProtocolIPv6ICMP, err
+	}
+	return conn, ProtocolIPv6ICMP, nil
+}
+
+// SetTTL sets the IPv4 TTL or the IPv6 hop limit on the connection used to send probes.
+func SetTTL(conn net.PacketConn, destIP net.IP, ttl int) error {
+	if c, ok := conn.(TTLSetter); ok {
+		return c.SetTTL(ttl)
+	}
+	if c, ok := conn.(*icmp.PacketConn); ok {
+		if IsIPv6(destIP) {
+			return c.IPv6PacketConn().SetHopLimit(ttl)
+		}
+		return c.IPv4PacketConn().SetTTL(ttl)
+	}
+	if IsIPv6(destIP) {
+		return ipv6.NewPacketConn(conn).SetHopLimit(ttl)
+	}
+	return ipv4.NewPacketConn(conn).SetTTL(ttl)
+}
+
+// NetworkLayer returns the IPv4 or IPv6 header used to calculate transport checksums.
+func NetworkLayer(srcIP, destIP net.IP, protocol layers.IPProtocol, ttl uint16) gopacket.NetworkLayer {
+	if IsIPv6(destIP) {
+		return &layers.IPv6{
+			Version:    6,
+			SrcIP:      srcIP,
+			DstIP:      destIP,
+			NextHeader: protocol,
+			HopLimit:   uint8(ttl),
+		}
+	}
+	return &layers.IPv4{
+		Version:  4,
+		SrcIP:    srcIP,
+		DstIP:    destIP,
+		Protocol: protocol,
+		TTL:      uint8(ttl),
+	}
+}
+
+// IsTimeExceeded returns true for ICMP and ICMPv6 time exceeded messages.
+func IsTimeExceeded(typ icmp.Type) bool {
+	return typ == ipv4.ICMPTypeTimeExceeded || typ == ipv6.ICMPTypeTimeExceeded
+}
+
+// IsEchoReply returns true for ICMP and ICMPv6 echo replies.
+func IsEchoReply(typ icmp.Type) bool {
+	return typ == ipv4.ICMPTypeEchoReply || typ == ipv6.ICMPTypeEchoReply
+}
+
+// IsDestinationUnreachable returns true for ICMP and ICMPv6 destination unreachable messages.
+func IsDestinationUnreachable(typ icmp.Type) bool {
+	return typ == ipv4.ICMPTypeDestinationUnreachable || typ == ipv6.ICMPTypeDestinationUnreachable
+}
@@ -0,0 +1,64 @@
+package methods
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestReduceFinalResult(t *testing.T) {
+	dest := net.ParseIP("192.0.2.1")
+	router := TracerouteHop{Success: true, Address: &net.IPAddr{IP: net.ParseIP("10.0.0.1")}}
+	reached := TracerouteHop{Success: true, Address: &net.IPAddr{IP: dest}}
+	tests := []struct {
+		name        string
+		preliminary map[uint16][]TracerouteHop
+		maxHops     uint16
+		// want are the TTLs that are kept.
+		want []int
+	}{
+		{
+			name:        "stops at the destination",
+			preliminary: map[uint16][]TracerouteHop{1: {router}, 2: {reached}, 3: {reached}},
+			maxHops:     3,
+			want:        []int{1, 2},
+		},
+		{
+			name:        "keeps the max hop",
+			preliminary: map[uint16][]TracerouteHop{1: {router}, 2: {router}, 3: {router}},
+			maxHops:     3,
+			want:        []int{1, 2, 3},
+		},
+		{
+			name:        "destination at the max hop",
+			preliminary: map[uint16][]TracerouteHop{1: {router}, 2: {reached}},
+			maxHops:     2,
+			want:        []int{1, 2},
+		},
+		{
+			name:        "drops the hops above the max hop",
+			preliminary: map[uint16][]TracerouteHop{1: {router}, 2: {router}, 3: {router}},
+			maxHops:     2,
+			want:        []int{1, 2},
+		},
+		{
+			name:        "stops at a TTL that was not probed",
+			preliminary: map[uint16][]TracerouteHop{1: {router}, 3: {router}},
+			maxHops:     3,
+			want:        []int{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []int{}
+			for ttl := range ReduceFinalResult(tt.preliminary, tt.maxHops, dest) {
+				got = append(got, int(ttl))
+			}
+			sort.Ints(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReduceFinalResult() TTLs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package methods
+
+import (
+	"fmt"
+
+	"golang.org/x/net/icmp"
+)
+
+// MPLSLabel is an entry of the MPLS label stack quoted by a router in an ICMP extension (RFC 4950).
+type MPLSLabel struct {
+	Label int  `json:"label"`
+	TC    int  `json:"tc"`
+	S     bool `json:"s"`
+	TTL   int  `json:"ttl"`
+}
+
+func (l MPLSLabel) String() string {
+	s := 0
+	if l.S {
+		s = 1
+	}
+	return fmt.Sprintf("Lbl %d, TC %d, S %d, TTL %d", l.Label, l.TC, s, l.TTL)
+}
+
+// GetMPLSLabels returns the MPLS label stack from the multi-part extensions (RFC 4884) of a time exceeded or
+// destination unreachable message, the label stacks are returned in the order they were received.
+func GetMPLSLabels(rm *icmp.Message) []MPLSLabel {
+	var extensions []icmp.Extension
+	switch body := rm.Body.(type) {
+	case *icmp.TimeExceeded:
+		extensions = body.Extensions
+	case *icmp.DstUnreach:
+		extensions = body.Extensions
+	default:
+		return nil
+	}
+	var labels []MPLSLabel
+	for _, ext := range extensions {
+		stack, ok := ext.(*icmp.MPLSLabelStack)
+		if !ok {
+			continue
+		}
+		for _, l := range stack.Labels {
+			labels = append(labels, MPLSLabel{Label: l.Label, TC: l.TC, S: l.S, TTL: l.TTL})
+		}
+	}
+	return labels
+}
@@ -0,0 +1,81 @@
+package methods
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func TestGetMPLSLabels(t *testing.T) {
+	// the quoted datagram is padded to 128 bytes when extensions are present (RFC 4884).
+	quoted := make([]byte, 128)
+	quoted[0] = 0x45
+	tests := []struct {
+		name string
+		body icmp.MessageBody
+		want []MPLSLabel
+	}{
+		{
+			name: "no extensions",
+			body: &icmp.TimeExceeded{Data: quoted},
+		},
+		{
+			name: "label stack",
+			body: &icmp.TimeExceeded{
+				Data: quoted,
+				Extensions: []icmp.Extension{
+					&icmp.MPLSLabelStack{
+						Class: 1,
+						Type:  1,
+						Labels: []icmp.MPLSLabel{
+							{Label: 24001, TC: 0, S: false, TTL: 1},
+							{Label: 16, TC: 5, S: true, TTL: 254},
+						},
+					},
+				},
+			},
+			want: []MPLSLabel{
+				{Label: 24001, TC: 0, S: false, TTL: 1},
+				{Label: 16, TC: 5, S: true, TTL: 254},
+			},
+		},
+		{
+			name: "destination unreachable",
+			body: &icmp.DstUnreach{
+				Data: quoted,
+				Extensions: []icmp.Extension{
+					&icmp.MPLSLabelStack{Class: 1, Type: 1, Labels: []icmp.MPLSLabel{{Label: 100, S: true, TTL: 1}}},
+				},
+			},
+			want: []MPLSLabel{{Label: 100, S: true, TTL: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ := icmp.Type(ipv4.ICMPTypeTimeExceeded)
+			if _, ok := tt.body.(*icmp.DstUnreach); ok {
+				typ = ipv4.ICMPTypeDestinationUnreachable
+			}
+			wire, err := (&icmp.Message{Type: typ, Body: tt.body}).Marshal(nil)
+			if err != nil {
+				t.Fatalf("icmp.Message.Marshal() error = %v", err)
+			}
+			rm, err := icmp.ParseMessage(ProtocolICMP, wire)
+			if err != nil {
+				t.Fatalf("icmp.ParseMessage() error = %v", err)
+			}
+			if got := GetMPLSLabels(rm); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetMPLSLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMPLSLabelString(t *testing.T) {
+	label := MPLSLabel{Label: 24001, TC: 5, S: true, TTL: 1}
+	if got := label.String(); got != "Lbl 24001, TC 5, S 1, TTL 1" {
+		t.Errorf("MPLSLabel.String() = %v, want %v", got, "Lbl 24001, TC 5, S 1, TTL 1")
+	}
+}
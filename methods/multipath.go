@@ -0,0 +1,45 @@
+package methods
+
+import (
+	"net"
+	"sort"
+	"time"
+)
+
+// FlowProber is implemented by the traceroute methods that support multipath detection. ProbeFlow sends a
+// single probe using the five-tuple identified by flow and returns once the reply or timeout is received.
+type FlowProber interface {
+	ProbeFlow(flow uint16, ttl uint16) (TracerouteHop, error)
+}
+
+// MultipathInterface is a router interface discovered at a TTL and the flows that were forwarded through it.
+type MultipathInterface struct {
+	Address net.IP          `json:"address"`
+	TTL     uint16          `json:"ttl"`
+	Flows   []uint16        `json:"flows"`
+	RTT     []time.Duration `json:"rtt_ns"`
+}
+
+// MultipathLink connects an interface at TTL to an interface at TTL+1.
+type MultipathLink struct {
+	TTL  uint16 `json:"ttl"`
+	From net.IP `json:"from"`
+	To   net.IP `json:"to"`
+}
+
+// MultipathResult is the topology discovered by the multipath detection algorithm.
+type MultipathResult struct {
+	Hops       map[uint16][]MultipathInterface `json:"hops"`
+	Links      []MultipathLink                 `json:"links"`
+	ProbesSent int                             `json:"probes_sent"`
+}
+
+// Interfaces returns the addresses of the interfaces discovered at the TTL in a stable order.
+func (mr *MultipathResult) Interfaces(ttl uint16) []string {
+	addrs := make([]string, 0, len(mr.Hops[ttl]))
+	for _, iface := range mr.Hops[ttl] {
+		addrs = append(addrs, iface.Address.String())
+	}
+	sort.Strings(addrs)
+	return addrs
+}
@@ -0,0 +1,46 @@
+package methods
+
+import (
+	"net"
+
+	"github.com/jimmystewpot/traceroute/util"
+)
+
+// Network opens the sockets the tracers send the probes and receive the replies on. The tracers use the sockets
+// of the system unless the config sets another network, the tests run the tracers over a simulated network.
+type Network interface {
+	// ListenPacket opens a UDP ("udp") or raw IP ("ip4:tcp", "ip6:tcp") socket on the address.
+	ListenPacket(network, address string) (net.PacketConn, error)
+	// ListenICMP opens the ICMP socket of the address family of the destination, it returns the protocol number
+	// used to parse the received messages.
+	ListenICMP(destIP net.IP) (net.PacketConn, int, error)
+	// LocalIPPort returns the source address used to reach the destination and a free port.
+	LocalIPPort(destIP net.IP) (net.IP, int)
+}
+
+// TTLSetter is implemented by the connections that set the TTL of the probes themselves, SetTTL uses it before
+// falling back to the socket options.
+type TTLSetter interface {
+	SetTTL(ttl int) error
+}
+
+// System is the network of the host, it opens raw sockets so it requires root or CAP_NET_RAW.
+var System Network = systemNetwork{}
+
+type systemNetwork struct{}
+
+func (systemNetwork) ListenPacket(network, address string) (net.PacketConn, error) {
+	return net.ListenPacket(network, address)
+}
+
+func (systemNetwork) ListenICMP(destIP net.IP) (net.PacketConn, int, error) {
+	conn, proto, err := ListenICMP(destIP)
+	if err != nil {
+		return nil, proto, err
+	}
+	return conn, proto, nil
+}
+
+func (systemNetwork) LocalIPPort(destIP net.IP) (net.IP, int) {
+	return util.LocalIPPort(destIP)
+}
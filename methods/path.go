@@ -0,0 +1,136 @@
+package methods
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PathChange describes how the path to a destination changed between two traceroutes.
+type PathChange struct {
+	Destination string      `json:"destination"`
+	IP          net.IP      `json:"ip"`
+	Protocol    string      `json:"protocol"`
+	Hops        []HopChange `json:"hops"`
+	Previous    []string    `json:"previous"`
+	Current     []string    `json:"current"`
+	// LastSeen is the start of the last traceroute that took the previous path.
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// HopChange is a hop where the responding addresses differ, an address is empty when the path did not
+// have the hop.
+type HopChange struct {
+	TTL      uint16 `json:"ttl"`
+	Previous string `json:"previous"`
+	Current  string `json:"current"`
+}
+
+func (hc HopChange) String() string {
+	return fmt.Sprintf("%d: %s -> %s", hc.TTL, hc.Previous, hc.Current)
+}
+
+// PathRecorder keeps the previous results to each destination and reports when the path changes.
+type PathRecorder interface {
+	Record(res *TraceResult) (*PathChange, error)
+}
+
+// DiffPath compares the paths of two traceroutes to the same destination hop by hop, hops that did not
+// reply in either traceroute are ignored. A hop only changed when none of the addresses that replied are the
+// same, a load balanced hop answers from a different subset of its interfaces from one traceroute to the next.
+// It returns nil when the paths are the same.
+func DiffPath(previous, current *TraceResult) *PathChange {
+	before := hopPaths(previous)
+	after := hopPaths(current)
+	ttls := make([]int, 0, len(after))
+	for ttl := range after {
+		ttls = append(ttls, int(ttl))
+	}
+	for ttl := range before {
+		if _, ok := after[ttl]; !ok {
+			ttls = append(ttls, int(ttl))
+		}
+	}
+	sort.Ints(ttls)
+
+	var hops []HopChange
+	for _, ttl := range ttls {
+		b, a := before[uint16(ttl)], after[uint16(ttl)]
+		if b == "*" || a == "*" || overlap(b, a) {
+			continue
+		}
+		hops = append(hops, HopChange{TTL: uint16(ttl), Previous: b, Current: a})
+	}
+	if len(hops) == 0 {
+		return nil
+	}
+	return &PathChange{
+		Destination: current.Destination,
+		IP:          current.IP,
+		Protocol:    current.Protocol,
+		Hops:        hops,
+		Previous:    previous.Path(),
+		Current:     current.Path(),
+		LastSeen:    previous.StartTime,
+	}
+}
+
+// overlap reports whether the hops of two paths share an address, the addresses of a hop are joined by a comma.
+func overlap(before, after string) bool {
+	if before == "" || after == "" {
+		return false
+	}
+	addrs := map[string]bool{}
+	for _, addr := range strings.Split(before, ",") {
+		addrs[addr] = true
+	}
+	for _, addr := range strings.Split(after, ",") {
+		if addrs[addr] {
+			return true
+		}
+	}
+	return false
+}
+
+// hopPaths returns the path of the result indexed by TTL.
+func hopPaths(res *TraceResult) map[uint16]string {
+	path := res.Path()
+	hops := make(map[uint16]string, len(path))
+	for i, hop := range res.Hops {
+		hops[hop.TTL] = path[i]
+	}
+	return hops
+}
+
+// Attributes returns the span attributes describing the path change.
+func (pc *PathChange) Attributes() []attribute.KeyValue {
+	hops := make([]string, 0, len(pc.Hops))
+	for _, hop := range pc.Hops {
+		hops = append(hops, hop.String())
+	}
+	return []attribute.KeyValue{
+		attribute.StringSlice("path.hops", hops),
+		attribute.StringSlice("path.previous", pc.Previous),
+		attribute.StringSlice("path.current", pc.Current),
+		attribute.String("path.last_seen", pc.LastSeen.Format(time.RFC3339)),
+	}
+}
+
+// RecordPath records the result and adds an event to the span when the path to the destination changed.
+func RecordPath(recorder PathRecorder, res *TraceResult, span trace.Span) {
+	if recorder == nil {
+		return
+	}
+	change, err := recorder.Record(res)
+	if err != nil {
+		span.RecordError(err)
+	}
+	if change != nil {
+		span.AddEvent("path-change", trace.WithAttributes(change.Attributes()...))
+	}
+}
@@ -0,0 +1,116 @@
+package methods
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testPath returns a result where each hop was answered by the addresses, an empty hop timed out.
+func testPath(start time.Time, hops ...[]string) *TraceResult {
+	res := &TraceResult{Destination: "example.com", IP: net.ParseIP("192.0.2.1"), Protocol: "udp", StartTime: start}
+	rtt := time.Millisecond
+	for i, addrs := range hops {
+		hop := TraceHop{TTL: uint16(i + 1)}
+		if len(addrs) == 0 {
+			hop.Probes = append(hop.Probes, TracerouteHop{TTL: hop.TTL})
+		}
+		for _, addr := range addrs {
+			hop.Probes = append(hop.Probes, TracerouteHop{
+				Success: true,
+				Address: &net.IPAddr{IP: net.ParseIP(addr)},
+				TTL:     hop.TTL,
+				RTT:     &rtt,
+			})
+		}
+		res.Hops = append(res.Hops, hop)
+	}
+	return res
+}
+
+func TestDiffPath(t *testing.T) {
+	lastSeen := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := lastSeen.Add(time.Minute)
+	tests := []struct {
+		name     string
+		previous *TraceResult
+		current  *TraceResult
+		want     []HopChange
+	}{
+		{
+			name:     "same path",
+			previous: testPath(lastSeen, []string{"10.0.0.1"}, []string{"192.0.2.1"}),
+			current:  testPath(now, []string{"10.0.0.1"}, []string{"192.0.2.1"}),
+		},
+		{
+			name:     "timeouts are ignored",
+			previous: testPath(lastSeen, []string{"10.0.0.1"}, nil, []string{"192.0.2.1"}),
+			current:  testPath(now, nil, []string{"10.0.0.2"}, []string{"192.0.2.1"}, nil),
+		},
+		{
+			name:     "same addresses in a different order",
+			previous: testPath(lastSeen, []string{"10.0.0.1", "10.0.0.2"}),
+			current:  testPath(now, []string{"10.0.0.2", "10.0.0.1"}),
+		},
+		{
+			name:     "load balanced hop answers from fewer interfaces",
+			previous: testPath(lastSeen, []string{"10.0.0.1"}, []string{"10.0.1.1", "10.0.1.2"}, []string{"192.0.2.1"}),
+			current:  testPath(now, []string{"10.0.0.1"}, []string{"10.0.1.2", "10.0.1.2"}, []string{"192.0.2.1"}),
+		},
+		{
+			name:     "load balanced hop answers from more interfaces",
+			previous: testPath(lastSeen, []string{"10.0.0.1"}, []string{"10.0.1.1"}, []string{"192.0.2.1"}),
+			current:  testPath(now, []string{"10.0.0.1"}, []string{"10.0.1.1", "10.0.1.2"}, []string{"192.0.2.1"}),
+		},
+		{
+			name:     "load balanced hop answers from other interfaces",
+			previous: testPath(lastSeen, []string{"10.0.0.1"}, []string{"10.0.1.1", "10.0.1.2"}, []string{"192.0.2.1"}),
+			current:  testPath(now, []string{"10.0.0.1"}, []string{"10.0.1.2", "10.0.1.3"}, []string{"192.0.2.1"}),
+		},
+		{
+			name:     "load balanced hop moved",
+			previous: testPath(lastSeen, []string{"10.0.0.1"}, []string{"10.0.1.1", "10.0.1.2"}, []string{"192.0.2.1"}),
+			current:  testPath(now, []string{"10.0.0.1"}, []string{"10.0.2.1", "10.0.2.2"}, []string{"192.0.2.1"}),
+			want:     []HopChange{{TTL: 2, Previous: "10.0.1.1,10.0.1.2", Current: "10.0.2.1,10.0.2.2"}},
+		},
+		{
+			name:     "hop changed",
+			previous: testPath(lastSeen, []string{"10.0.0.1"}, []string{"10.0.1.1"}, []string{"192.0.2.1"}),
+			current:  testPath(now, []string{"10.0.0.1"}, []string{"10.0.2.1"}, []string{"192.0.2.1"}),
+			want:     []HopChange{{TTL: 2, Previous: "10.0.1.1", Current: "10.0.2.1"}},
+		},
+		{
+			name:     "path is shorter",
+			previous: testPath(lastSeen, []string{"10.0.0.1"}, []string{"10.0.1.1"}, []string{"192.0.2.1"}),
+			current:  testPath(now, []string{"10.0.0.1"}, []string{"192.0.2.1"}),
+			want: []HopChange{
+				{TTL: 2, Previous: "10.0.1.1", Current: "192.0.2.1"},
+				{TTL: 3, Previous: "192.0.2.1", Current: ""},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffPath(tt.previous, tt.current)
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("DiffPath() = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("DiffPath() = nil, want %v", tt.want)
+			}
+			if !reflect.DeepEqual(got.Hops, tt.want) {
+				t.Errorf("DiffPath().Hops = %v, want %v", got.Hops, tt.want)
+			}
+			if !got.LastSeen.Equal(lastSeen) {
+				t.Errorf("DiffPath().LastSeen = %v, want %v", got.LastSeen, lastSeen)
+			}
+			if !reflect.DeepEqual(got.Previous, tt.previous.Path()) || !reflect.DeepEqual(got.Current, tt.current.Path()) {
+				t.Errorf("DiffPath() paths = %v -> %v", got.Previous, got.Current)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package methods
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// DiscardedReplies counts the replies that were received for the probes of a traceroute but not recorded.
+type DiscardedReplies struct {
+	// Mismatched replies carry the key of a probe but quote another destination, protocol or port.
+	Mismatched int `json:"mismatched"`
+	// Late replies arrive after their probe timed out.
+	Late int `json:"late"`
+	// Duplicate replies arrive after their probe was answered.
+	Duplicate int `json:"duplicate"`
+}
+
+// Total returns the number of discarded replies.
+func (d DiscardedReplies) Total() int {
+	return d.Mismatched + d.Late + d.Duplicate
+}
+
+// ProbeTracker remembers how the probes of a traceroute completed so the replies that arrive after their probe
+// are counted, the key is the identifier of the probe used by the tracer.
+type ProbeTracker struct {
+	mu sync.Mutex
+	// completed is true when the probe was answered and false when it timed out.
+	completed map[uint32]bool
+	discarded DiscardedReplies
+}
+
+// NewProbeTracker returns an empty tracker.
+func NewProbeTracker() *ProbeTracker {
+	return &ProbeTracker{completed: map[uint32]bool{}}
+}
+
+// Complete records that the probe was answered or timed out.
+func (t *ProbeTracker) Complete(key uint32, answered bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.completed[key] = answered
+}
+
+// Unmatched counts a reply for a probe that is not in flight, it is late when the probe timed out and a duplicate
+// when it was answered. It returns false without counting the reply when no probe with the key has completed.
+func (t *ProbeTracker) Unmatched(key uint32) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	answered, ok := t.completed[key]
+	switch {
+	case !ok:
+		return false
+	case answered:
+		t.discarded.Duplicate++
+	default:
+		t.discarded.Late++
+	}
+	return true
+}
+
+// Mismatched counts a reply that does not quote the probe of its key.
+func (t *ProbeTracker) Mismatched() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.discarded.Mismatched++
+}
+
+// Discarded returns the counts of the replies that were not recorded.
+func (t *ProbeTracker) Discarded() DiscardedReplies {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.discarded
+}
+
+// QuotedProbe reports whether the transport header quoted by an ICMP error was sent to the destination and port,
+// the ports are the first two 16 bit words of the UDP and TCP headers. The source port is not checked when it is
+// zero. The protocol is matched by the receiver that dispatched the reply.
+func QuotedProbe(dst net.IP, header []byte, destIP net.IP, srcPort, dstPort int) bool {
+	if !dst.Equal(destIP) || len(header) < 4 {
+		return false
+	}
+	if srcPort != 0 && int(binary.BigEndian.Uint16(header[0:2])) != srcPort {
+		return false
+	}
+	return int(binary.BigEndian.Uint16(header[2:4])) == dstPort
+}
@@ -0,0 +1,59 @@
+package methods
+
+import (
+	"net"
+	"testing"
+)
+
+func TestProbeTracker(t *testing.T) {
+	tracker := NewProbeTracker()
+	tracker.Complete(1, true)
+	tracker.Complete(2, false)
+
+	for _, key := range []uint32{1, 1, 2} {
+		if !tracker.Unmatched(key) {
+			t.Errorf("ProbeTracker.Unmatched(%d) = false, want true", key)
+		}
+	}
+	if tracker.Unmatched(3) {
+		t.Errorf("ProbeTracker.Unmatched(3) = true for a probe that was not sent")
+	}
+	tracker.Mismatched()
+
+	want := DiscardedReplies{Mismatched: 1, Late: 1, Duplicate: 2}
+	if got := tracker.Discarded(); got != want {
+		t.Errorf("ProbeTracker.Discarded() = %+v, want %+v", got, want)
+	}
+	if got := want.Total(); got != 4 {
+		t.Errorf("DiscardedReplies.Total() = %d, want 4", got)
+	}
+}
+
+func TestQuotedProbe(t *testing.T) {
+	dest := net.ParseIP("192.0.2.1")
+	// source port 40000, destination port 33434.
+	header := []byte{0x9c, 0x40, 0x82, 0x9a, 0, 8, 0, 0}
+	tests := []struct {
+		name    string
+		dst     net.IP
+		header  []byte
+		srcPort int
+		dstPort int
+		want    bool
+	}{
+		{name: "match", dst: dest, header: header, srcPort: 40000, dstPort: 33434, want: true},
+		{name: "any source port", dst: dest, header: header, dstPort: 33434, want: true},
+		{name: "other destination", dst: net.ParseIP("192.0.2.2"), header: header, dstPort: 33434},
+		{name: "other source port", dst: dest, header: header, srcPort: 40001, dstPort: 33434},
+		{name: "other destination port", dst: dest, header: header, dstPort: 443},
+		{name: "echo reply", dst: nil, header: header, dstPort: 33434},
+		{name: "truncated", dst: dest, header: header[:2], dstPort: 33434},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := QuotedProbe(tt.dst, tt.header, dest, tt.srcPort, tt.dstPort); got != tt.want {
+				t.Errorf("QuotedProbe() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
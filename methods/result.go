@@ -0,0 +1,190 @@
+package methods
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// TraceResult is the result of a traceroute to a single destination IP.
+type TraceResult struct {
+	Destination        string     `json:"destination"`
+	IP                 net.IP     `json:"ip"`
+	Protocol           string     `json:"protocol"`
+	Port               int        `json:"port"`
+	StartTime          time.Time  `json:"start_time"`
+	EndTime            time.Time  `json:"end_time"`
+	Hops               []TraceHop `json:"hops"`
+	ReachedDestination bool       `json:"reached_destination"`
+	Error              string     `json:"error,omitempty"`
+	// Discarded counts the replies that were not recorded for a probe.
+	Discarded DiscardedReplies `json:"discarded"`
+	// Tags are the labels of the destination in the service configuration.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Multipath holds the interfaces and links found by the multipath detection algorithm, the hops then hold
+	// the probes of every flow.
+	Multipath *MultipathResult `json:"multipath,omitempty"`
+}
+
+// TraceHop holds every probe sent with the same TTL.
+type TraceHop struct {
+	TTL    uint16          `json:"ttl"`
+	Probes []TracerouteHop `json:"probes"`
+	Stats  HopStats        `json:"stats"`
+}
+
+// tracerouteHopJSON is the JSON representation of a TracerouteHop, net.Addr can not be unmarshalled.
+type tracerouteHopJSON struct {
+	Success  bool           `json:"success"`
+	Address  string         `json:"address,omitempty"`
+	Hostname string         `json:"hostname,omitempty"`
+	TTL      uint16         `json:"ttl"`
+	RTT      *time.Duration `json:"rtt_ns,omitempty"`
+	ICMP     *ICMPReply     `json:"icmp,omitempty"`
+	AS       *ASInfo        `json:"as,omitempty"`
+}
+
+// NewTraceResult returns a result for the traceroute that is starting now.
+func NewTraceResult(destination string, ip net.IP, protocol string, port int) *TraceResult {
+	return &TraceResult{
+		Destination: destination,
+		IP:          ip,
+		Protocol:    protocol,
+		Port:        port,
+		StartTime:   time.Now(),
+		Hops:        []TraceHop{},
+	}
+}
+
+// Finish reduces the probes to the hops up to the destination, err is the error that stopped the traceroute.
+func (res *TraceResult) Finish(preliminary map[uint16][]TracerouteHop, maxHops uint16, err error) {
+	res.EndTime = time.Now()
+	if err != nil {
+		res.Error = err.Error()
+	}
+	reduced := ReduceFinalResult(preliminary, maxHops, res.IP)
+	ttls := make([]int, 0, len(reduced))
+	for ttl := range reduced {
+		ttls = append(ttls, int(ttl))
+	}
+	sort.Ints(ttls)
+	res.Hops = make([]TraceHop, 0, len(ttls))
+	for _, ttl := range ttls {
+		probes := reduced[uint16(ttl)]
+		for _, probe := range probes {
+			if probe.Success && probe.Address.String() == res.IP.String() {
+				res.ReachedDestination = true
+			}
+		}
+		res.Hops = append(res.Hops, TraceHop{TTL: uint16(ttl), Probes: probes, Stats: NewHopStats(probes)})
+	}
+}
+
+// Duration returns the time taken by the traceroute.
+func (res *TraceResult) Duration() time.Duration {
+	return res.EndTime.Sub(res.StartTime)
+}
+
+// HopMap returns the probes indexed by TTL, this is the format returned by the tracers before TraceResult.
+func (res *TraceResult) HopMap() map[uint16][]TracerouteHop {
+	hops := make(map[uint16][]TracerouteHop, len(res.Hops))
+	for _, hop := range res.Hops {
+		hops[hop.TTL] = hop.Probes
+	}
+	return hops
+}
+
+// Path returns the addresses that replied at each hop, the addresses of a hop are sorted and joined by a
+// comma and a hop without any reply is a single asterisk.
+func (res *TraceResult) Path() []string {
+	path := make([]string, 0, len(res.Hops))
+	for _, hop := range res.Hops {
+		addrs := []string{}
+		seen := map[string]bool{}
+		for _, probe := range hop.Probes {
+			if !probe.Success || probe.Address == nil {
+				continue
+			}
+			addr := AddrIP(probe.Address).String()
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+		if len(addrs) == 0 {
+			path = append(path, "*")
+			continue
+		}
+		sort.Strings(addrs)
+		path = append(path, strings.Join(addrs, ","))
+	}
+	return path
+}
+
+// MarshalJSON renders the address as a string.
+func (th TracerouteHop) MarshalJSON() ([]byte, error) {
+	hop := tracerouteHopJSON{
+		Success:  th.Success,
+		TTL:      th.TTL,
+		RTT:      th.RTT,
+		ICMP:     th.ICMP,
+		Hostname: th.Hostname,
+		AS:       th.AS,
+	}
+	if th.Address != nil {
+		hop.Address = AddrIP(th.Address).String()
+	}
+	return json.Marshal(hop)
+}
+
+// UnmarshalJSON restores the address as a *net.IPAddr.
+func (th *TracerouteHop) UnmarshalJSON(data []byte) error {
+	var hop tracerouteHopJSON
+	err := json.Unmarshal(data, &hop)
+	if err != nil {
+		return err
+	}
+	*th = TracerouteHop{
+		Success:  hop.Success,
+		TTL:      hop.TTL,
+		RTT:      hop.RTT,
+		ICMP:     hop.ICMP,
+		Hostname: hop.Hostname,
+		AS:       hop.AS,
+	}
+	if hop.Address != "" {
+		th.Address = &net.IPAddr{IP: net.ParseIP(hop.Address)}
+	}
+	return nil
+}
+
+// AddrIP returns the IP address of a hop address.
+func AddrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	return net.ParseIP(addr.String())
+}
+
+// TagAttributes returns the tags as span attributes prefixed with tag. and sorted by name.
+func TagAttributes(tags map[string]string) []attribute.KeyValue {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	attrs := make([]attribute.KeyValue, 0, len(tags))
+	for _, name := range names {
+		attrs = append(attrs, attribute.String("tag."+name, tags[name]))
+	}
+	return attrs
+}
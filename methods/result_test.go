@@ -0,0 +1,91 @@
+package methods
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTraceResultFinish(t *testing.T) {
+	rtt := 10 * time.Millisecond
+	destIP := net.ParseIP("192.0.2.1")
+	preliminary := map[uint16][]TracerouteHop{
+		1: {{Success: true, Address: &net.IPAddr{IP: net.ParseIP("10.0.0.1")}, TTL: 1, RTT: &rtt}},
+		2: {{Success: false, TTL: 2}},
+		3: {{Success: true, Address: &net.IPAddr{IP: destIP}, TTL: 3, RTT: &rtt}},
+		4: {{Success: true, Address: &net.IPAddr{IP: destIP}, TTL: 4, RTT: &rtt}},
+	}
+	tests := []struct {
+		name        string
+		err         error
+		wantHops    []uint16
+		wantReached bool
+		wantError   string
+	}{
+		{
+			name:        "reduced to the destination",
+			wantHops:    []uint16{1, 2, 3},
+			wantReached: true,
+		},
+		{
+			name:        "error recorded",
+			err:         errors.New("socket closed"),
+			wantHops:    []uint16{1, 2, 3},
+			wantReached: true,
+			wantError:   "socket closed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := NewTraceResult("example.com", destIP, "udp", 33434)
+			res.Finish(preliminary, 30, tt.err)
+			got := []uint16{}
+			for _, hop := range res.Hops {
+				got = append(got, hop.TTL)
+			}
+			if !reflect.DeepEqual(got, tt.wantHops) {
+				t.Errorf("TraceResult.Finish() hops = %v, want %v", got, tt.wantHops)
+			}
+			if res.ReachedDestination != tt.wantReached {
+				t.Errorf("TraceResult.Finish() reached = %v, want %v", res.ReachedDestination, tt.wantReached)
+			}
+			if res.Error != tt.wantError {
+				t.Errorf("TraceResult.Finish() error = %v, want %v", res.Error, tt.wantError)
+			}
+			if len(res.HopMap()) != len(tt.wantHops) {
+				t.Errorf("TraceResult.HopMap() = %v, want %d hops", res.HopMap(), len(tt.wantHops))
+			}
+		})
+	}
+}
+
+func TestTraceResultJSON(t *testing.T) {
+	rtt := 10 * time.Millisecond
+	res := NewTraceResult("example.com", net.ParseIP("192.0.2.1"), "tcp", 443)
+	res.Finish(map[uint16][]TracerouteHop{
+		1: {
+			{Success: true, Address: &net.IPAddr{IP: net.ParseIP("10.0.0.1")}, TTL: 1, RTT: &rtt},
+			{Success: false, TTL: 1},
+		},
+	}, 30, nil)
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var got TraceResult
+	err = json.Unmarshal(data, &got)
+	if err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if got.Destination != res.Destination || !got.IP.Equal(res.IP) || got.Port != res.Port || !got.StartTime.Equal(res.StartTime) {
+		t.Errorf("json round trip = %+v, want %+v", got, res)
+	}
+	probes := got.Hops[0].Probes
+	if probes[0].Address.String() != "10.0.0.1" || *probes[0].RTT != rtt || probes[1].Address != nil || probes[1].RTT != nil {
+		t.Errorf("json round trip probes = %+v, want %+v", probes, res.Hops[0].Probes)
+	}
+}
@@ -0,0 +1,162 @@
+package simnet
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+// packet is a reply waiting to be read from a connection.
+type packet struct {
+	data []byte
+	peer net.Addr
+}
+
+// conn is a connection of the simulated network, it implements methods.TTLSetter so the tracers set the TTL of
+// the probes without socket options.
+type conn struct {
+	network *Network
+	kind    string
+	family  int
+	port    int
+
+	mu       sync.Mutex
+	ttl      int
+	deadline time.Time
+
+	in        chan packet
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *conn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p := <-c.in:
+		return copy(b, p.data), p.peer, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo sends the probe into the network, the probe is routed with the TTL set on the connection.
+func (c *conn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if c.network.SendErr != nil {
+		return 0, &net.OpError{Op: "write", Net: c.kind, Addr: addr, Err: c.network.SendErr}
+	}
+	c.mu.Lock()
+	p := probe{from: c, src: c.network.Source, ttl: c.ttl, data: append([]byte(nil), b...)}
+	c.mu.Unlock()
+
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		p.dst, p.srcPort, p.dstPort = a.IP, c.port, a.Port
+		header, err := udpHeader(p)
+		if err != nil {
+			return 0, err
+		}
+		p.header = header
+	case *net.IPAddr:
+		p.dst = a.IP
+		if len(b) < transportHeader {
+			return 0, &net.OpError{Op: "write", Net: c.kind, Addr: addr, Err: os.ErrInvalid}
+		}
+		p.header = p.data[:transportHeader]
+	default:
+		return 0, &net.OpError{Op: "write", Net: c.kind, Addr: addr, Err: os.ErrInvalid}
+	}
+	c.network.route(p)
+	return len(b), nil
+}
+
+// udpHeader returns the header the kernel would send with the payload of a UDP probe.
+func udpHeader(p probe) ([]byte, error) {
+	udp := &layers.UDP{
+		SrcPort: layers.UDPPort(p.srcPort),
+		DstPort: layers.UDPPort(p.dstPort),
+	}
+	_ = udp.SetNetworkLayerForChecksum(methods.NetworkLayer(p.src, p.dst, layers.IPProtocolUDP, 0))
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}, udp, gopacket.Payload(p.data))
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte(nil), buf.Bytes()[:transportHeader]...)
+	// a computed checksum of zero is transmitted as all ones (RFC 768).
+	if binary.BigEndian.Uint16(header[6:8]) == 0 {
+		binary.BigEndian.PutUint16(header[6:8], 0xffff)
+	}
+	return header, nil
+}
+
+// enqueue adds a reply to the connection, it is dropped when the connection is closed or its queue is full.
+func (c *conn) enqueue(p packet) {
+	select {
+	case <-c.closed:
+	case c.in <- p:
+	default:
+	}
+}
+
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.network.remove(c)
+	})
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	if c.kind == kindUDP {
+		return &net.UDPAddr{IP: c.network.Source, Port: c.port}
+	}
+	return &net.IPAddr{IP: c.network.Source}
+}
+
+func (c *conn) SetTTL(ttl int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	return nil
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(time.Time) error {
+	return nil
+}
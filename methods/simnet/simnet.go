@@ -0,0 +1,414 @@
+// Package simnet is an in-memory network used to test the tracers without raw sockets. The probes travel along a
+// chain of routers, the router where the TTL of a probe expires replies with an ICMP time exceeded and the last
+// router is the destination of the traceroute.
+package simnet
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/jimmystewpot/traceroute/methods"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// firstPort is the first port handed out by the network, the ports are not reused.
+	firstPort int = 40000
+	// queueLength is the number of packets a connection holds before the next ones are dropped.
+	queueLength       int   = 64
+	transportHeader   int   = 8
+	icmpv4PortUnreach uint8 = 3
+	icmpv6PortUnreach uint8 = 4
+)
+
+const (
+	kindUDP  = "udp"
+	kindTCP  = "tcp"
+	kindICMP = "icmp"
+)
+
+// Router is a hop on the path to the destination.
+type Router struct {
+	Addr net.IP
+	// Delay is the round trip time of the replies from the router.
+	Delay time.Duration
+	// Loss is the fraction of the replies from the router that are lost, the lost replies are spread evenly so
+	// the result is the same on every run. The destination also answers the probes sent past it, so the loss
+	// of its replies is only repeatable when the probes past it are not sent.
+	Loss float64
+	// RateLimit is the number of ICMP messages the router sends per second, zero is unlimited.
+	RateLimit int
+	// Silent routers forward the probes but do not reply when the TTL of a probe expires.
+	Silent bool
+	// Unreachable routers do not forward the probes, they reply with a destination unreachable of the Code.
+	Unreachable bool
+	Code        uint8
+	// Duplicate routers send every reply twice.
+	Duplicate bool
+	// QuoteDst replaces the destination quoted by the ICMP errors of the router, like a NAT that does not
+	// translate the quoted header.
+	QuoteDst net.IP
+}
+
+// routerState is the number of replies sent by a router, it is used for the loss and the rate limit.
+type routerState struct {
+	replies int
+	window  time.Time
+	sent    int
+}
+
+// Network is a simulated network, it implements methods.Network so it can be set in the config of the tracers.
+type Network struct {
+	// Source is the address of the host running the traceroute.
+	Source net.IP
+	// SendErr is returned by every write when it is set, like a host without a route to the destination.
+	SendErr error
+	routers []Router
+
+	mu    sync.Mutex
+	state []routerState
+	conns map[*conn]struct{}
+	port  int
+}
+
+// New returns a network where the probes sent from source travel through the routers, the last router is the
+// destination.
+func New(source net.IP, routers ...Router) *Network {
+	return &Network{
+		Source:  source,
+		routers: routers,
+		state:   make([]routerState, len(routers)),
+		conns:   map[*conn]struct{}{},
+		port:    firstPort,
+	}
+}
+
+// ListenPacket opens a UDP ("udp") or raw TCP ("ip4:tcp", "ip6:tcp") connection.
+func (n *Network) ListenPacket(network, address string) (net.PacketConn, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, err
+		}
+		return n.open(kindUDP, family(net.ParseIP(host)), p)
+	case "ip4:tcp":
+		return n.open(kindTCP, 4, 0)
+	case "ip6:tcp":
+		return n.open(kindTCP, 6, 0)
+	}
+	return nil, fmt.Errorf("simnet: network %s is not supported", network)
+}
+
+// ListenICMP opens the ICMP connection of the address family of the destination.
+func (n *Network) ListenICMP(destIP net.IP) (net.PacketConn, int, error) {
+	proto := methods.ProtocolICMP
+	if methods.IsIPv6(destIP) {
+		proto = methods.ProtocolIPv6ICMP
+	}
+	c, err := n.open(kindICMP, family(destIP), 0)
+	if err != nil {
+		return nil, proto, err
+	}
+	return c, proto, nil
+}
+
+// LocalIPPort returns the source address and a port that is not used.
+func (n *Network) LocalIPPort(destIP net.IP) (net.IP, int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.Source, n.nextPort()
+}
+
+// nextPort returns the next port, it is called with the lock held.
+func (n *Network) nextPort() int {
+	n.port++
+	return n.port
+}
+
+func (n *Network) open(kind string, fam, port int) (*conn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if kind == kindUDP {
+		if port == 0 {
+			port = n.nextPort()
+		}
+		for c := range n.conns {
+			if c.kind == kindUDP && c.port == port {
+				return nil, fmt.Errorf("simnet: port %d is in use", port)
+			}
+		}
+	}
+	c := &conn{
+		network: n,
+		kind:    kind,
+		family:  fam,
+		port:    port,
+		in:      make(chan packet, queueLength),
+		closed:  make(chan struct{}),
+	}
+	n.conns[c] = struct{}{}
+	return c, nil
+}
+
+func (n *Network) remove(c *conn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.conns, c)
+}
+
+// probe is a packet sent by a connection.
+type probe struct {
+	from    *conn
+	src     net.IP
+	dst     net.IP
+	ttl     int
+	data    []byte
+	header  []byte
+	srcPort int
+	dstPort int
+}
+
+// route forwards the probe along the routers until the TTL expires, a router does not forward it or it reaches
+// the destination.
+func (n *Network) route(p probe) {
+	last := len(n.routers) - 1
+	for i, router := range n.routers {
+		switch {
+		case router.Unreachable:
+			n.icmpError(i, p, unreachableType(p.dst), router.Code)
+			return
+		case i == last:
+			n.arrive(i, p)
+			return
+		case p.ttl == i+1:
+			if !router.Silent {
+				n.icmpError(i, p, timeExceededType(p.dst), 0)
+			}
+			return
+		}
+	}
+}
+
+// arrive answers the probe from the destination.
+func (n *Network) arrive(i int, p probe) {
+	if n.routers[i].Silent {
+		return
+	}
+	switch p.from.kind {
+	case kindUDP:
+		code := icmpv4PortUnreach
+		if methods.IsIPv6(p.dst) {
+			code = icmpv6PortUnreach
+		}
+		n.icmpError(i, p, unreachableType(p.dst), code)
+	case kindTCP:
+		n.synAck(i, p)
+	case kindICMP:
+		n.echoReply(i, p)
+	}
+}
+
+// icmpError replies with an ICMP error quoting the IP header and the start of the transport header of the probe.
+func (n *Network) icmpError(i int, p probe, typ icmp.Type, code uint8) {
+	if !n.reply(i, true) {
+		return
+	}
+	if quoteDst := n.routers[i].QuoteDst; quoteDst != nil {
+		p.dst = quoteDst
+	}
+	quote, err := quoteProbe(p)
+	if err != nil {
+		return
+	}
+	msg := icmp.Message{Type: typ, Code: int(code)}
+	if methods.IsTimeExceeded(typ) {
+		msg.Body = &icmp.TimeExceeded{Data: quote}
+	} else {
+		msg.Body = &icmp.DstUnreach{Data: quote}
+	}
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return
+	}
+	n.deliver(i, kindICMP, family(p.dst), data)
+}
+
+// synAck replies to a TCP SYN probe with a SYN ACK acknowledging its sequence number.
+func (n *Network) synAck(i int, p probe) {
+	if !n.reply(i, false) {
+		return
+	}
+	syn := gopacket.NewPacket(p.data, layers.LayerTypeTCP, gopacket.Default).Layer(layers.LayerTypeTCP)
+	if syn == nil {
+		return
+	}
+	tcp := &layers.TCP{
+		SrcPort: syn.(*layers.TCP).DstPort,
+		DstPort: syn.(*layers.TCP).SrcPort,
+		Ack:     syn.(*layers.TCP).Seq + 1,
+		SYN:     true,
+		ACK:     true,
+		Window:  65535,
+	}
+	_ = tcp.SetNetworkLayerForChecksum(methods.NetworkLayer(p.dst, p.src, layers.IPProtocolTCP, 0))
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}, tcp); err != nil {
+		return
+	}
+	n.deliver(i, kindTCP, family(p.dst), buf.Bytes())
+}
+
+// echoReply replies to an ICMP echo request probe.
+func (n *Network) echoReply(i int, p probe) {
+	if !n.reply(i, true) {
+		return
+	}
+	proto, typ := methods.ProtocolICMP, icmp.Type(ipv4.ICMPTypeEchoReply)
+	if methods.IsIPv6(p.dst) {
+		proto, typ = methods.ProtocolIPv6ICMP, ipv6.ICMPTypeEchoReply
+	}
+	request, err := icmp.ParseMessage(proto, p.data)
+	if err != nil {
+		return
+	}
+	echo, ok := request.Body.(*icmp.Echo)
+	if !ok {
+		return
+	}
+	data, err := (&icmp.Message{Type: typ, Body: echo}).Marshal(nil)
+	if err != nil {
+		return
+	}
+	n.deliver(i, kindICMP, family(p.dst), data)
+}
+
+// reply returns false when the reply of the router is lost or, for ICMP messages, rate limited.
+func (n *Network) reply(i int, isICMP bool) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	router, state := n.routers[i], &n.state[i]
+	if isICMP && router.RateLimit > 0 {
+		now := time.Now()
+		if now.Sub(state.window) >= time.Second {
+			state.window, state.sent = now, 0
+		}
+		if state.sent >= router.RateLimit {
+			return false
+		}
+		state.sent++
+	}
+	r := float64(state.replies)
+	state.replies++
+	return math.Floor((r+1)*router.Loss) <= math.Floor(r*router.Loss)
+}
+
+// deliver hands the reply to the connections of the kind after the delay of the router, every raw connection
+// receives a copy as it would from the kernel.
+func (n *Network) deliver(i int, kind string, fam int, data []byte) {
+	router := n.routers[i]
+	peer := &net.IPAddr{IP: router.Addr}
+	copies := 1
+	if router.Duplicate {
+		copies = 2
+	}
+	time.AfterFunc(router.Delay, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		for c := range n.conns {
+			if c.kind == kind && c.family == fam {
+				for j := 0; j < copies; j++ {
+					c.enqueue(packet{data: data, peer: peer})
+				}
+			}
+		}
+	})
+}
+
+// quoteProbe returns the IP header of the probe followed by the first 8 bytes of its transport header.
+func quoteProbe(p probe) ([]byte, error) {
+	proto := layers.IPProtocolICMPv4
+	switch {
+	case p.from.kind == kindUDP:
+		proto = layers.IPProtocolUDP
+	case p.from.kind == kindTCP:
+		proto = layers.IPProtocolTCP
+	case methods.IsIPv6(p.dst):
+		proto = layers.IPProtocolICMPv6
+	}
+	ip, ok := methods.NetworkLayer(p.src, p.dst, proto, 1).(gopacket.SerializableLayer)
+	if !ok {
+		return nil, errors.New("simnet: the network layer can not be serialized")
+	}
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}, ip, gopacket.Payload(p.header))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func timeExceededType(dst net.IP) icmp.Type {
+	if methods.IsIPv6(dst) {
+		return ipv6.ICMPTypeTimeExceeded
+	}
+	return ipv4.ICMPTypeTimeExceeded
+}
+
+func unreachableType(dst net.IP) icmp.Type {
+	if methods.IsIPv6(dst) {
+		return ipv6.ICMPTypeDestinationUnreachable
+	}
+	return ipv4.ICMPTypeDestinationUnreachable
+}
+
+func family(ip net.IP) int {
+	if ip != nil && methods.IsIPv6(ip) {
+		return 6
+	}
+	return 4
+}
+
+// Summary describes the hops of a result without the RTTs so that tests can compare it, each hop is the TTL
+// followed by the addresses that replied, with their marker, and a * for each timeout.
+func Summary(res *methods.TraceResult) []string {
+	summary := make([]string, 0, len(res.Hops))
+	for _, hop := range res.Hops {
+		var replies []string
+		timeouts := 0
+		for _, probe := range hop.Probes {
+			if !probe.Success {
+				timeouts++
+				continue
+			}
+			reply := methods.AddrIP(probe.Address).String()
+			if marker := probe.Marker(); marker != "" {
+				reply += " " + marker
+			}
+			replies = append(replies, reply)
+		}
+		// the probes complete in any order, the replies are sorted and the timeouts are last.
+		sort.Strings(replies)
+		line := append([]string{strconv.Itoa(int(hop.TTL))}, replies...)
+		for ; timeouts > 0; timeouts-- {
+			line = append(line, "*")
+		}
+		summary = append(summary, strings.Join(line, " "))
+	}
+	return summary
+}
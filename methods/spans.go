@@ -0,0 +1,160 @@
+package methods
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SpanLayout selects how the probes of a traceroute are exported as spans.
+type SpanLayout string
+
+const (
+	// SpanLayoutProbe exports every probe as a span under the traceroute span, named after it, and adds a span
+	// with the statistics of each hop. It is the layout of the earlier releases and the default.
+	SpanLayoutProbe SpanLayout = "probe"
+	// SpanLayoutHop exports a span per TTL under the traceroute span, named with the TTL and the responders, with
+	// the probes of the TTL as its children.
+	SpanLayoutHop SpanLayout = "hop"
+)
+
+// ProbeSpans starts the spans of the probes of a traceroute in the layout of the config, it is safe for
+// concurrent use by the probes.
+type ProbeSpans struct {
+	ctx    context.Context
+	tracer trace.Tracer
+	name   string
+	layout SpanLayout
+	// attrs are the network attributes of the hop and probe spans of the hop layout.
+	attrs []attribute.KeyValue
+
+	mu   sync.Mutex
+	hops map[uint16]*hopSpan
+}
+
+// hopSpan is the span of a TTL in the hop layout.
+type hopSpan struct {
+	ctx    context.Context
+	span   trace.Span
+	probes int
+	// end is when the last probe of the TTL completed.
+	end time.Time
+}
+
+// NewProbeSpans returns the spans of the probes of the traceroute span started in ctx with the name, the
+// protocol and destination set the network attributes of the hop layout.
+func NewProbeSpans(ctx context.Context, cfg *TracerouteConfig, name, protocol string, destIP net.IP) *ProbeSpans {
+	layout := cfg.SpanLayout
+	if layout == "" {
+		layout = SpanLayoutProbe
+	}
+	attrs := []attribute.KeyValue{semconv.NetworkTypeIpv4}
+	if destIP.To4() == nil {
+		attrs[0] = semconv.NetworkTypeIpv6
+	}
+	// ICMP is not a transport in the semantic conventions.
+	switch protocol {
+	case "udp":
+		attrs = append(attrs, semconv.NetworkTransportUDP)
+	case "tcp":
+		attrs = append(attrs, semconv.NetworkTransportTCP)
+	}
+	return &ProbeSpans{
+		ctx:    ctx,
+		tracer: cfg.Tracer,
+		name:   name,
+		layout: layout,
+		attrs:  attrs,
+		hops:   map[uint16]*hopSpan{},
+	}
+}
+
+// Start starts the span of a probe sent with the TTL, in the hop layout the span of the TTL is started with
+// its first probe.
+func (p *ProbeSpans) Start(ttl uint16, opts ...trace.SpanStartOption) trace.Span {
+	if p.layout != SpanLayoutHop {
+		_, span := p.tracer.Start(p.ctx, p.name, opts...)
+		return span
+	}
+	p.mu.Lock()
+	hop, ok := p.hops[ttl]
+	if !ok {
+		hop = &hopSpan{}
+		hop.ctx, hop.span = p.tracer.Start(p.ctx, fmt.Sprintf("hop %d", ttl),
+			trace.WithAttributes(append([]attribute.KeyValue{attribute.Int64("ttl", int64(ttl))}, p.attrs...)...),
+		)
+		p.hops[ttl] = hop
+	}
+	hop.probes++
+	name := fmt.Sprintf("probe %d", hop.probes)
+	p.mu.Unlock()
+
+	_, span := p.tracer.Start(hop.ctx, name, append(opts, trace.WithAttributes(p.attrs...))...)
+	return span
+}
+
+// RecordProbe sets the reply of a probe on its span in the hop layout, the RTT is in milliseconds. It returns
+// false without changing the span in the probe layout, where the tracer sets the attributes it always has.
+func (p *ProbeSpans) RecordProbe(span trace.Span, probe TracerouteHop) bool {
+	if p.layout != SpanLayoutHop {
+		return false
+	}
+	p.mu.Lock()
+	if hop, ok := p.hops[probe.TTL]; ok {
+		hop.end = time.Now()
+	}
+	p.mu.Unlock()
+
+	if !probe.Success {
+		span.SetStatus(codes.Error, "timeout")
+		return true
+	}
+	span.SetAttributes(semconv.NetworkPeerAddress(AddrIP(probe.Address).String()))
+	if probe.RTT != nil {
+		span.SetAttributes(attribute.Float64("rtt_ms", Milliseconds(*probe.RTT)))
+	}
+	span.SetAttributes(probe.ICMPAttributes()...)
+	span.SetStatus(codes.Ok, "success")
+	return true
+}
+
+// End ends the spans of the hops once the probes are reduced to the result, the spans are named with the
+// responders of the hop and carry its statistics. In the probe layout a span is added for each hop instead.
+func (p *ProbeSpans) End(res *TraceResult) {
+	if p.layout != SpanLayoutHop {
+		RecordHopSpans(p.ctx, p.tracer, p.name, res)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, hop := range res.Hops {
+		span, ok := p.hops[hop.TTL]
+		if !ok {
+			continue
+		}
+		responders := "*"
+		if len(hop.Stats.Responders) > 0 {
+			responders = strings.Join(hop.Stats.Responders, ",")
+			span.span.SetAttributes(semconv.NetworkPeerAddress(hop.Stats.Responders[0]))
+		}
+		span.span.SetName(fmt.Sprintf("hop %d %s", hop.TTL, responders))
+		span.span.SetAttributes(hop.Stats.Attributes()...)
+	}
+	// the TTLs after the destination are not in the result, their spans only hold the probes.
+	for _, hop := range p.hops {
+		if hop.end.IsZero() {
+			hop.span.End()
+			continue
+		}
+		hop.span.End(trace.WithTimestamp(hop.end))
+	}
+	p.hops = map[uint16]*hopSpan{}
+}
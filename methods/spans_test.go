@@ -0,0 +1,103 @@
+package methods
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestProbeSpans(t *testing.T) {
+	rtt := 2 * time.Millisecond
+	dest := net.ParseIP("192.0.2.1")
+	probes := []TracerouteHop{
+		{Success: true, Address: &net.IPAddr{IP: net.ParseIP("10.0.0.1")}, TTL: 1, RTT: &rtt},
+		{TTL: 1},
+		{TTL: 2},
+	}
+	tests := []struct {
+		name   string
+		layout SpanLayout
+		// want are the ended spans as the name of their parent and their own name.
+		want []string
+	}{
+		{
+			name: "probe",
+			want: []string{
+				"traceroute > host/traceroute/192.0.2.1",
+				"traceroute > host/traceroute/192.0.2.1",
+				"traceroute > host/traceroute/192.0.2.1",
+				"traceroute > host/traceroute/192.0.2.1/hop/1",
+				"traceroute > host/traceroute/192.0.2.1/hop/2",
+			},
+		},
+		{
+			name:   "hop",
+			layout: SpanLayoutHop,
+			want: []string{
+				"hop 1 10.0.0.1 > probe 1",
+				"hop 1 10.0.0.1 > probe 2",
+				"hop 2 * > probe 1",
+				"traceroute > hop 1 10.0.0.1",
+				"traceroute > hop 2 *",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+			ctx, parent := tracer.Start(context.Background(), "traceroute")
+			spans := NewProbeSpans(ctx, &TracerouteConfig{Tracer: tracer, SpanLayout: tt.layout}, "host/traceroute/192.0.2.1", "udp", dest)
+
+			hops := map[uint16][]TracerouteHop{}
+			for _, probe := range probes {
+				span := spans.Start(probe.TTL)
+				if recorded := spans.RecordProbe(span, probe); recorded != (tt.layout == SpanLayoutHop) {
+					t.Errorf("ProbeSpans.RecordProbe() = %v in the %s layout", recorded, tt.name)
+				}
+				span.End()
+				hops[probe.TTL] = append(hops[probe.TTL], probe)
+			}
+			res := NewTraceResult("example.com", dest, "udp", 33434)
+			res.Finish(hops, 2, nil)
+			spans.End(res)
+			parent.End()
+
+			ended := recorder.Ended()
+			names := map[trace.SpanID]string{}
+			for _, span := range ended {
+				names[span.SpanContext().SpanID()] = span.Name()
+			}
+			got := []string{}
+			for _, span := range ended {
+				if span.Parent().IsValid() {
+					got = append(got, names[span.Parent().SpanID()]+" > "+span.Name())
+				}
+				attrs := attribute.NewSet(span.Attributes()...)
+				if span.Name() == "probe 1" && names[span.Parent().SpanID()] == "hop 1 10.0.0.1" {
+					if rtt, _ := attrs.Value("rtt_ms"); rtt.AsFloat64() != 2 {
+						t.Errorf("probe rtt_ms = %v, want 2", rtt.AsFloat64())
+					}
+					if peer, _ := attrs.Value("network.peer.address"); peer.AsString() != "10.0.0.1" {
+						t.Errorf("probe network.peer.address = %q, want 10.0.0.1", peer.AsString())
+					}
+					if transport, _ := attrs.Value("network.transport"); transport.AsString() != "udp" {
+						t.Errorf("probe network.transport = %q, want udp", transport.AsString())
+					}
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ProbeSpans spans = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
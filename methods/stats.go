@@ -0,0 +1,130 @@
+package methods
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HopStats summarises the probes sent with a TTL the same way as mtr.
+type HopStats struct {
+	Sent     int `json:"sent"`
+	Received int `json:"received"`
+	// Loss is the percentage of the probes that were not answered.
+	Loss   float64       `json:"loss_pct"`
+	Min    time.Duration `json:"min_rtt_ns"`
+	Avg    time.Duration `json:"avg_rtt_ns"`
+	Median time.Duration `json:"median_rtt_ns"`
+	Max    time.Duration `json:"max_rtt_ns"`
+	StdDev time.Duration `json:"stddev_rtt_ns"`
+	// Jitter is the mean difference between the RTTs of consecutive replies.
+	Jitter time.Duration `json:"jitter_ns"`
+	// Responders are the distinct addresses that replied, in the order of their first reply.
+	Responders []string `json:"responders,omitempty"`
+}
+
+// NewHopStats returns the statistics of the probes of a hop, the RTTs are taken in the order of the probes.
+func NewHopStats(probes []TracerouteHop) HopStats {
+	stats := HopStats{Sent: len(probes)}
+	rtts := make([]time.Duration, 0, len(probes))
+	seen := map[string]bool{}
+	for _, probe := range probes {
+		if !probe.Success {
+			continue
+		}
+		stats.Received++
+		if probe.Address != nil {
+			addr := AddrIP(probe.Address).String()
+			if !seen[addr] {
+				seen[addr] = true
+				stats.Responders = append(stats.Responders, addr)
+			}
+		}
+		if probe.RTT != nil {
+			rtts = append(rtts, *probe.RTT)
+		}
+	}
+	if stats.Sent > 0 {
+		stats.Loss = float64(stats.Sent-stats.Received) / float64(stats.Sent) * 100
+	}
+	if len(rtts) == 0 {
+		return stats
+	}
+
+	var sum, jitter time.Duration
+	for i, rtt := range rtts {
+		sum += rtt
+		if i > 0 {
+			jitter += absDuration(rtt - rtts[i-1])
+		}
+	}
+	stats.Avg = sum / time.Duration(len(rtts))
+	if len(rtts) > 1 {
+		stats.Jitter = jitter / time.Duration(len(rtts)-1)
+	}
+	var variance float64
+	for _, rtt := range rtts {
+		d := float64(rtt - stats.Avg)
+		variance += d * d
+	}
+	stats.StdDev = time.Duration(math.Sqrt(variance / float64(len(rtts))))
+
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	stats.Min, stats.Max = rtts[0], rtts[len(rtts)-1]
+	stats.Median = rtts[len(rtts)/2]
+	if len(rtts)%2 == 0 {
+		stats.Median = (rtts[len(rtts)/2-1] + rtts[len(rtts)/2]) / 2
+	}
+	return stats
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Attributes returns the statistics as span attributes, the RTTs are in milliseconds.
+func (s HopStats) Attributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.Int("hop.sent", s.Sent),
+		attribute.Int("hop.received", s.Received),
+		attribute.Float64("hop.loss_pct", s.Loss),
+		attribute.StringSlice("hop.responders", s.Responders),
+	}
+	if s.Received == 0 {
+		return attrs
+	}
+	return append(attrs,
+		attribute.Float64("hop.rtt.min_ms", Milliseconds(s.Min)),
+		attribute.Float64("hop.rtt.avg_ms", Milliseconds(s.Avg)),
+		attribute.Float64("hop.rtt.median_ms", Milliseconds(s.Median)),
+		attribute.Float64("hop.rtt.max_ms", Milliseconds(s.Max)),
+		attribute.Float64("hop.rtt.stddev_ms", Milliseconds(s.StdDev)),
+		attribute.Float64("hop.jitter_ms", Milliseconds(s.Jitter)),
+	)
+}
+
+// Milliseconds returns the duration as a fraction of milliseconds.
+func Milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// RecordHopSpans adds a span for every hop of the result under the span of the traceroute, the spans are named
+// after the traceroute span and carry the statistics of the hop.
+func RecordHopSpans(ctx context.Context, tracer trace.Tracer, name string, res *TraceResult) {
+	for _, hop := range res.Hops {
+		attrs := append([]attribute.KeyValue{attribute.Int64("ttl", int64(hop.TTL))}, hop.Stats.Attributes()...)
+		_, span := tracer.Start(ctx, fmt.Sprintf("%s/hop/%d", name, hop.TTL),
+			trace.WithTimestamp(res.StartTime),
+			trace.WithAttributes(attrs...),
+		)
+		span.End(trace.WithTimestamp(res.EndTime))
+	}
+}
@@ -0,0 +1,100 @@
+package methods
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestNewHopStats(t *testing.T) {
+	reply := func(addr string, ms float64) TracerouteHop {
+		rtt := time.Duration(ms * float64(time.Millisecond))
+		return TracerouteHop{Success: true, Address: &net.IPAddr{IP: net.ParseIP(addr)}, RTT: &rtt}
+	}
+	tests := []struct {
+		name   string
+		probes []TracerouteHop
+		want   HopStats
+	}{
+		{
+			name: "no probes",
+			want: HopStats{},
+		},
+		{
+			name:   "timeouts",
+			probes: []TracerouteHop{{}, {}},
+			want:   HopStats{Sent: 2, Loss: 100},
+		},
+		{
+			name:   "odd replies",
+			probes: []TracerouteHop{reply("10.0.0.1", 1), {}, reply("10.0.0.2", 3), reply("10.0.0.1", 2)},
+			want: HopStats{
+				Sent: 4, Received: 3, Loss: 25,
+				Min: time.Millisecond, Avg: 2 * time.Millisecond, Median: 2 * time.Millisecond, Max: 3 * time.Millisecond,
+				StdDev: 816496 * time.Nanosecond, Jitter: 1500 * time.Microsecond,
+				Responders: []string{"10.0.0.1", "10.0.0.2"},
+			},
+		},
+		{
+			name:   "even replies",
+			probes: []TracerouteHop{reply("10.0.0.1", 4), reply("10.0.0.1", 1)},
+			want: HopStats{
+				Sent: 2, Received: 2,
+				Min: time.Millisecond, Avg: 2500 * time.Microsecond, Median: 2500 * time.Microsecond, Max: 4 * time.Millisecond,
+				StdDev: 1500 * time.Microsecond, Jitter: 3 * time.Millisecond,
+				Responders: []string{"10.0.0.1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewHopStats(tt.probes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewHopStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordHopSpans(t *testing.T) {
+	rtt := 2 * time.Millisecond
+	res := NewTraceResult("example.com", net.ParseIP("192.0.2.1"), "udp", 33434)
+	res.Finish(map[uint16][]TracerouteHop{
+		1: {{Success: true, Address: &net.IPAddr{IP: net.ParseIP("10.0.0.1")}, TTL: 1, RTT: &rtt}, {TTL: 1}},
+		2: {{Success: true, Address: &net.IPAddr{IP: res.IP}, TTL: 2, RTT: &rtt}},
+	}, 30, nil)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "host/traceroute/192.0.2.1")
+	RecordHopSpans(ctx, provider.Tracer("test"), "host/traceroute/192.0.2.1", res)
+	parent.End()
+
+	ended := recorder.Ended()
+	if len(ended) != 3 {
+		t.Fatalf("%d spans ended, want 3", len(ended))
+	}
+	hop := ended[0]
+	if got, want := hop.Name(), "host/traceroute/192.0.2.1/hop/1"; got != want {
+		t.Errorf("RecordHopSpans() name = %q, want %q", got, want)
+	}
+	if hop.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("RecordHopSpans() span is not a child of the traceroute span")
+	}
+	attrs := attribute.NewSet(hop.Attributes()...)
+	for key, want := range map[attribute.Key]attribute.Value{
+		"ttl":            attribute.Int64Value(1),
+		"hop.sent":       attribute.IntValue(2),
+		"hop.loss_pct":   attribute.Float64Value(50),
+		"hop.rtt.avg_ms": attribute.Float64Value(2),
+	} {
+		if got, ok := attrs.Value(key); !ok || got != want {
+			t.Errorf("RecordHopSpans() %s = %v, want %v", key, got.Emit(), want.Emit())
+		}
+	}
+}
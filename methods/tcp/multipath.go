@@ -0,0 +1,42 @@
+package tcp
+
+import (
+	"github.com/jimmystewpot/traceroute/methods"
+	"github.com/jimmystewpot/traceroute/methods/mda"
+	"golang.org/x/net/context"
+)
+
+// StartMultipath enumerates the load balanced paths to the destination using the multipath detection algorithm.
+func (tr *Traceroute) StartMultipath() (*methods.TraceResult, error) {
+	return tr.StartMultipathContext(context.Background())
+}
+
+// StartMultipathContext enumerates the load balanced paths until it completes or the context is done, the
+// interfaces and links are in the Multipath of the result.
+func (tr *Traceroute) StartMultipathContext(ctx context.Context) (*methods.TraceResult, error) {
+	err := tr.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tr.close()
+
+	go tr.timeoutLoop()
+	go tr.tcpListener()
+
+	res, err := mda.New(tr, tr.opConfig.destIP, protocol, tr.trcrtConfig).StartContext(ctx)
+	res.Discarded = tr.results.tracker.Discarded()
+	return res, err
+}
+
+// ProbeFlow sends a single SYN for the flow, the source port is offset by the flow identifier.
+func (tr *Traceroute) ProbeFlow(flow uint16, ttl uint16) (methods.TracerouteHop, error) {
+	reply := make(chan methods.TracerouteHop, 1)
+	srcPort := int(uint16(tr.opConfig.srcPort) + flow)
+	err := tr.sendProbe(ttl, srcPort, inflightData{ttl: ttl, reply: reply})
+	if err != nil {
+		return methods.TracerouteHop{}, err
+	}
+	hop := <-reply
+	// the probe was cancelled rather than timed out when the context is done.
+	return hop, tr.opConfig.ctx.Err()
+}
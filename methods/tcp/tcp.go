@@ -2,7 +2,6 @@ package tcp
 
 import (
 	"fmt"
-	"log"
 	"math"
 	"math/rand"
 	"net"
@@ -13,21 +12,25 @@ import (
 	"github.com/google/gopacket/layers"
 	"github.com/jimmystewpot/traceroute/listener_channel"
 	"github.com/jimmystewpot/traceroute/methods"
+	"github.com/jimmystewpot/traceroute/methods/demux"
 	"github.com/jimmystewpot/traceroute/parallel_limiter"
 	"github.com/jimmystewpot/traceroute/signal"
-	"github.com/jimmystewpot/traceroute/util"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/context"
-	"golang.org/x/net/icmp"
-	"golang.org/x/net/ipv4"
 )
 
+const protocol string = "tcp"
+
 type inflightData struct {
 	start     time.Time
 	ttl       uint16
+	seq       uint32
+	srcPort   int
 	childSpan trace.Span
+	// reply receives the reply or timeout of the probe, it is waited for by the goroutine that sent the probe.
+	reply chan<- methods.TracerouteHop
 }
 
 type results struct {
@@ -35,7 +38,10 @@ type results struct {
 
 	results   map[uint16][]methods.TracerouteHop
 	resultsMu sync.Mutex
-	err       error
+	// err is the first error of the probes, it is set under resultsMu.
+	err error
+	// tracker counts the replies that are not recorded.
+	tracker *methods.ProbeTracker
 
 	concurrentRequests *parallel_limiter.ParallelLimiter
 	reachedFinalHop    *signal.Signal
@@ -48,21 +54,36 @@ type Traceroute struct {
 }
 
 type opConfig struct {
-	icmpConn net.PacketConn
-	tcpConn  net.PacketConn
-	tcpMu    sync.Mutex
+	// receiver dispatches the ICMP replies to the probes, it is shared with the other traceroutes.
+	receiver *demux.Receiver
+	// seqs are the sequence numbers registered with the receiver, they stay registered until the traceroute
+	// finishes so the replies that arrive after their probe are counted.
+	seqs    []uint32
+	seqsMu  sync.Mutex
+	tcpConn net.PacketConn
+	tcpMu   sync.Mutex
 
 	destIP net.IP
 	srcIP  net.IP
+	// srcPort is shared by all probes in Paris mode.
+	srcPort int
 
 	wg *sync.WaitGroup
 
+	// spans starts the spans of the probes in the layout of the config.
+	spans *methods.ProbeSpans
+
 	ctx    context.Context
 	cancel context.CancelFunc
+	// stop ends the timeout loop once every probe has completed.
+	stop chan struct{}
 }
 
 //nolint:gocritic // config is large and required
 func New(destIP net.IP, config methods.TracerouteConfig) *Traceroute {
+	if config.Network == nil {
+		config.Network = methods.System
+	}
 	return &Traceroute{
 		opConfig: opConfig{
 			destIP: destIP,
@@ -71,20 +92,43 @@ func New(destIP net.IP, config methods.TracerouteConfig) *Traceroute {
 	}
 }
 
-func (tr *Traceroute) Start() (*map[uint16][]methods.TracerouteHop, error) {
-	tr.opConfig.ctx, tr.opConfig.cancel = context.WithCancel(context.Background())
+func (tr *Traceroute) Start() (*methods.TraceResult, error) {
+	return tr.StartContext(context.Background())
+}
 
-	tr.opConfig.srcIP, _ = util.LocalIPPort(tr.opConfig.destIP)
+// StartContext runs the traceroute until it completes or the context is done. Once the context is done no more
+// probes are sent, the probes in flight are cancelled and the partial result is returned with the context error.
+func (tr *Traceroute) StartContext(ctx context.Context) (*methods.TraceResult, error) {
+	err := tr.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return tr.start(ctx)
+}
+
+// open creates the raw sockets used to send the probes and receive the replies.
+func (tr *Traceroute) open(ctx context.Context) error {
+	tr.opConfig.ctx, tr.opConfig.cancel = context.WithCancel(ctx)
+	tr.opConfig.stop = make(chan struct{})
+
+	tr.opConfig.srcIP, tr.opConfig.srcPort = tr.trcrtConfig.Network.LocalIPPort(tr.opConfig.destIP)
+
+	network := "ip4:tcp"
+	if methods.IsIPv6(tr.opConfig.destIP) {
+		network = "ip6:tcp"
+	}
 
 	var err error
-	tr.opConfig.tcpConn, err = net.ListenPacket("ip4:tcp", tr.opConfig.srcIP.String())
+	tr.opConfig.tcpConn, err = tr.trcrtConfig.Network.ListenPacket(network, tr.opConfig.srcIP.String())
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	tr.opConfig.icmpConn, err = icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	tr.opConfig.receiver, err = demux.Listen(tr.trcrtConfig.Network, tr.opConfig.destIP)
 	if err != nil {
-		return nil, err
+		tr.opConfig.tcpConn.Close()
+		return err
 	}
 
 	var wg sync.WaitGroup
@@ -94,48 +138,67 @@ func (tr *Traceroute) Start() (*map[uint16][]methods.TracerouteHop, error) {
 		inflightRequests:   sync.Map{},
 		concurrentRequests: parallel_limiter.New(int(tr.trcrtConfig.ParallelRequests)),
 		reachedFinalHop:    signal.New(),
+		tracker:            methods.NewProbeTracker(),
 
 		results: map[uint16][]methods.TracerouteHop{},
 	}
+	return nil
+}
 
-	return tr.start()
+// close stops the listeners and closes the raw sockets.
+func (tr *Traceroute) close() {
+	tr.opConfig.cancel()
+	close(tr.opConfig.stop)
+	tr.opConfig.tcpConn.Close()
+	tr.opConfig.seqsMu.Lock()
+	for _, seq := range tr.opConfig.seqs {
+		tr.opConfig.receiver.Unregister(demux.TCPKey(seq))
+	}
+	tr.opConfig.seqsMu.Unlock()
+	tr.opConfig.receiver.Release()
 }
 
 func (tr *Traceroute) timeoutLoop() {
 	ticker := time.NewTicker(tr.trcrtConfig.Timeout / 4)
-	go func() {
-		for range ticker.C {
-			tr.results.inflightRequests.Range(func(key, value interface{}) bool {
-				request := value.(inflightData)
-				expired := time.Since(request.start) > tr.trcrtConfig.Timeout
-				if !expired {
-					return true
-				}
-				tr.results.inflightRequests.Delete(key)
-				tr.addToResult(request.ttl, methods.TracerouteHop{
-					Success: false,
-					TTL:     request.ttl,
-				})
-				request.childSpan.SetAttributes(
-					attribute.Int64("ttl", int64(request.ttl)),
-					attribute.String("hop", "null"),
-					attribute.String("rtt", time.Since(request.start).String()),
-				)
-				request.childSpan.SetStatus(codes.Error, "timeout")
-				tr.results.concurrentRequests.Finished()
-				tr.opConfig.wg.Done()
-				request.childSpan.End()
-				return true
-			})
+	defer ticker.Stop()
+	done := tr.opConfig.ctx.Done()
+	for {
+		select {
+		case <-tr.opConfig.stop:
+			return
+		case <-done:
+			// the probes in flight are cancelled now, one stored while they are cancelled is on the next tick.
+			done = nil
+		case <-ticker.C:
 		}
-	}()
-	//nolint:gosimple // keeping code from fork
-	select {
-	case <-tr.opConfig.ctx.Done():
-		ticker.Stop()
+		tr.expireInflight()
 	}
 }
 
+// expireInflight completes the probes that timed out, every probe is cancelled once the context is done.
+func (tr *Traceroute) expireInflight() {
+	err := tr.opConfig.ctx.Err()
+	tr.results.inflightRequests.Range(func(key, value interface{}) bool {
+		request := value.(inflightData)
+		if err == nil && time.Since(request.start) <= tr.trcrtConfig.Timeout {
+			return true
+		}
+		if _, ok := tr.take(key.(uint32)); !ok {
+			return true
+		}
+		if err != nil {
+			// a cancelled probe is not recorded as a timeout.
+			request.reply <- methods.TracerouteHop{TTL: request.ttl}
+			return true
+		}
+		tr.complete(request, methods.TracerouteHop{
+			Success: false,
+			TTL:     request.ttl,
+		})
+		return true
+	})
+}
+
 func (tr *Traceroute) addToResult(ttl uint16, hop methods.TracerouteHop) {
 	tr.results.resultsMu.Lock()
 	defer tr.results.resultsMu.Unlock()
@@ -144,73 +207,94 @@ func (tr *Traceroute) addToResult(ttl uint16, hop methods.TracerouteHop) {
 	}
 
 	tr.results.results[ttl] = append(tr.results.results[ttl], hop)
+	if tr.trcrtConfig.OnProbe != nil {
+		tr.trcrtConfig.OnProbe(hop)
+	}
 }
 
-func (tr *Traceroute) handleICMPMessage(msg listener_channel.ReceivedMessage, data []byte) {
-	header, err := methods.GetICMPResponsePayload(data)
-	if err != nil {
-		return
-	}
-	sequenceNumber := methods.GetTCPSeq(header)
+// take removes the probe from the requests in flight, it returns false when the probe has already completed.
+func (tr *Traceroute) take(sequenceNumber uint32) (inflightData, bool) {
 	val, ok := tr.results.inflightRequests.LoadAndDelete(sequenceNumber)
 	if !ok {
+		return inflightData{}, false
+	}
+	return val.(inflightData), true
+}
+
+// handleICMPMessage completes the probe quoted by the ICMP reply, the replies that quote another destination or
+// port or arrive after their probe completed are counted and dropped.
+func (tr *Traceroute) handleICMPMessage(msg demux.Message) {
+	seq := methods.GetTCPSeq(msg.Header)
+	val, ok := tr.results.inflightRequests.Load(seq)
+	if !ok {
+		if !tr.results.tracker.Unmatched(seq) {
+			tr.results.tracker.Mismatched()
+		}
 		return
 	}
-	request := val.(inflightData)
-	elapsed := time.Since(request.start)
-	if msg.Peer.String() == tr.opConfig.destIP.String() {
-		tr.results.reachedFinalHop.Signal()
+	if !methods.QuotedProbe(msg.Dst, msg.Header, tr.opConfig.destIP, val.(inflightData).srcPort, tr.trcrtConfig.Port) {
+		tr.results.tracker.Mismatched()
+		return
 	}
-	tr.addToResult(request.ttl, methods.TracerouteHop{
+	request, ok := tr.take(seq)
+	if !ok {
+		tr.results.tracker.Unmatched(seq)
+		return
+	}
+	elapsed := msg.Received.Sub(request.start)
+	tr.complete(request, methods.TracerouteHop{
 		Success: true,
 		Address: msg.Peer,
 		TTL:     request.ttl,
 		RTT:     &elapsed,
+		ICMP:    methods.NewICMPReply(msg.ICMP),
 	})
-	request.childSpan.SetAttributes(
-		attribute.Int64("ttl", int64(request.ttl)),
-		attribute.String("hop", msg.Peer.String()),
-		attribute.String("rtt", elapsed.String()),
-	)
-	request.childSpan.SetStatus(codes.Ok, "success")
-
-	tr.results.concurrentRequests.Finished()
-	tr.opConfig.wg.Done()
-	request.childSpan.End()
 }
 
-func (tr *Traceroute) icmpListener() {
-	lc := listener_channel.New(tr.opConfig.icmpConn)
-
-	defer lc.Stop()
-
-	go lc.Start()
+// complete hands the reply or timeout of a probe to the goroutine that sent it, the probe was taken from the
+// requests in flight so the buffered reply channel never blocks the shared receiver.
+func (tr *Traceroute) complete(request inflightData, hop methods.TracerouteHop) {
+	tr.results.tracker.Complete(request.seq, hop.Success)
+	request.reply <- hop
+}
 
-	for {
-		select {
-		case <-tr.opConfig.ctx.Done():
-			return
-		case msg := <-lc.Messages:
-			if msg.N == nil {
-				continue
-			}
-			rm, err := icmp.ParseMessage(1, msg.Msg[:*msg.N])
-			if err != nil {
-				log.Println(err)
-				continue
-			}
-			switch rm.Type {
-			case ipv4.ICMPTypeTimeExceeded:
-				body := rm.Body.(*icmp.TimeExceeded).Data
-				tr.handleICMPMessage(msg, body)
-			case ipv4.ICMPTypeDestinationUnreachable:
-				body := rm.Body.(*icmp.DstUnreach).Data
-				tr.handleICMPMessage(msg, body)
-			default:
-				log.Println("received icmp message of unknown type")
-			}
+// record adds the reply or timeout of a probe sent by sendMessage to the result and ends its span, the probes
+// in flight when the context was done are not recorded.
+func (tr *Traceroute) record(request inflightData, hop methods.TracerouteHop) {
+	if err := tr.opConfig.ctx.Err(); err != nil && !hop.Success {
+		methods.SetSpanStatus(request.childSpan, err)
+		tr.results.concurrentRequests.Finished()
+		tr.opConfig.wg.Done()
+		request.childSpan.End()
+		return
+	}
+	if hop.Success && (hop.Address.String() == tr.opConfig.destIP.String() || hop.Fatal()) {
+		tr.results.reachedFinalHop.Signal()
+	}
+	tr.addToResult(request.ttl, hop)
+	// the probe layout keeps the attributes of the earlier releases.
+	if !tr.opConfig.spans.RecordProbe(request.childSpan, hop) {
+		if hop.Success {
+			request.childSpan.SetAttributes(
+				attribute.Int64("ttl", int64(request.ttl)),
+				attribute.String("hop", hop.Address.String()),
+				attribute.String("rtt", hop.RTT.String()),
+			)
+			request.childSpan.SetAttributes(hop.ICMPAttributes()...)
+			request.childSpan.SetStatus(codes.Ok, "success")
+		} else {
+			request.childSpan.SetAttributes(
+				attribute.Int64("ttl", int64(request.ttl)),
+				attribute.String("hop", "null"),
+				attribute.String("rtt", time.Since(request.start).String()),
+			)
+			request.childSpan.SetStatus(codes.Error, "timeout")
 		}
 	}
+
+	tr.results.concurrentRequests.Finished()
+	tr.opConfig.wg.Done()
+	request.childSpan.End()
 }
 
 func (tr *Traceroute) tcpListener() {
@@ -236,48 +320,81 @@ func (tr *Traceroute) tcpListener() {
 			// Get the TCP layer from this packet
 			if tcpLayer := packet.Layer(layers.LayerTypeTCP); tcpLayer != nil {
 				tcp, _ := tcpLayer.(*layers.TCP)
-
-				val, ok := tr.results.inflightRequests.LoadAndDelete(tcp.Ack - 1)
-				if !ok {
+				// the other segments from the destination are not replies to the probes.
+				if int(tcp.SrcPort) != tr.trcrtConfig.Port {
 					continue
 				}
-				request := val.(inflightData)
-				tr.results.concurrentRequests.Finished()
-				elapsed := time.Since(request.start)
-				if msg.Peer.String() == tr.opConfig.destIP.String() {
-					tr.results.reachedFinalHop.Signal()
+				seq := tcp.Ack - 1
+				if val, ok := tr.results.inflightRequests.Load(seq); ok && int(tcp.DstPort) != val.(inflightData).srcPort {
+					tr.results.tracker.Mismatched()
+					continue
+				}
+				request, ok := tr.take(seq)
+				if !ok {
+					tr.results.tracker.Unmatched(seq)
+					continue
 				}
-				tr.addToResult(request.ttl, methods.TracerouteHop{
+				elapsed := msg.Received.Sub(request.start)
+				tr.complete(request, methods.TracerouteHop{
 					Success: true,
 					Address: msg.Peer,
 					TTL:     request.ttl,
 					RTT:     &elapsed,
 				})
-				tr.opConfig.wg.Done()
 			}
 		}
 	}
 }
 
-func (tr *Traceroute) sendMessage(parentctx context.Context, ttl uint16) {
-	_, childSpan := tr.trcrtConfig.Tracer.Start(
-		parentctx,
-		fmt.Sprintf("%s/traceroute/%s", tr.trcrtConfig.LocalHostname, tr.opConfig.destIP),
+// fail keeps the first error, the probes sent in parallel may fail after it.
+func (r *results) fail(err error) {
+	r.resultsMu.Lock()
+	defer r.resultsMu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (tr *Traceroute) sendMessage(ttl uint16) {
+	childSpan := tr.opConfig.spans.Start(
+		ttl,
 		tr.returnTraceAttributes(),
 		trace.WithAttributes(attribute.Int64("ttl", int64(ttl))),
 		trace.WithSpanKind(trace.SpanKindClient),
 	)
 
-	_, srcPort := util.LocalIPPort(tr.opConfig.destIP)
-	ipHeader := &layers.IPv4{
-		SrcIP:    tr.opConfig.srcIP,
-		DstIP:    tr.opConfig.destIP,
-		Protocol: layers.IPProtocolTCP,
-		TTL:      uint8(ttl),
+	// in Paris mode the five-tuple is constant and the probe is identified by the sequence number.
+	srcPort := tr.opConfig.srcPort
+	if !tr.trcrtConfig.Paris {
+		_, srcPort = tr.trcrtConfig.Network.LocalIPPort(tr.opConfig.destIP)
 	}
 
-	//nolint:gosec  //packet sequence randomisation is enough in this context.
-	sequenceNumber := uint32(rand.Intn(math.MaxUint32))
+	reply := make(chan methods.TracerouteHop, 1)
+	request := inflightData{start: time.Now(), ttl: ttl, childSpan: childSpan, reply: reply}
+	err := tr.sendProbe(ttl, srcPort, request)
+	if err != nil {
+		tr.results.fail(err)
+		childSpan.SetStatus(codes.Error, "failure")
+		tr.opConfig.cancel()
+		childSpan.End()
+		tr.results.concurrentRequests.Finished()
+		tr.opConfig.wg.Done()
+		return
+	}
+	// the reply is recorded here as OnProbe can block and the ICMP receiver is shared with the other traceroutes.
+	tr.record(request, <-reply)
+}
+
+// sendProbe sends a SYN from srcPort with a random sequence number and stores the request until the reply or timeout.
+func (tr *Traceroute) sendProbe(ttl uint16, srcPort int, request inflightData) (err error) {
+	ipHeader := methods.NetworkLayer(tr.opConfig.srcIP, tr.opConfig.destIP, layers.IPProtocolTCP, ttl)
+
+	sequenceNumber := tr.register()
+	defer func() {
+		if err != nil {
+			tr.opConfig.receiver.Unregister(demux.TCPKey(sequenceNumber))
+		}
+	}()
 
 	tcpHeader := &layers.TCP{
 		SrcPort: layers.TCPPort(srcPort),
@@ -293,35 +410,45 @@ func (tr *Traceroute) sendMessage(parentctx context.Context, ttl uint16) {
 		ComputeChecksums: true,
 		FixLengths:       true,
 	}
-	if err := gopacket.SerializeLayers(buf, opts, tcpHeader); err != nil {
-		tr.results.err = err
-		tr.opConfig.cancel()
-		return
+	if err = gopacket.SerializeLayers(buf, opts, tcpHeader); err != nil {
+		return err
 	}
 
 	tr.opConfig.tcpMu.Lock()
 	defer tr.opConfig.tcpMu.Unlock()
-	err := ipv4.NewPacketConn(tr.opConfig.tcpConn).SetTTL(int(ttl))
+	err = methods.SetTTL(tr.opConfig.tcpConn, tr.opConfig.destIP, int(ttl))
 	if err != nil {
-		tr.results.err = err
-		childSpan.SetStatus(codes.Error, "failure")
-		tr.opConfig.cancel()
-		childSpan.End()
-		return
+		return err
 	}
 
-	start := time.Now()
-	if _, err := tr.opConfig.tcpConn.WriteTo(buf.Bytes(), &net.IPAddr{IP: tr.opConfig.destIP}); err != nil {
-		tr.results.err = err
-		childSpan.SetStatus(codes.Error, "failure")
-		tr.opConfig.cancel()
-		childSpan.End()
-		return
+	// the request is stored before sending as the reply can arrive before WriteTo returns.
+	request.seq = sequenceNumber
+	request.srcPort = srcPort
+	request.start = time.Now()
+	tr.results.inflightRequests.Store(sequenceNumber, request)
+	if _, err = tr.opConfig.tcpConn.WriteTo(buf.Bytes(), &net.IPAddr{IP: tr.opConfig.destIP}); err != nil {
+		tr.results.inflightRequests.Delete(sequenceNumber)
+		return err
+	}
+	return nil
+}
+
+// register returns a random sequence number for a probe, the sequence number identifies the probe to the
+// receiver shared with the other traceroutes so it is drawn again when another probe uses it.
+func (tr *Traceroute) register() uint32 {
+	for {
+		//nolint:gosec  //packet sequence randomisation is enough in this context.
+		sequenceNumber := uint32(rand.Intn(math.MaxUint32))
+		if tr.opConfig.receiver.Register(demux.TCPKey(sequenceNumber), tr.handleICMPMessage) == nil {
+			tr.opConfig.seqsMu.Lock()
+			tr.opConfig.seqs = append(tr.opConfig.seqs, sequenceNumber)
+			tr.opConfig.seqsMu.Unlock()
+			return sequenceNumber
+		}
 	}
-	tr.results.inflightRequests.Store(sequenceNumber, inflightData{start: start, ttl: ttl, childSpan: childSpan})
 }
 
-func (tr *Traceroute) sendLoop(parentctx context.Context) {
+func (tr *Traceroute) sendLoop() {
 	//nolint:gosec // not cryptographic
 	rand.New(rand.NewSource(time.Now().UTC().UnixNano()))
 	defer tr.opConfig.wg.Done()
@@ -338,48 +465,58 @@ func (tr *Traceroute) sendLoop(parentctx context.Context) {
 				return
 			case <-tr.results.concurrentRequests.Start():
 				tr.opConfig.wg.Add(1)
-				go tr.sendMessage(parentctx, ttl)
+				go tr.sendMessage(ttl)
 			}
 		}
 	}
 }
 
-func (tr *Traceroute) start() (*map[uint16][]methods.TracerouteHop, error) {
+func (tr *Traceroute) start(ctx context.Context) (*methods.TraceResult, error) {
+	result := methods.NewTraceResult(tr.trcrtConfig.DestinationHostname, tr.opConfig.destIP, protocol, tr.trcrtConfig.Port)
+	result.Tags = tr.trcrtConfig.Tags
+
+	spanName := fmt.Sprintf("%s/traceroute/%s", tr.trcrtConfig.LocalHostname, tr.opConfig.destIP)
 	parentctx, parentSpan := tr.trcrtConfig.Tracer.Start(
 		tr.trcrtConfig.TraceCtx,
-		fmt.Sprintf("%s/traceroute/%s", tr.trcrtConfig.LocalHostname, tr.opConfig.destIP),
+		spanName,
 		tr.returnTraceAttributes(),
 		trace.WithSpanKind(trace.SpanKindClient),
 	)
 	defer parentSpan.End()
+	tr.opConfig.spans = methods.NewProbeSpans(parentctx, &tr.trcrtConfig, spanName, protocol, tr.opConfig.destIP)
 
 	go tr.timeoutLoop()
-	go tr.icmpListener()
 	go tr.tcpListener()
 
 	tr.opConfig.wg.Add(1)
-	go tr.sendLoop(parentctx)
+	go tr.sendLoop()
 
 	tr.opConfig.wg.Wait()
-	tr.opConfig.cancel()
-
-	if tr.results.err != nil {
-		parentSpan.SetStatus(codes.Error, fmt.Sprintf("%s", tr.results.err))
-		return nil, tr.results.err
+	tr.close()
+	if tr.results.err == nil {
+		tr.results.err = ctx.Err()
 	}
 
-	result := methods.ReduceFinalResult(tr.results.results, tr.trcrtConfig.MaxHops, tr.opConfig.destIP)
-	parentSpan.SetStatus(codes.Ok, "success")
+	result.Finish(tr.results.results, tr.trcrtConfig.MaxHops, tr.results.err)
+	result.Discarded = tr.results.tracker.Discarded()
+	methods.EnrichResult(parentctx, tr.trcrtConfig.Enricher, result, parentSpan)
+	methods.RecordPath(tr.trcrtConfig.PathRecorder, result, parentSpan)
+	tr.opConfig.spans.End(result)
+	methods.SetSpanStatus(parentSpan, tr.results.err)
 
-	return &result, tr.results.err
+	return result, tr.results.err
 }
 
 func (tr *Traceroute) returnTraceAttributes() trace.SpanStartEventOption {
-	return trace.WithAttributes(
+	attrs := []attribute.KeyValue{
 		attribute.String("source", tr.trcrtConfig.LocalHostname),
 		attribute.String("destination_hostname", tr.trcrtConfig.DestinationHostname),
 		attribute.Int64("max_ttl", int64(tr.trcrtConfig.MaxHops)),
-		attribute.String("protocol", "tcp"),
+		attribute.String("protocol", protocol),
 		attribute.String("xid", tr.trcrtConfig.Xid.String()),
-	)
+	}
+	if tr.trcrtConfig.Cycle > 0 {
+		attrs = append(attrs, attribute.Int("cycle", tr.trcrtConfig.Cycle))
+	}
+	return trace.WithAttributes(append(attrs, methods.TagAttributes(tr.trcrtConfig.Tags)...)...)
 }
@@ -0,0 +1,188 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+	"github.com/jimmystewpot/traceroute/methods/simnet"
+	"go.opentelemetry.io/otel"
+)
+
+func TestTracerouteStart(t *testing.T) {
+	dest := net.ParseIP("192.0.2.1")
+	tests := []struct {
+		name    string
+		paris   bool
+		routers []simnet.Router
+		want    []string
+		// discarded are the replies that are not recorded.
+		discarded methods.DiscardedReplies
+	}{
+		{
+			name: "reaches the destination",
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), Delay: time.Millisecond},
+				{Addr: net.ParseIP("10.0.1.1"), Delay: 2 * time.Millisecond},
+				{Addr: dest, Delay: 3 * time.Millisecond},
+			},
+			want: []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 10.0.1.1 10.0.1.1 10.0.1.1", "3 192.0.2.1 192.0.2.1 192.0.2.1"},
+		},
+		{
+			name:  "paris",
+			paris: true,
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), Delay: time.Millisecond},
+				{Addr: dest, Delay: 2 * time.Millisecond},
+			},
+			want: []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 192.0.2.1 192.0.2.1 192.0.2.1"},
+		},
+		{
+			name: "silent hop",
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1")},
+				{Addr: net.ParseIP("10.0.1.1"), Silent: true},
+				{Addr: dest},
+			},
+			want: []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 * * *", "3 192.0.2.1 192.0.2.1 192.0.2.1"},
+		},
+		{
+			// the rate limit only applies to ICMP, the SYN ACKs of the destination are not limited.
+			name: "rate limited",
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), RateLimit: 1},
+				{Addr: dest, RateLimit: 1},
+			},
+			want: []string{"1 10.0.0.1 * *", "2 192.0.2.1 192.0.2.1 192.0.2.1"},
+		},
+		{
+			name: "filtered destination",
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1")},
+				{Addr: dest, Unreachable: true, Code: 13},
+			},
+			want: []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 192.0.2.1 !X 192.0.2.1 !X 192.0.2.1 !X"},
+		},
+		{
+			// the destination does not duplicate its replies, the TTLs probed past it and the replies that arrive
+			// after the sockets are closed would make the count depend on the timing.
+			name: "duplicate replies",
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), Duplicate: true},
+				{Addr: net.ParseIP("10.0.1.1"), Silent: true},
+				{Addr: dest},
+			},
+			want:      []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 * * *", "3 192.0.2.1 192.0.2.1 192.0.2.1"},
+			discarded: methods.DiscardedReplies{Duplicate: 3},
+		},
+		{
+			name: "mismatched destination",
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), QuoteDst: net.ParseIP("192.0.2.99")},
+				{Addr: dest},
+			},
+			want:      []string{"1 * * *", "2 192.0.2.1 192.0.2.1 192.0.2.1"},
+			discarded: methods.DiscardedReplies{Mismatched: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := methods.TracerouteConfig{
+				MaxHops:          5,
+				NumMeasurements:  3,
+				ParallelRequests: 6,
+				Port:             443,
+				Timeout:          200 * time.Millisecond,
+				Paris:            tt.paris,
+				Tracer:           otel.Tracer("test"),
+				TraceCtx:         context.Background(),
+				Network:          simnet.New(net.ParseIP("198.51.100.1"), tt.routers...),
+			}
+			res, err := New(dest, cfg).Start()
+			if err != nil {
+				t.Fatalf("Traceroute.Start() error = %v", err)
+			}
+			if got := simnet.Summary(res); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Traceroute.Start() = %q, want %q", got, tt.want)
+			}
+			if res.Discarded != tt.discarded {
+				t.Errorf("Traceroute.Start() discarded = %+v, want %+v", res.Discarded, tt.discarded)
+			}
+		})
+	}
+}
+
+func TestTracerouteSendFailed(t *testing.T) {
+	dest := net.ParseIP("192.0.2.1")
+	network := simnet.New(net.ParseIP("198.51.100.1"), simnet.Router{Addr: net.ParseIP("10.0.0.1")}, simnet.Router{Addr: dest})
+	network.SendErr = errors.New("network is unreachable")
+	// the probes sent in parallel all fail, the first error is returned.
+	cfg := methods.TracerouteConfig{
+		MaxHops:          5,
+		NumMeasurements:  3,
+		ParallelRequests: 6,
+		Port:             443,
+		Timeout:          200 * time.Millisecond,
+		Tracer:           otel.Tracer("test"),
+		TraceCtx:         context.Background(),
+		Network:          network,
+	}
+	res, err := New(dest, cfg).Start()
+	if !errors.Is(err, network.SendErr) {
+		t.Fatalf("Traceroute.Start() error = %v, want %v", err, network.SendErr)
+	}
+	if res == nil || len(res.Path()) != 0 {
+		t.Errorf("Traceroute.Start() = %+v, want no replies", res)
+	}
+}
+
+func TestTracerouteBlockingOnProbe(t *testing.T) {
+	dest := net.ParseIP("192.0.2.1")
+	network := simnet.New(net.ParseIP("198.51.100.1"),
+		simnet.Router{Addr: net.ParseIP("10.0.0.1")},
+		simnet.Router{Addr: dest},
+	)
+	cfg := methods.TracerouteConfig{
+		MaxHops:          5,
+		NumMeasurements:  3,
+		ParallelRequests: 6,
+		Timeout:          200 * time.Millisecond,
+		Tracer:           otel.Tracer("test"),
+		TraceCtx:         context.Background(),
+		Network:          network,
+	}
+
+	// the first traceroute blocks in OnProbe until the second one, which shares the ICMP receiver, has completed.
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	first := cfg
+	first.OnProbe = func(methods.TracerouteHop) {
+		once.Do(func() { close(blocked) })
+		<-release
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := New(dest, first).Start()
+		done <- err
+	}()
+	<-blocked
+
+	res, err := New(dest, cfg).Start()
+	close(release)
+	if err != nil {
+		t.Fatalf("Traceroute.Start() error = %v", err)
+	}
+	want := []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 192.0.2.1 192.0.2.1 192.0.2.1"}
+	if got := simnet.Summary(res); !reflect.DeepEqual(got, want) {
+		t.Errorf("Traceroute.Start() with a blocked OnProbe = %q, want %q", got, want)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Traceroute.Start() blocked traceroute error = %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package udp
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+	"github.com/jimmystewpot/traceroute/methods/demux"
+	"github.com/jimmystewpot/traceroute/methods/mda"
+	"github.com/jimmystewpot/traceroute/parallel_limiter"
+	"github.com/jimmystewpot/traceroute/signal"
+	"golang.org/x/net/context"
+)
+
+// flowConn is the connection used for every probe of a flow, the source port identifies the flow.
+type flowConn struct {
+	conn    net.PacketConn
+	mu      sync.Mutex
+	srcIP   net.IP
+	srcPort int
+
+	// reply receives the UDP reply from the destination to the probe of the flow in flight, it is nil between
+	// the probes.
+	reply   chan<- probeReply
+	replyMu sync.Mutex
+}
+
+// read hands the UDP replies from the destination to the probe of the flow in flight until the connection is
+// closed, the replies that arrive between the probes are dropped.
+func (fc *flowConn) read() {
+	buf := make([]byte, 1500)
+	for {
+		_, peer, err := fc.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		rep := probeReply{peer: &net.IPAddr{IP: peer.(*net.UDPAddr).IP}, received: time.Now()}
+		fc.replyMu.Lock()
+		select {
+		case fc.reply <- rep:
+		default:
+		}
+		fc.replyMu.Unlock()
+	}
+}
+
+// expect sets the channel of the probe of the flow in flight, nil once it completed.
+func (fc *flowConn) expect(reply chan<- probeReply) {
+	fc.replyMu.Lock()
+	defer fc.replyMu.Unlock()
+	fc.reply = reply
+}
+
+// StartMultipath enumerates the load balanced paths to the destination using the multipath detection algorithm.
+func (tr *Traceroute) StartMultipath() (*methods.TraceResult, error) {
+	return tr.StartMultipathContext(context.Background())
+}
+
+// StartMultipathContext enumerates the load balanced paths until it completes or the context is done, the
+// interfaces and links are in the Multipath of the result.
+func (tr *Traceroute) StartMultipathContext(ctx context.Context) (*methods.TraceResult, error) {
+	tr.opConfig.ctx, tr.opConfig.cancel = context.WithCancel(ctx)
+
+	tr.results = results{
+		inflightRequests:   sync.Map{},
+		concurrentRequests: parallel_limiter.New(int(tr.trcrtConfig.ParallelRequests)),
+		results:            map[uint16][]methods.TracerouteHop{},
+		reachedFinalHop:    signal.New(),
+		tracker:            methods.NewProbeTracker(),
+	}
+	tr.opConfig.multipath = true
+	tr.opConfig.flows = map[uint16]*flowConn{}
+	tr.opConfig.ports = map[uint16]struct{}{}
+
+	var err error
+	tr.opConfig.receiver, err = demux.Listen(tr.trcrtConfig.Network, tr.opConfig.destIP)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		tr.opConfig.cancel()
+		tr.unregisterPorts()
+		tr.opConfig.receiver.Release()
+		tr.opConfig.flowsMu.Lock()
+		defer tr.opConfig.flowsMu.Unlock()
+		for _, fc := range tr.opConfig.flows {
+			fc.conn.Close()
+		}
+	}()
+
+	res, err := mda.New(tr, tr.opConfig.destIP, protocol, tr.trcrtConfig).StartContext(ctx)
+	res.Discarded = tr.results.tracker.Discarded()
+	return res, err
+}
+
+// ProbeFlow sends a single probe for the flow, probes of the same flow are sent one at a time.
+func (tr *Traceroute) ProbeFlow(flow uint16, ttl uint16) (methods.TracerouteHop, error) {
+	fc := tr.flowConn(flow)
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	payload, err := tr.payload(fc.srcIP, fc.srcPort)
+	if err != nil {
+		return methods.TracerouteHop{}, err
+	}
+	// the connection is reused by the next probe of the flow, its reader only hands it the replies from now on.
+	udpMsg := make(chan probeReply, 1)
+	fc.expect(udpMsg)
+	defer fc.expect(nil)
+	return tr.probe(fc.conn, payload, uint16(fc.srcPort), ttl, udpMsg)
+}
+
+// flowConn returns the connection for the flow, opening it on first use.
+func (tr *Traceroute) flowConn(flow uint16) *flowConn {
+	tr.opConfig.flowsMu.Lock()
+	defer tr.opConfig.flowsMu.Unlock()
+	fc, ok := tr.opConfig.flows[flow]
+	if !ok {
+		fc = &flowConn{}
+		fc.srcIP, fc.srcPort, fc.conn = tr.getUDPConn(0)
+		tr.opConfig.flows[flow] = fc
+		go fc.read()
+	}
+	return fc
+}
@@ -0,0 +1,128 @@
+package udp
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/jimmystewpot/traceroute/methods"
+	"github.com/jimmystewpot/traceroute/methods/demux"
+)
+
+// parisConfig holds the shared connection used when running in Paris mode, every probe uses the
+// same five-tuple so that ECMP routers forward all of the probes along the same path.
+type parisConfig struct {
+	conn    net.PacketConn
+	mu      sync.Mutex
+	srcIP   net.IP
+	srcPort int
+	payload []byte
+
+	probeID   uint16
+	probeIDMu sync.Mutex
+}
+
+// openParis opens the connection shared by all of the probes and starts listening for replies from the destination.
+func (tr *Traceroute) openParis() error {
+	tr.opConfig.paris.srcIP, tr.opConfig.paris.srcPort, tr.opConfig.paris.conn = tr.getUDPConn(0)
+
+	var err error
+	tr.opConfig.paris.payload, err = tr.payload(tr.opConfig.paris.srcIP, tr.opConfig.paris.srcPort)
+	if err != nil {
+		tr.opConfig.paris.conn.Close()
+		return err
+	}
+	// the probe identifier is appended as a 16 bit word, keep it aligned for the checksum.
+	if len(tr.opConfig.paris.payload)%2 != 0 {
+		tr.opConfig.paris.payload = append(tr.opConfig.paris.payload, 0)
+	}
+	err = tr.opConfig.receiver.Register(demux.UDPKey(uint16(tr.opConfig.paris.srcPort)), tr.handleICMPMessage)
+	if err != nil {
+		tr.opConfig.paris.conn.Close()
+		return err
+	}
+
+	go tr.parisListener()
+	return nil
+}
+
+// parisPayload returns the payload for the next probe and the UDP checksum that identifies it. Only the
+// last word of the payload changes between probes which changes the checksum but not the flow.
+func (tr *Traceroute) parisPayload() ([]byte, uint16, error) {
+	tr.opConfig.paris.probeIDMu.Lock()
+	tr.opConfig.paris.probeID++
+	probeID := tr.opConfig.paris.probeID
+	tr.opConfig.paris.probeIDMu.Unlock()
+
+	payload := make([]byte, len(tr.opConfig.paris.payload), len(tr.opConfig.paris.payload)+2)
+	copy(payload, tr.opConfig.paris.payload)
+	payload = binary.BigEndian.AppendUint16(payload, probeID)
+
+	checksum, err := tr.udpChecksum(payload)
+	if err != nil {
+		return nil, 0, err
+	}
+	return payload, checksum, nil
+}
+
+// udpChecksum calculates the checksum the kernel will set on the probe.
+func (tr *Traceroute) udpChecksum(payload []byte) (uint16, error) {
+	udpHeader := &layers.UDP{
+		SrcPort: layers.UDPPort(tr.opConfig.paris.srcPort),
+		DstPort: layers.UDPPort(tr.trcrtConfig.Port),
+	}
+	ipHeader := methods.NetworkLayer(tr.opConfig.paris.srcIP, tr.opConfig.destIP, layers.IPProtocolUDP, 0)
+	_ = udpHeader.SetNetworkLayerForChecksum(ipHeader)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		ComputeChecksums: true,
+		FixLengths:       true,
+	}
+	if err := gopacket.SerializeLayers(buf, opts, udpHeader, gopacket.Payload(payload)); err != nil {
+		return 0, err
+	}
+	checksum := methods.GetUDPChecksum(buf.Bytes())
+	// a computed checksum of zero is transmitted as all ones (RFC 768).
+	if checksum == 0 {
+		checksum = 0xffff
+	}
+	return checksum, nil
+}
+
+// parisListener reads the replies from the destination on the shared connection. The reply does not
+// identify the probe, it is attributed to the inflight probe with the lowest TTL as that is the
+// first probe that could have reached the destination.
+func (tr *Traceroute) parisListener() {
+	reply := make([]byte, 1500)
+	for {
+		_, peer, err := tr.opConfig.paris.conn.ReadFrom(reply)
+		if err != nil {
+			// probably because we closed the connection
+			return
+		}
+		var (
+			lowestKey interface{}
+			lowestTTL uint16
+		)
+		tr.results.inflightRequests.Range(func(key, value interface{}) bool {
+			request := value.(inflightData)
+			if lowestKey == nil || request.ttl < lowestTTL {
+				lowestKey, lowestTTL = key, request.ttl
+			}
+			return true
+		})
+		if lowestKey == nil {
+			continue
+		}
+		val, ok := tr.results.inflightRequests.LoadAndDelete(lowestKey)
+		if !ok {
+			continue
+		}
+		tr.results.tracker.Complete(uint32(lowestKey.(uint16)), true)
+		val.(inflightData).icmpMsg <- probeReply{peer: &net.IPAddr{IP: peer.(*net.UDPAddr).IP}, received: time.Now()}
+	}
+}
@@ -0,0 +1,70 @@
+package udp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+func TestParisPayload(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   net.IP
+		dest  net.IP
+		proto gopacket.Decoder
+	}{
+		{name: "ipv4", src: net.ParseIP("198.51.100.1"), dest: net.ParseIP("192.0.2.1"), proto: layers.LayerTypeIPv4},
+		{name: "ipv6", src: net.ParseIP("2001:db8:ffff::1"), dest: net.ParseIP("2001:db8::1"), proto: layers.LayerTypeIPv6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := New(tt.dest, false, methods.TracerouteConfig{Port: 33434, Paris: true})
+			tr.opConfig.paris.srcIP = tt.src
+			tr.opConfig.paris.srcPort = 40000
+			tr.opConfig.paris.payload = []byte("paris payload...")
+
+			checksums := map[uint16]bool{}
+			for probe := 0; probe < 3; probe++ {
+				payload, checksum, err := tr.parisPayload()
+				if err != nil {
+					t.Fatalf("Traceroute.parisPayload() error = %v", err)
+				}
+				if checksums[checksum] {
+					t.Errorf("Traceroute.parisPayload() checksum %#x identifies more than one probe", checksum)
+				}
+				checksums[checksum] = true
+				// the flow fields are the same for every probe and the checksum of a probe does not depend on the TTL.
+				for _, ttl := range []uint16{1, 2, 30, 255} {
+					udp := sent(t, tt.src, tt.dest, ttl, payload, tt.proto)
+					if udp.SrcPort != 40000 || udp.DstPort != 33434 || udp.Checksum != checksum {
+						t.Errorf("probe %d TTL %d sent ports %d->%d checksum %#x, want 40000->33434 checksum %#x",
+							probe, ttl, udp.SrcPort, udp.DstPort, udp.Checksum, checksum)
+					}
+				}
+			}
+		})
+	}
+}
+
+// sent returns the UDP header of the probe as the kernel sends it with the TTL.
+func sent(t *testing.T, src, dest net.IP, ttl uint16, payload []byte, proto gopacket.Decoder) *layers.UDP {
+	t.Helper()
+	ip := methods.NetworkLayer(src, dest, layers.IPProtocolUDP, ttl)
+	udp := &layers.UDP{SrcPort: 40000, DstPort: 33434}
+	_ = udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	err := gopacket.SerializeLayers(buf, opts, ip.(gopacket.SerializableLayer), udp, gopacket.Payload(payload))
+	if err != nil {
+		t.Fatalf("gopacket.SerializeLayers() error = %v", err)
+	}
+	packet := gopacket.NewPacket(buf.Bytes(), proto, gopacket.Default)
+	layer, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok {
+		t.Fatalf("probe has no UDP header: %v", packet)
+	}
+	return layer
+}
@@ -1,6 +1,7 @@
 package udp
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -10,23 +11,35 @@ import (
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"github.com/jimmystewpot/traceroute/listener_channel"
 	"github.com/jimmystewpot/traceroute/methods"
+	"github.com/jimmystewpot/traceroute/methods/demux"
 	"github.com/jimmystewpot/traceroute/methods/quic"
 	"github.com/jimmystewpot/traceroute/parallel_limiter"
 	"github.com/jimmystewpot/traceroute/signal"
 	"github.com/jimmystewpot/traceroute/taskgroup"
-	"github.com/jimmystewpot/traceroute/util"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/context"
-	"golang.org/x/net/icmp"
-	"golang.org/x/net/ipv4"
+)
+
+const (
+	protocol string = "udp"
+	// maxPortAttempts is the number of source ports tried for a probe when the port is still registered by
+	// another traceroute.
+	maxPortAttempts int = 3
 )
 
 type inflightData struct {
-	icmpMsg chan<- net.Addr
+	icmpMsg chan<- probeReply
+	ttl     uint16
+}
+
+// probeReply is the address that answered a probe, icmp is nil when the destination replied over UDP.
+type probeReply struct {
+	peer     net.Addr
+	icmp     *methods.ICMPReply
+	received time.Time
 }
 
 type opConfig struct {
@@ -34,7 +47,23 @@ type opConfig struct {
 	destIP net.IP
 	wg     *taskgroup.TaskGroup
 
-	icmpConn net.PacketConn
+	// receiver dispatches the ICMP replies to the probes, it is shared with the other traceroutes.
+	receiver *demux.Receiver
+	// ports are the source ports registered with the receiver, they stay registered until the traceroute
+	// finishes so the replies that arrive after their probe are counted.
+	ports   map[uint16]struct{}
+	portsMu sync.Mutex
+
+	paris parisConfig
+
+	// multipath is set when running the multipath detection algorithm, each flow has its own source port.
+	multipath bool
+	// flows holds a connection per flow identifier when running the multipath detection algorithm.
+	flows   map[uint16]*flowConn
+	flowsMu sync.Mutex
+
+	// spans starts the spans of the probes in the layout of the config.
+	spans *methods.ProbeSpans
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -45,7 +74,10 @@ type results struct {
 
 	results   map[uint16][]methods.TracerouteHop
 	resultsMu sync.Mutex
-	err       error
+	// err is the first error of the probes, it is set under resultsMu.
+	err error
+	// tracker counts the replies that are not recorded.
+	tracker *methods.ProbeTracker
 
 	concurrentRequests *parallel_limiter.ParallelLimiter
 	reachedFinalHop    *signal.Signal
@@ -59,6 +91,9 @@ type Traceroute struct {
 
 //nolint:gocritic // config is large and required.
 func New(destIP net.IP, quic bool, config methods.TracerouteConfig) *Traceroute {
+	if config.Network == nil {
+		config.Network = methods.System
+	}
 	return &Traceroute{
 		opConfig: opConfig{
 			quic:   quic,
@@ -68,23 +103,39 @@ func New(destIP net.IP, quic bool, config methods.TracerouteConfig) *Traceroute
 	}
 }
 
-func (tr *Traceroute) Start() (*map[uint16][]methods.TracerouteHop, error) {
-	tr.opConfig.ctx, tr.opConfig.cancel = context.WithCancel(context.Background())
+func (tr *Traceroute) Start() (*methods.TraceResult, error) {
+	return tr.StartContext(context.Background())
+}
+
+// StartContext runs the traceroute until it completes or the context is done. Once the context is done no more
+// probes are sent, the probes in flight are cancelled and the partial result is returned with the context error.
+func (tr *Traceroute) StartContext(ctx context.Context) (*methods.TraceResult, error) {
+	tr.opConfig.ctx, tr.opConfig.cancel = context.WithCancel(ctx)
 
 	tr.results = results{
 		inflightRequests:   sync.Map{},
 		concurrentRequests: parallel_limiter.New(int(tr.trcrtConfig.ParallelRequests)),
 		results:            map[uint16][]methods.TracerouteHop{},
 		reachedFinalHop:    signal.New(),
+		tracker:            methods.NewProbeTracker(),
 	}
+	tr.opConfig.ports = map[uint16]struct{}{}
 
 	var err error
-	tr.opConfig.icmpConn, err = icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	tr.opConfig.receiver, err = demux.Listen(tr.trcrtConfig.Network, tr.opConfig.destIP)
 	if err != nil {
 		return nil, err
 	}
 
-	return tr.start()
+	if tr.trcrtConfig.Paris {
+		err = tr.openParis()
+		if err != nil {
+			tr.opConfig.receiver.Release()
+			return nil, err
+		}
+	}
+
+	return tr.start(ctx)
 }
 
 func (tr *Traceroute) addToResult(ttl uint16, hop methods.TracerouteHop) {
@@ -95,10 +146,13 @@ func (tr *Traceroute) addToResult(ttl uint16, hop methods.TracerouteHop) {
 	}
 
 	tr.results.results[ttl] = append(tr.results.results[ttl], hop)
+	if tr.trcrtConfig.OnProbe != nil {
+		tr.trcrtConfig.OnProbe(hop)
+	}
 }
 
 func (tr *Traceroute) getUDPConn(try int) (net.IP, int, net.PacketConn) {
-	srcIP, _ := util.LocalIPPort(tr.opConfig.destIP)
+	srcIP, _ := tr.trcrtConfig.Network.LocalIPPort(tr.opConfig.destIP)
 
 	var ipString string
 
@@ -108,7 +162,7 @@ func (tr *Traceroute) getUDPConn(try int) (net.IP, int, net.PacketConn) {
 		ipString = srcIP.String()
 	}
 
-	udpConn, err := net.ListenPacket("udp", ipString+":0")
+	udpConn, err := tr.trcrtConfig.Network.ListenPacket("udp", net.JoinHostPort(ipString, "0"))
 	if err != nil {
 		if try > 3 {
 			log.Fatal(err)
@@ -119,72 +173,166 @@ func (tr *Traceroute) getUDPConn(try int) (net.IP, int, net.PacketConn) {
 	return srcIP, udpConn.LocalAddr().(*net.UDPAddr).Port, udpConn
 }
 
-//nolint:funlen  // required length exceeds recommended.
-func (tr *Traceroute) sendMessage(parentctx context.Context, ttl uint16) {
-	_, childSpan := tr.trcrtConfig.Tracer.Start(
-		parentctx,
-		fmt.Sprintf("%s/traceroute/%s", tr.trcrtConfig.LocalHostname, tr.opConfig.destIP),
+// payload returns the UDP payload for a probe sent from srcIP and srcPort.
+func (tr *Traceroute) payload(srcIP net.IP, srcPort int) ([]byte, error) {
+	if tr.opConfig.quic {
+		return quic.GenerateWithRandomIds(), nil
+	}
+	ipHeader := methods.NetworkLayer(srcIP, tr.opConfig.destIP, layers.IPProtocolUDP, 0)
+
+	udpHeader := &layers.UDP{
+		SrcPort: layers.UDPPort(srcPort),
+		DstPort: layers.UDPPort(tr.trcrtConfig.Port),
+	}
+	_ = udpHeader.SetNetworkLayerForChecksum(ipHeader)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		ComputeChecksums: true,
+		FixLengths:       true,
+	}
+	if err := gopacket.SerializeLayers(buf, opts, udpHeader, gopacket.Payload("HAJSFJHKAJSHFKJHAJKFHKASHKFHHKAFKHFAHSJK")); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// fail keeps the first error, the probes sent in parallel may fail after it.
+func (r *results) fail(err error) {
+	r.resultsMu.Lock()
+	defer r.resultsMu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// sendFailed records the error and stops the traceroute.
+func (tr *Traceroute) sendFailed(err error) {
+	tr.results.fail(err)
+	tr.opConfig.cancel()
+	tr.results.concurrentRequests.Finished()
+	tr.opConfig.wg.Done()
+}
+
+func (tr *Traceroute) sendMessage(ttl uint16) {
+	childSpan := tr.opConfig.spans.Start(
+		ttl,
 		tr.returnTraceAttributes(),
 		trace.WithAttributes(attribute.Int64("ttl", int64(ttl))),
 		trace.WithSpanKind(trace.SpanKindClient),
 	)
 	defer childSpan.End()
 
-	srcIP, srcPort, udpConn := tr.getUDPConn(0)
-
-	var payload []byte
-	if tr.opConfig.quic {
-		payload = quic.GenerateWithRandomIds()
+	var (
+		udpConn net.PacketConn
+		udpMsg  <-chan probeReply
+		payload []byte
+		key     uint16
+		err     error
+	)
+	if tr.sharedFlow() {
+		// the five-tuple is shared by all probes, the probe is identified by the UDP checksum.
+		udpConn = tr.opConfig.paris.conn
+		payload, key, err = tr.parisPayload()
 	} else {
-		ipHeader := &layers.IPv4{
-			SrcIP:    srcIP,
-			DstIP:    tr.opConfig.destIP,
-			Protocol: layers.IPProtocolTCP,
-			TTL:      uint8(ttl),
-		}
-
-		udpHeader := &layers.UDP{
-			SrcPort: layers.UDPPort(srcPort),
-			DstPort: layers.UDPPort(tr.trcrtConfig.Port),
-		}
-		_ = udpHeader.SetNetworkLayerForChecksum(ipHeader)
-		buf := gopacket.NewSerializeBuffer()
-		opts := gopacket.SerializeOptions{
-			ComputeChecksums: true,
-			FixLengths:       true,
+		var srcIP net.IP
+		var srcPort int
+		srcIP, srcPort, udpConn, err = tr.openProbeConn()
+		if err == nil {
+			defer udpConn.Close()
+			udpMsg = readReply(udpConn)
+			key = uint16(srcPort)
+			payload, err = tr.payload(srcIP, srcPort)
 		}
-		if err := gopacket.SerializeLayers(buf, opts, udpHeader, gopacket.Payload("HAJSFJHKAJSHFKJHAJKFHKASHKFHHKAFKHFAHSJK")); err != nil {
-			tr.results.err = err
-			tr.opConfig.cancel()
-			return
-		}
-
-		payload = buf.Bytes()
+	}
+	if err != nil {
+		tr.sendFailed(err)
+		return
 	}
 
-	err := ipv4.NewPacketConn(udpConn).SetTTL(int(ttl))
+	hop, err := tr.probe(udpConn, payload, key, ttl, udpMsg)
+	if methods.Cancelled(err) {
+		// a cancelled probe is not recorded as a timeout.
+		methods.SetSpanStatus(childSpan, err)
+		tr.results.concurrentRequests.Finished()
+		tr.opConfig.wg.Done()
+		return
+	}
 	if err != nil {
-		tr.results.err = err
-		tr.opConfig.cancel()
+		tr.sendFailed(err)
 		return
 	}
+	if hop.Success && (hop.Address.(*net.IPAddr).IP.Equal(tr.opConfig.destIP) || hop.Fatal()) {
+		tr.results.reachedFinalHop.Signal()
+	}
+	tr.addToResult(ttl, hop)
+	// the probe layout keeps the attributes of the earlier releases.
+	if !tr.opConfig.spans.RecordProbe(childSpan, hop) {
+		if hop.Success {
+			childSpan.SetAttributes(
+				attribute.String("hop", hop.Address.String()),
+				attribute.String("rtt", hop.RTT.String()),
+			)
+			childSpan.SetAttributes(hop.ICMPAttributes()...)
+			childSpan.SetStatus(codes.Ok, "success")
+		} else {
+			childSpan.SetAttributes(
+				attribute.String("hop", "null"),
+				attribute.String("rtt", ""),
+			)
+			childSpan.SetStatus(codes.Error, "failure")
+		}
+	}
 
-	icmpMsg := make(chan net.Addr, 1)
-	udpMsg := make(chan net.Addr, 1)
+	tr.results.concurrentRequests.Finished()
+	tr.opConfig.wg.Done()
+}
 
-	start := time.Now()
-	if _, err := udpConn.WriteTo(payload, &net.UDPAddr{IP: tr.opConfig.destIP, Port: tr.trcrtConfig.Port}); err != nil {
-		tr.results.err = err
-		tr.opConfig.cancel()
-		return
+// openProbeConn opens the connection of a probe and registers its source port, a port that is still registered by
+// another traceroute for its late replies is skipped.
+func (tr *Traceroute) openProbeConn() (net.IP, int, net.PacketConn, error) {
+	for attempt := 0; ; attempt++ {
+		srcIP, srcPort, udpConn := tr.getUDPConn(0)
+		err := tr.registerPort(uint16(srcPort))
+		if err == nil {
+			return srcIP, srcPort, udpConn, nil
+		}
+		udpConn.Close()
+		if !errors.Is(err, demux.ErrRegistered) || attempt == maxPortAttempts {
+			return nil, 0, nil, err
+		}
 	}
+}
 
-	inflight := inflightData{
-		icmpMsg: icmpMsg,
+// registerPort receives the ICMP replies to the probes sent from the port until the traceroute finishes.
+func (tr *Traceroute) registerPort(port uint16) error {
+	tr.opConfig.portsMu.Lock()
+	defer tr.opConfig.portsMu.Unlock()
+	if _, ok := tr.opConfig.ports[port]; ok {
+		return nil
 	}
+	err := tr.opConfig.receiver.Register(demux.UDPKey(port), tr.handleICMPMessage)
+	if err != nil {
+		return err
+	}
+	tr.opConfig.ports[port] = struct{}{}
+	return nil
+}
 
-	tr.results.inflightRequests.Store(uint16(srcPort), inflight)
+// unregisterPorts stops receiving the ICMP replies once the traceroute has finished.
+func (tr *Traceroute) unregisterPorts() {
+	tr.opConfig.portsMu.Lock()
+	defer tr.opConfig.portsMu.Unlock()
+	for port := range tr.opConfig.ports {
+		tr.opConfig.receiver.Unregister(demux.UDPKey(port))
+	}
+	tr.opConfig.ports = map[uint16]struct{}{}
+}
 
+// readReply reads the UDP reply from the destination on the connection of a single probe, the reader stops when
+// the connection is closed once the probe completed.
+func readReply(udpConn net.PacketConn) <-chan probeReply {
+	udpMsg := make(chan probeReply, 1)
 	go func() {
 		reply := make([]byte, 1500)
 		_, peer, err := udpConn.ReadFrom(reply)
@@ -192,114 +340,117 @@ func (tr *Traceroute) sendMessage(parentctx context.Context, ttl uint16) {
 			// probably because we closed the connection
 			return
 		}
-		udpMsg <- peer
+		udpMsg <- probeReply{peer: &net.IPAddr{IP: peer.(*net.UDPAddr).IP}, received: time.Now()}
 	}()
+	return udpMsg
+}
 
-	select {
-	case peer := <-icmpMsg:
-		rtt := time.Since(start)
-		if peer.(*net.IPAddr).IP.Equal(tr.opConfig.destIP) {
-			tr.results.reachedFinalHop.Signal()
-		}
-		tr.addToResult(ttl, methods.TracerouteHop{
-			Success: true,
-			Address: peer,
-			TTL:     ttl,
-			RTT:     &rtt,
-		})
-		childSpan.SetAttributes(
-			attribute.String("hop", peer.String()),
-			attribute.String("rtt", rtt.String()),
-		)
-		childSpan.SetStatus(codes.Ok, "success")
-
-	case peer := <-udpMsg:
-		rtt := time.Since(start)
-		ip := peer.(*net.UDPAddr).IP
-		if ip.Equal(tr.opConfig.destIP) {
-			tr.results.reachedFinalHop.Signal()
+// probe sends the payload and waits for the ICMP reply, the UDP reply from the destination read from udpMsg or the
+// timeout. udpMsg is nil when the replies from the destination are not read for the probe.
+func (tr *Traceroute) probe(udpConn net.PacketConn, payload []byte, key uint16, ttl uint16,
+	udpMsg <-chan probeReply,
+) (methods.TracerouteHop, error) {
+	icmpMsg := make(chan probeReply, 1)
+
+	if !tr.sharedFlow() {
+		// every probe has its own source port, in Paris mode the shared source port is registered once.
+		err := tr.registerPort(key)
+		if err != nil {
+			return methods.TracerouteHop{}, err
 		}
-		tr.addToResult(ttl, methods.TracerouteHop{
-			Success: true,
-			Address: &net.IPAddr{IP: ip},
-			TTL:     ttl,
-			RTT:     &rtt,
-		})
-		childSpan.SetAttributes(
-			attribute.String("hop", ip.String()),
-			attribute.String("rtt", rtt.String()),
-		)
-		childSpan.SetStatus(codes.Ok, "success")
+	}
+	tr.results.inflightRequests.Store(key, inflightData{
+		icmpMsg: icmpMsg,
+		ttl:     ttl,
+	})
+	defer tr.results.inflightRequests.Delete(key)
+
+	start, err := tr.writeProbe(udpConn, payload, ttl)
+	if err != nil {
+		return methods.TracerouteHop{}, err
+	}
 
+	var rep probeReply
+	select {
+	case rep = <-icmpMsg:
+	case rep = <-udpMsg:
+		tr.results.tracker.Complete(uint32(key), true)
+	case <-tr.opConfig.ctx.Done():
+		return methods.TracerouteHop{TTL: ttl}, tr.opConfig.ctx.Err()
 	case <-time.After(tr.trcrtConfig.Timeout):
-		tr.addToResult(ttl, methods.TracerouteHop{
+		if _, ok := tr.results.inflightRequests.LoadAndDelete(key); !ok {
+			// the reply was taken by the receiver as the probe timed out.
+			rep = <-icmpMsg
+			break
+		}
+		tr.results.tracker.Complete(uint32(key), false)
+		return methods.TracerouteHop{
 			Success: false,
 			Address: nil,
 			TTL:     ttl,
 			RTT:     nil,
-		})
-		childSpan.SetAttributes(
-			attribute.String("hop", "null"),
-			attribute.String("rtt", ""),
-		)
-		childSpan.SetStatus(codes.Error, "failure")
+		}, nil
 	}
-
-	tr.results.inflightRequests.Delete(uint16(srcPort))
-	udpConn.Close()
-	tr.results.concurrentRequests.Finished()
-	tr.opConfig.wg.Done()
+	rtt := rep.received.Sub(start)
+	return methods.TracerouteHop{
+		Success: true,
+		Address: rep.peer,
+		TTL:     ttl,
+		RTT:     &rtt,
+		ICMP:    rep.icmp,
+	}, nil
 }
 
-func (tr *Traceroute) handleICMPMessage(msg listener_channel.ReceivedMessage, data []byte) {
-	header, err := methods.GetICMPResponsePayload(data)
-	if err != nil {
-		return
+// writeProbe sets the TTL and sends the probe, it returns the time the probe was sent.
+func (tr *Traceroute) writeProbe(udpConn net.PacketConn, payload []byte, ttl uint16) (time.Time, error) {
+	if tr.sharedFlow() {
+		// the TTL is set on the shared connection so it must not change until the probe is sent.
+		tr.opConfig.paris.mu.Lock()
+		defer tr.opConfig.paris.mu.Unlock()
 	}
-	srcPort := methods.GetUDPSrcPort(header)
-	val, ok := tr.results.inflightRequests.LoadAndDelete(srcPort)
-	if !ok {
-		return
+	err := methods.SetTTL(udpConn, tr.opConfig.destIP, int(ttl))
+	if err != nil {
+		return time.Time{}, err
 	}
-	request := val.(inflightData)
-	request.icmpMsg <- msg.Peer
-}
-
-func (tr *Traceroute) icmpListener() {
-	lc := listener_channel.New(tr.opConfig.icmpConn)
 
-	defer lc.Stop()
+	start := time.Now()
+	_, err = udpConn.WriteTo(payload, &net.UDPAddr{IP: tr.opConfig.destIP, Port: tr.trcrtConfig.Port})
+	return start, err
+}
 
-	go lc.Start()
+// sharedFlow reports whether the probes share the source port of the Paris connection and are told apart by their
+// checksum. The flows of the multipath detection algorithm keep their own source port in Paris mode as well.
+func (tr *Traceroute) sharedFlow() bool {
+	return tr.trcrtConfig.Paris && !tr.opConfig.multipath
+}
 
-	for {
-		select {
-		case <-tr.opConfig.ctx.Done():
+// handleICMPMessage hands the ICMP reply to the probe quoted by the message, the replies that quote another
+// destination or port or arrive after their probe completed are counted and dropped.
+func (tr *Traceroute) handleICMPMessage(msg demux.Message) {
+	key := methods.GetUDPSrcPort(msg.Header)
+	if tr.sharedFlow() {
+		if !methods.QuotedProbe(msg.Dst, msg.Header, tr.opConfig.destIP, tr.opConfig.paris.srcPort, tr.trcrtConfig.Port) {
+			tr.results.tracker.Mismatched()
 			return
-		case msg := <-lc.Messages:
-			if msg.N == nil {
-				continue
-			}
-			rm, err := icmp.ParseMessage(1, msg.Msg[:*msg.N])
-			if err != nil {
-				log.Println(err)
-				continue
-			}
-			switch rm.Type {
-			case ipv4.ICMPTypeTimeExceeded:
-				body := rm.Body.(*icmp.TimeExceeded).Data
-				tr.handleICMPMessage(msg, body)
-			case ipv4.ICMPTypeDestinationUnreachable:
-				body := rm.Body.(*icmp.DstUnreach).Data
-				tr.handleICMPMessage(msg, body)
-			default:
-				log.Println("received icmp message of unknown type", rm.Type)
-			}
 		}
+		key = methods.GetUDPChecksum(msg.Header)
+	} else if !methods.QuotedProbe(msg.Dst, msg.Header, tr.opConfig.destIP, 0, tr.trcrtConfig.Port) {
+		tr.results.tracker.Mismatched()
+		return
 	}
+	val, ok := tr.results.inflightRequests.LoadAndDelete(key)
+	if !ok {
+		if !tr.results.tracker.Unmatched(uint32(key)) {
+			tr.results.tracker.Mismatched()
+		}
+		return
+	}
+	tr.results.tracker.Complete(uint32(key), true)
+	request := val.(inflightData)
+	request.icmpMsg <- probeReply{peer: msg.Peer, icmp: methods.NewICMPReply(msg.ICMP), received: msg.Received}
 }
 
-func (tr *Traceroute) sendLoop(parentctx context.Context) {
+func (tr *Traceroute) sendLoop() {
 	//nolint:gosec // not cryptographic
 	rand.New(rand.NewSource(time.Now().UTC().UnixNano()))
 
@@ -315,50 +466,64 @@ func (tr *Traceroute) sendLoop(parentctx context.Context) {
 				return
 			case <-tr.results.concurrentRequests.Start():
 				tr.opConfig.wg.Add()
-				go tr.sendMessage(parentctx, ttl)
+				go tr.sendMessage(ttl)
 			}
 		}
 	}
 }
 
-func (tr *Traceroute) start() (*map[uint16][]methods.TracerouteHop, error) {
+func (tr *Traceroute) start(ctx context.Context) (*methods.TraceResult, error) {
+	result := methods.NewTraceResult(tr.trcrtConfig.DestinationHostname, tr.opConfig.destIP, protocol, tr.trcrtConfig.Port)
+	result.Tags = tr.trcrtConfig.Tags
+
+	spanName := fmt.Sprintf("%s/traceroute/%s", tr.trcrtConfig.LocalHostname, tr.opConfig.destIP)
 	parentctx, parentSpan := tr.trcrtConfig.Tracer.Start(
 		tr.trcrtConfig.TraceCtx,
-		fmt.Sprintf("%s/traceroute/%s", tr.trcrtConfig.LocalHostname, tr.opConfig.destIP),
+		spanName,
 		tr.returnTraceAttributes(),
 		trace.WithSpanKind(trace.SpanKindClient),
 	)
 	defer parentSpan.End()
-
-	go tr.icmpListener()
+	tr.opConfig.spans = methods.NewProbeSpans(parentctx, &tr.trcrtConfig, spanName, protocol, tr.opConfig.destIP)
 
 	wg := taskgroup.New()
 	tr.opConfig.wg = wg
 
-	tr.sendLoop(parentctx)
+	tr.sendLoop()
 
 	wg.Wait()
 
 	tr.opConfig.cancel()
-	tr.opConfig.icmpConn.Close()
-
-	if tr.results.err != nil {
-		parentSpan.SetStatus(codes.Error, fmt.Sprintf("%s", tr.results.err))
-		return nil, tr.results.err
+	if tr.trcrtConfig.Paris {
+		tr.opConfig.receiver.Unregister(demux.UDPKey(uint16(tr.opConfig.paris.srcPort)))
+		tr.opConfig.paris.conn.Close()
+	}
+	tr.unregisterPorts()
+	tr.opConfig.receiver.Release()
+	if tr.results.err == nil {
+		tr.results.err = ctx.Err()
 	}
 
-	result := methods.ReduceFinalResult(tr.results.results, tr.trcrtConfig.MaxHops, tr.opConfig.destIP)
-	parentSpan.SetStatus(codes.Ok, "success")
+	result.Finish(tr.results.results, tr.trcrtConfig.MaxHops, tr.results.err)
+	result.Discarded = tr.results.tracker.Discarded()
+	methods.EnrichResult(parentctx, tr.trcrtConfig.Enricher, result, parentSpan)
+	methods.RecordPath(tr.trcrtConfig.PathRecorder, result, parentSpan)
+	tr.opConfig.spans.End(result)
+	methods.SetSpanStatus(parentSpan, tr.results.err)
 
-	return &result, tr.results.err
+	return result, tr.results.err
 }
 
 func (tr *Traceroute) returnTraceAttributes() trace.SpanStartEventOption {
-	return trace.WithAttributes(
+	attrs := []attribute.KeyValue{
 		attribute.String("source", tr.trcrtConfig.LocalHostname),
 		attribute.String("destination_hostname", tr.trcrtConfig.DestinationHostname),
 		attribute.Int64("max_ttl", int64(tr.trcrtConfig.MaxHops)),
-		attribute.String("protocol", "udp"),
+		attribute.String("protocol", protocol),
 		attribute.String("xid", tr.trcrtConfig.Xid.String()),
-	)
+	}
+	if tr.trcrtConfig.Cycle > 0 {
+		attrs = append(attrs, attribute.Int("cycle", tr.trcrtConfig.Cycle))
+	}
+	return trace.WithAttributes(append(attrs, methods.TagAttributes(tr.trcrtConfig.Tags)...)...)
 }
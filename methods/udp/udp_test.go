@@ -0,0 +1,266 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+	"github.com/jimmystewpot/traceroute/methods/simnet"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracerouteStart(t *testing.T) {
+	dest := net.ParseIP("192.0.2.1")
+	tests := []struct {
+		name     string
+		paris    bool
+		parallel uint16
+		routers  []simnet.Router
+		want     []string
+		// discarded are the replies that are not recorded.
+		discarded methods.DiscardedReplies
+	}{
+		{
+			name: "reaches the destination",
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), Delay: time.Millisecond},
+				{Addr: net.ParseIP("10.0.1.1"), Delay: 2 * time.Millisecond},
+				{Addr: dest, Delay: 3 * time.Millisecond},
+			},
+			want: []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 10.0.1.1 10.0.1.1 10.0.1.1", "3 192.0.2.1 192.0.2.1 192.0.2.1"},
+		},
+		{
+			name:  "paris",
+			paris: true,
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), Delay: time.Millisecond},
+				{Addr: dest, Delay: 2 * time.Millisecond},
+			},
+			want: []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 192.0.2.1 192.0.2.1 192.0.2.1"},
+		},
+		{
+			name: "silent hop",
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1")},
+				{Addr: net.ParseIP("10.0.1.1"), Silent: true},
+				{Addr: dest},
+			},
+			want: []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 * * *", "3 192.0.2.1 192.0.2.1 192.0.2.1"},
+		},
+		{
+			name: "loss",
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), Loss: 0.5},
+				{Addr: dest},
+			},
+			want: []string{"1 10.0.0.1 10.0.0.1 *", "2 192.0.2.1 192.0.2.1 192.0.2.1"},
+		},
+		{
+			name: "rate limited",
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), RateLimit: 1},
+				{Addr: dest},
+			},
+			want: []string{"1 10.0.0.1 * *", "2 192.0.2.1 192.0.2.1 192.0.2.1"},
+		},
+		{
+			name: "unreachable",
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1")},
+				{Addr: net.ParseIP("10.0.1.1"), Unreachable: true, Code: 1},
+				{Addr: dest},
+			},
+			want: []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 10.0.1.1 !H 10.0.1.1 !H 10.0.1.1 !H"},
+		},
+		{
+			name: "duplicate replies",
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), Duplicate: true},
+				{Addr: net.ParseIP("10.0.1.1"), Silent: true},
+				{Addr: dest},
+			},
+			want:      []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 * * *", "3 192.0.2.1 192.0.2.1 192.0.2.1"},
+			discarded: methods.DiscardedReplies{Duplicate: 3},
+		},
+		{
+			name:  "paris duplicate replies",
+			paris: true,
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), Duplicate: true},
+				{Addr: net.ParseIP("10.0.1.1"), Silent: true},
+				{Addr: dest},
+			},
+			want:      []string{"1 10.0.0.1 10.0.0.1 10.0.0.1", "2 * * *", "3 192.0.2.1 192.0.2.1 192.0.2.1"},
+			discarded: methods.DiscardedReplies{Duplicate: 3},
+		},
+		{
+			// the hops are probed one after the other so the replies of the first hop arrive while the second
+			// hop is probed.
+			name:     "late replies",
+			parallel: 3,
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), Delay: 300 * time.Millisecond},
+				{Addr: net.ParseIP("10.0.1.1"), Silent: true},
+				{Addr: dest},
+			},
+			want:      []string{"1 * * *", "2 * * *", "3 192.0.2.1 192.0.2.1 192.0.2.1"},
+			discarded: methods.DiscardedReplies{Late: 3},
+		},
+		{
+			name: "mismatched destination",
+			routers: []simnet.Router{
+				{Addr: net.ParseIP("10.0.0.1"), QuoteDst: net.ParseIP("192.0.2.99")},
+				{Addr: dest},
+			},
+			want:      []string{"1 * * *", "2 192.0.2.1 192.0.2.1 192.0.2.1"},
+			discarded: methods.DiscardedReplies{Mismatched: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parallel := tt.parallel
+			if parallel == 0 {
+				parallel = 6
+			}
+			cfg := methods.TracerouteConfig{
+				MaxHops:          5,
+				NumMeasurements:  3,
+				ParallelRequests: parallel,
+				Port:             33434,
+				Timeout:          200 * time.Millisecond,
+				Paris:            tt.paris,
+				Tracer:           otel.Tracer("test"),
+				TraceCtx:         context.Background(),
+				Network:          simnet.New(net.ParseIP("198.51.100.1"), tt.routers...),
+			}
+			res, err := New(dest, false, cfg).Start()
+			if err != nil {
+				t.Fatalf("Traceroute.Start() error = %v", err)
+			}
+			if got := simnet.Summary(res); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Traceroute.Start() = %q, want %q", got, tt.want)
+			}
+			if res.Discarded != tt.discarded {
+				t.Errorf("Traceroute.Start() discarded = %+v, want %+v", res.Discarded, tt.discarded)
+			}
+		})
+	}
+}
+
+func TestTracerouteSendFailed(t *testing.T) {
+	dest := net.ParseIP("192.0.2.1")
+	sendErr := errors.New("network is unreachable")
+	for _, paris := range []bool{false, true} {
+		t.Run(fmt.Sprintf("paris %t", paris), func(t *testing.T) {
+			network := simnet.New(net.ParseIP("198.51.100.1"), simnet.Router{Addr: net.ParseIP("10.0.0.1")}, simnet.Router{Addr: dest})
+			network.SendErr = sendErr
+			// the probes sent in parallel all fail, the first error is returned.
+			cfg := methods.TracerouteConfig{
+				MaxHops:          5,
+				NumMeasurements:  3,
+				ParallelRequests: 6,
+				Port:             33434,
+				Timeout:          200 * time.Millisecond,
+				Paris:            paris,
+				Tracer:           otel.Tracer("test"),
+				TraceCtx:         context.Background(),
+				Network:          network,
+			}
+			res, err := New(dest, false, cfg).Start()
+			if !errors.Is(err, sendErr) {
+				t.Fatalf("Traceroute.Start() error = %v, want %v", err, sendErr)
+			}
+			if res == nil || len(res.Path()) != 0 {
+				t.Errorf("Traceroute.Start() = %+v, want no replies", res)
+			}
+		})
+	}
+}
+
+func TestTracerouteStartMultipath(t *testing.T) {
+	dest := net.ParseIP("192.0.2.1")
+	for _, paris := range []bool{false, true} {
+		t.Run(fmt.Sprintf("paris %t", paris), func(t *testing.T) {
+			cfg := methods.TracerouteConfig{
+				MaxHops:             5,
+				ParallelRequests:    6,
+				Port:                33434,
+				Timeout:             200 * time.Millisecond,
+				Paris:               paris,
+				Multipath:           true,
+				MultipathConfidence: 0.95,
+				Tracer:              otel.Tracer("test"),
+				TraceCtx:            context.Background(),
+				Network: simnet.New(net.ParseIP("198.51.100.1"),
+					simnet.Router{Addr: net.ParseIP("10.0.0.1"), Delay: time.Millisecond},
+					simnet.Router{Addr: net.ParseIP("10.0.1.1"), Delay: time.Millisecond},
+					simnet.Router{Addr: dest, Delay: time.Millisecond},
+				),
+			}
+			res, err := New(dest, false, cfg).StartMultipath()
+			if err != nil {
+				t.Fatalf("Traceroute.StartMultipath() error = %v", err)
+			}
+			for ttl, want := range map[uint16][]string{1: {"10.0.0.1"}, 2: {"10.0.1.1"}, 3: {"192.0.2.1"}} {
+				if got := res.Multipath.Interfaces(ttl); !reflect.DeepEqual(got, want) {
+					t.Errorf("Traceroute.StartMultipath() ttl %d interfaces = %v, want %v", ttl, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTracerouteSpanLayout(t *testing.T) {
+	dest := net.ParseIP("192.0.2.1")
+	recorder := tracetest.NewSpanRecorder()
+	cfg := methods.TracerouteConfig{
+		LocalHostname:    "host",
+		MaxHops:          5,
+		NumMeasurements:  3,
+		ParallelRequests: 6,
+		Port:             33434,
+		Timeout:          200 * time.Millisecond,
+		SpanLayout:       methods.SpanLayoutHop,
+		Tracer:           sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test"),
+		TraceCtx:         context.Background(),
+		Network: simnet.New(net.ParseIP("198.51.100.1"),
+			simnet.Router{Addr: net.ParseIP("10.0.0.1")},
+			simnet.Router{Addr: net.ParseIP("10.0.1.1"), Silent: true},
+			simnet.Router{Addr: dest},
+		),
+	}
+	_, err := New(dest, false, cfg).Start()
+	if err != nil {
+		t.Fatalf("Traceroute.Start() error = %v", err)
+	}
+
+	// every probe is a child of the span of its hop, the hop spans are children of the traceroute span.
+	names := map[trace.SpanID]string{}
+	for _, span := range recorder.Ended() {
+		names[span.SpanContext().SpanID()] = span.Name()
+	}
+	children := map[string]int{}
+	for _, span := range recorder.Ended() {
+		if span.Parent().IsValid() {
+			children[names[span.Parent().SpanID()]]++
+		}
+	}
+	want := map[string]int{"hop 1 10.0.0.1": 3, "hop 2 *": 3, "hop 3 192.0.2.1": 3}
+	for name, n := range want {
+		if children[name] != n {
+			t.Errorf("span %q has %d children, want %d (all %v)", name, children[name], n, children)
+		}
+	}
+	// the TTLs probed in parallel after the destination keep their own spans.
+	if got := children["host/traceroute/192.0.2.1"]; got < len(want) {
+		t.Errorf("traceroute span has %d children, want at least %d", got, len(want))
+	}
+}
@@ -0,0 +1,128 @@
+package methods
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// ICMP and ICMPv6 destination unreachable codes that are expected from the destination.
+	icmpCodePortUnreachable   uint8 = 3
+	icmpv6CodePortUnreachable uint8 = 4
+)
+
+// ICMPReply is the type and code of the ICMP message received in reply to a probe.
+type ICMPReply struct {
+	Type uint8       `json:"type"`
+	Code uint8       `json:"code"`
+	MPLS []MPLSLabel `json:"mpls,omitempty"`
+}
+
+// NewICMPReply returns the type, code and MPLS label stack of a parsed ICMP or ICMPv6 message.
+func NewICMPReply(rm *icmp.Message) *ICMPReply {
+	reply := &ICMPReply{Code: uint8(rm.Code), MPLS: GetMPLSLabels(rm)}
+	switch typ := rm.Type.(type) {
+	case ipv4.ICMPType:
+		reply.Type = uint8(typ)
+	case ipv6.ICMPType:
+		reply.Type = uint8(typ)
+	}
+	return reply
+}
+
+// unreachable returns true when the hop replied with an ICMP or ICMPv6 destination unreachable.
+func (th TracerouteHop) unreachable() bool {
+	if th.ICMP == nil || th.Address == nil {
+		return false
+	}
+	if IsIPv6(AddrIP(th.Address)) {
+		return th.ICMP.Type == uint8(ipv6.ICMPTypeDestinationUnreachable)
+	}
+	return th.ICMP.Type == uint8(ipv4.ICMPTypeDestinationUnreachable)
+}
+
+// Marker returns the classic traceroute annotation for a destination unreachable, port unreachable
+// is the expected reply from the destination and is not annotated.
+func (th TracerouteHop) Marker() string {
+	if !th.unreachable() {
+		return ""
+	}
+	if IsIPv6(AddrIP(th.Address)) {
+		return icmpv6Marker(th.ICMP.Code)
+	}
+	return icmpMarker(th.ICMP.Code)
+}
+
+// Fatal returns true when the hop reported that the destination can not be reached, there is no
+// reason to keep probing with higher TTLs.
+func (th TracerouteHop) Fatal() bool {
+	return th.Marker() != ""
+}
+
+//nolint:gomnd // ICMP destination unreachable codes (RFC 792, RFC 1812).
+func icmpMarker(code uint8) string {
+	switch code {
+	case 0, 6, 11:
+		return "!N"
+	case 1, 7, 12:
+		return "!H"
+	case 2:
+		return "!P"
+	case icmpCodePortUnreachable:
+		return ""
+	case 4:
+		return "!F"
+	case 5, 8:
+		return "!S"
+	case 9, 10, 13:
+		return "!X"
+	case 14:
+		return "!V"
+	case 15:
+		return "!C"
+	}
+	return fmt.Sprintf("!<%d>", code)
+}
+
+//nolint:gomnd // ICMPv6 destination unreachable codes (RFC 4443).
+func icmpv6Marker(code uint8) string {
+	switch code {
+	case 0:
+		return "!N"
+	case 1, 5, 6:
+		return "!X"
+	case 2:
+		return "!S"
+	case 3:
+		return "!H"
+	case icmpv6CodePortUnreachable:
+		return ""
+	}
+	return fmt.Sprintf("!<%d>", code)
+}
+
+// ICMPAttributes returns the span attributes describing the ICMP reply to the probe.
+func (th TracerouteHop) ICMPAttributes() []attribute.KeyValue {
+	if th.ICMP == nil {
+		return nil
+	}
+	attrs := []attribute.KeyValue{
+		attribute.Int("icmp.type", int(th.ICMP.Type)),
+		attribute.Int("icmp.code", int(th.ICMP.Code)),
+	}
+	if marker := th.Marker(); marker != "" {
+		attrs = append(attrs, attribute.String("icmp.unreachable", marker))
+	}
+	if len(th.ICMP.MPLS) > 0 {
+		labels := make([]string, 0, len(th.ICMP.MPLS))
+		for _, label := range th.ICMP.MPLS {
+			labels = append(labels, label.String())
+		}
+		attrs = append(attrs, attribute.StringSlice("icmp.mpls", labels))
+	}
+	return attrs
+}
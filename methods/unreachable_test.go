@@ -0,0 +1,81 @@
+package methods
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestNewICMPReply(t *testing.T) {
+	tests := []struct {
+		name string
+		rm   *icmp.Message
+		want ICMPReply
+	}{
+		{name: "time exceeded", rm: &icmp.Message{Type: ipv4.ICMPTypeTimeExceeded}, want: ICMPReply{Type: 11}},
+		{name: "admin prohibited", rm: &icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 13}, want: ICMPReply{Type: 3, Code: 13}},
+		{name: "icmpv6 port unreachable", rm: &icmp.Message{Type: ipv6.ICMPTypeDestinationUnreachable, Code: 4}, want: ICMPReply{Type: 1, Code: 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewICMPReply(tt.rm); !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("NewICMPReply() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTracerouteHopMarker(t *testing.T) {
+	v4 := &net.IPAddr{IP: net.ParseIP("10.0.0.1")}
+	v6 := &net.IPAddr{IP: net.ParseIP("2001:db8::1")}
+	tests := []struct {
+		name      string
+		hop       TracerouteHop
+		want      string
+		wantFatal bool
+	}{
+		{name: "no icmp", hop: TracerouteHop{Success: true, Address: v4}, want: ""},
+		{name: "time exceeded", hop: TracerouteHop{Success: true, Address: v4, ICMP: &ICMPReply{Type: 11}}, want: ""},
+		{name: "port unreachable", hop: TracerouteHop{Success: true, Address: v4, ICMP: &ICMPReply{Type: 3, Code: 3}}, want: ""},
+		{name: "network unreachable", hop: TracerouteHop{Success: true, Address: v4, ICMP: &ICMPReply{Type: 3, Code: 0}}, want: "!N", wantFatal: true},
+		{name: "host unreachable", hop: TracerouteHop{Success: true, Address: v4, ICMP: &ICMPReply{Type: 3, Code: 1}}, want: "!H", wantFatal: true},
+		{name: "protocol unreachable", hop: TracerouteHop{Success: true, Address: v4, ICMP: &ICMPReply{Type: 3, Code: 2}}, want: "!P", wantFatal: true},
+		{name: "admin prohibited", hop: TracerouteHop{Success: true, Address: v4, ICMP: &ICMPReply{Type: 3, Code: 13}}, want: "!X", wantFatal: true},
+		{name: "unknown code", hop: TracerouteHop{Success: true, Address: v4, ICMP: &ICMPReply{Type: 3, Code: 42}}, want: "!<42>", wantFatal: true},
+		{name: "icmpv6 port unreachable", hop: TracerouteHop{Success: true, Address: v6, ICMP: &ICMPReply{Type: 1, Code: 4}}, want: ""},
+		{name: "icmpv6 admin prohibited", hop: TracerouteHop{Success: true, Address: v6, ICMP: &ICMPReply{Type: 1, Code: 1}}, want: "!X", wantFatal: true},
+		{name: "icmpv6 address unreachable", hop: TracerouteHop{Success: true, Address: v6, ICMP: &ICMPReply{Type: 1, Code: 3}}, want: "!H", wantFatal: true},
+		{name: "icmpv6 packet too big", hop: TracerouteHop{Success: true, Address: v6, ICMP: &ICMPReply{Type: 2}}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hop.Marker(); got != tt.want {
+				t.Errorf("TracerouteHop.Marker() = %v, want %v", got, tt.want)
+			}
+			if got := tt.hop.Fatal(); got != tt.wantFatal {
+				t.Errorf("TracerouteHop.Fatal() = %v, want %v", got, tt.wantFatal)
+			}
+		})
+	}
+}
+
+func TestReduceFinalResultFatal(t *testing.T) {
+	rtt := time.Millisecond
+	preliminary := map[uint16][]TracerouteHop{
+		1: {{Success: true, Address: &net.IPAddr{IP: net.ParseIP("10.0.0.1")}, TTL: 1, RTT: &rtt, ICMP: &ICMPReply{Type: 11}}},
+		2: {{Success: true, Address: &net.IPAddr{IP: net.ParseIP("10.0.0.2")}, TTL: 2, RTT: &rtt, ICMP: &ICMPReply{Type: 3, Code: 13}}},
+		3: {{Success: false, TTL: 3}},
+	}
+	got := ReduceFinalResult(preliminary, 30, net.ParseIP("192.0.2.1"))
+	if len(got) != 2 {
+		t.Errorf("ReduceFinalResult() = %v, want the hops up to the fatal unreachable", got)
+	}
+	if s := got[2][0].String(); s != "10.0.0.2 1ms !X" {
+		t.Errorf("TracerouteHop.String() = %v, want %v", s, "10.0.0.2 1ms !X")
+	}
+}
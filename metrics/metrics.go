@@ -0,0 +1,329 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Type is the type of a metric family.
+type Type string
+
+const (
+	TypeCounter   Type = "counter"
+	TypeGauge     Type = "gauge"
+	TypeHistogram Type = "histogram"
+
+	// labelSeparator joins the label values into the key of a series, it can not appear in valid UTF-8.
+	labelSeparator string = "\xff"
+	// ContentType is the content type of the Prometheus text exposition format.
+	ContentType string = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// Registry holds the metric families and renders them in the Prometheus text exposition format.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// family is a metric and every series recorded for it, indexed by the joined label values.
+type family struct {
+	name    string
+	help    string
+	typ     Type
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// counts holds the number of observations per bucket for histograms, they are not cumulative.
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Family is a point in time copy of a metric family.
+type Family struct {
+	Name    string
+	Help    string
+	Type    Type
+	Buckets []float64
+	Series  []Series
+}
+
+// Series is a point in time copy of a series, Value is used by counters and gauges and the
+// remaining fields by histograms. Bucket counts are cumulative.
+type Series struct {
+	Labels       []Label
+	Value        float64
+	BucketCounts []uint64
+	Count        uint64
+	Sum          float64
+}
+
+// Label is the name and value of a label of a series.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Counter is a metric that only increases.
+type Counter struct{ f *family }
+
+// Gauge is a metric that can be set to any value.
+type Gauge struct{ f *family }
+
+// Histogram counts observations into buckets.
+type Histogram struct{ f *family }
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		families: map[string]*family{},
+	}
+}
+
+// NewCounter registers a counter, the label values are passed in the same order as labels when it is incremented.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{f: r.register(name, help, TypeCounter, nil, labels)}
+}
+
+// NewGauge registers a gauge.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{f: r.register(name, help, TypeGauge, nil, labels)}
+}
+
+// NewHistogram registers a histogram with the upper bounds of the buckets in increasing order, the +Inf bucket is implicit.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{f: r.register(name, help, TypeHistogram, buckets, labels)}
+}
+
+func (r *Registry) register(name, help string, typ Type, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("metric %s is already registered", name))
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	r.families[name] = f
+	return f
+}
+
+// with returns the series for the label values, the family lock must be held.
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, %d values given", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, labelSeparator)
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		if f.typ == TypeHistogram {
+			s.counts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter by v, negative values are ignored.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.with(labelValues).value += v
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.with(labelValues).value = v
+}
+
+// DeleteFunc removes the series of the gauge for which del returns true, it is called with the label values in
+// the same order as the labels.
+func (g *Gauge) DeleteFunc(del func(labelValues []string) bool) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	for key, s := range g.f.series {
+		if del(s.labelValues) {
+			delete(g.f.series, key)
+		}
+	}
+}
+
+// Observe adds v to the histogram.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.with(labelValues)
+	i := sort.SearchFloat64s(h.f.buckets, v)
+	s.counts[i]++
+	s.count++
+	s.sum += v
+}
+
+// Snapshot returns a copy of every family sorted by name, the series are sorted by label values.
+func (r *Registry) Snapshot() []Family {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	snapshot := make([]Family, 0, len(families))
+	for _, f := range families {
+		snapshot = append(snapshot, f.snapshot())
+	}
+	return snapshot
+}
+
+func (f *family) snapshot() Family {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fam := Family{
+		Name:    f.name,
+		Help:    f.help,
+		Type:    f.typ,
+		Buckets: f.buckets,
+		Series:  make([]Series, 0, len(keys)),
+	}
+	for _, key := range keys {
+		s := f.series[key]
+		out := Series{
+			Labels: make([]Label, len(f.labels)),
+			Value:  s.value,
+			Count:  s.count,
+			Sum:    s.sum,
+		}
+		for i, name := range f.labels {
+			out.Labels[i] = Label{Name: name, Value: s.labelValues[i]}
+		}
+		if f.typ == TypeHistogram {
+			out.BucketCounts = make([]uint64, len(s.counts))
+			var cumulative uint64
+			for i, c := range s.counts {
+				cumulative += c
+				out.BucketCounts[i] = cumulative
+			}
+		}
+		fam.Series = append(fam.Series, out)
+	}
+	return fam
+}
+
+// WriteText writes every family in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, fam := range r.Snapshot() {
+		fmt.Fprintf(&b, "# HELP %s %s\n", fam.Name, escapeHelp(fam.Help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", fam.Name, fam.Type)
+		for _, s := range fam.Series {
+			if fam.Type != TypeHistogram {
+				fmt.Fprintf(&b, "%s%s %s\n", fam.Name, formatLabels(s.Labels), formatFloat(s.Value))
+				continue
+			}
+			for i, upper := range fam.Buckets {
+				le := append(append([]Label{}, s.Labels...), Label{Name: "le", Value: formatFloat(upper)})
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", fam.Name, formatLabels(le), s.BucketCounts[i])
+			}
+			le := append(append([]Label{}, s.Labels...), Label{Name: "le", Value: "+Inf"})
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", fam.Name, formatLabels(le), s.Count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", fam.Name, formatLabels(s.Labels), formatFloat(s.Sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", fam.Name, formatLabels(s.Labels), s.Count)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	err := r.WriteText(w)
+	if err != nil {
+		http.Error(w, "service error", http.StatusInternalServerError)
+	}
+}
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l.Name, escapeLabel(l.Value)))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeLabel escapes the backslash, double quote and line feed in a label value.
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func escapeHelp(v string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// LinearBuckets returns count buckets starting at start and width apart.
+func LinearBuckets(start, width float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start + float64(i)*width
+	}
+	return buckets
+}
+
+// ExponentialBuckets returns count buckets starting at start, each factor times the previous one.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRegistryWriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "A counter.", "destination")
+	g := r.NewGauge("test_gauge", "A gauge\nwith two lines.")
+	h := r.NewHistogram("test_seconds", "A histogram.", []float64{0.1, 1}, "destination")
+
+	c.Inc("example.com")
+	c.Add(2, "example.com")
+	c.Add(-1, "example.com")
+	c.Inc(`quote"back\slash`)
+	g.Set(42.5)
+	h.Observe(0.05, "example.com")
+	h.Observe(0.1, "example.com")
+	h.Observe(0.5, "example.com")
+	h.Observe(5, "example.com")
+
+	var buf bytes.Buffer
+	err := r.WriteText(&buf)
+	if err != nil {
+		t.Fatalf("Registry.WriteText() error = %v", err)
+	}
+	want := `# HELP test_gauge A gauge\nwith two lines.
+# TYPE test_gauge gauge
+test_gauge 42.5
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{destination="example.com",le="0.1"} 2
+test_seconds_bucket{destination="example.com",le="1"} 3
+test_seconds_bucket{destination="example.com",le="+Inf"} 4
+test_seconds_sum{destination="example.com"} 5.65
+test_seconds_count{destination="example.com"} 4
+# HELP test_total A counter.
+# TYPE test_total counter
+test_total{destination="example.com"} 3
+test_total{destination="quote\"back\\slash"} 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Registry.WriteText() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "A counter.").Inc()
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != ContentType {
+		t.Errorf("Registry.ServeHTTP() = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	if got := LinearBuckets(1, 2, 3); !reflect.DeepEqual(got, []float64{1, 3, 5}) {
+		t.Errorf("LinearBuckets() = %v", got)
+	}
+	if got := ExponentialBuckets(1, 2, 3); !reflect.DeepEqual(got, []float64{1, 2, 4}) {
+		t.Errorf("ExponentialBuckets() = %v", got)
+	}
+}
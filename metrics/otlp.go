@@ -0,0 +1,327 @@
+package metrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// scopeName is the instrumentation scope of the exported metrics.
+	scopeName string = "github.com/jimmystewpot/traceroute/metrics"
+	// OTLPMetricsPath is the default URL path of the OTLP/HTTP metrics endpoint.
+	OTLPMetricsPath string = "/v1/metrics"
+)
+
+// OTLPExporter pushes the metrics of a registry to an OpenTelemetry collector, the counters and histograms
+// are cumulative from the time the exporter was created.
+type OTLPExporter struct {
+	registry   *Registry
+	client     otlpClient
+	resource   *resourcepb.Resource
+	attributes []*commonpb.KeyValue
+	start      time.Time
+}
+
+// otlpClient sends an export request over gRPC or HTTP.
+type otlpClient interface {
+	export(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) error
+	close() error
+}
+
+type otlpGRPCClient struct {
+	conn   grpc.ClientConnInterface
+	client colmetricpb.MetricsServiceClient
+	otlpOptions
+}
+
+type otlpHTTPClient struct {
+	url    string
+	client *http.Client
+	otlpOptions
+}
+
+// otlpOptions are the request settings shared by both transports.
+type otlpOptions struct {
+	headers map[string]string
+	gzip    bool
+}
+
+// OTLPOption configures the requests sent by the exporter.
+type OTLPOption func(*otlpOptions)
+
+// WithHeaders adds the headers to every request, they are sent as metadata over gRPC.
+func WithHeaders(headers map[string]string) OTLPOption {
+	return func(o *otlpOptions) {
+		o.headers = headers
+	}
+}
+
+// WithGzip compresses the requests.
+func WithGzip() OTLPOption {
+	return func(o *otlpOptions) {
+		o.gzip = true
+	}
+}
+
+func newOTLPOptions(opts []OTLPOption) otlpOptions {
+	var o otlpOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// NewOTLPGRPCExporter returns an exporter that sends the metrics over the gRPC connection, the connection is closed
+// by Close. The resource attributes describe the process and the attributes are added to every data point.
+func NewOTLPGRPCExporter(registry *Registry, conn grpc.ClientConnInterface, resource, attributes map[string]string,
+	opts ...OTLPOption) *OTLPExporter {
+	client := &otlpGRPCClient{conn: conn, client: colmetricpb.NewMetricsServiceClient(conn), otlpOptions: newOTLPOptions(opts)}
+	return newOTLPExporter(registry, client, resource, attributes)
+}
+
+// NewOTLPHTTPExporter returns an exporter that posts the metrics as protobuf to the URL.
+func NewOTLPHTTPExporter(registry *Registry, url string, client *http.Client, resource, attributes map[string]string,
+	opts ...OTLPOption) *OTLPExporter {
+	return newOTLPExporter(registry, &otlpHTTPClient{url: url, client: client, otlpOptions: newOTLPOptions(opts)}, resource, attributes)
+}
+
+func newOTLPExporter(registry *Registry, client otlpClient, resource, attributes map[string]string) *OTLPExporter {
+	return &OTLPExporter{
+		registry:   registry,
+		client:     client,
+		resource:   &resourcepb.Resource{Attributes: keyValues(resource)},
+		attributes: keyValues(attributes),
+		start:      time.Now(),
+	}
+}
+
+// Export sends the current value of every metric.
+func (e *OTLPExporter) Export(ctx context.Context) error {
+	return e.client.export(ctx, e.request(time.Now()))
+}
+
+// Close closes the gRPC connection or the idle HTTP connections of the exporter, it is called once the metrics
+// have been exported a final time.
+func (e *OTLPExporter) Close() error {
+	return e.client.close()
+}
+
+// Run exports the metrics every interval until the context is cancelled.
+func (e *OTLPExporter) Run(ctx context.Context, interval time.Duration, errs func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := e.Export(ctx)
+			if err != nil && errs != nil {
+				errs(err)
+			}
+		}
+	}
+}
+
+// request converts the registry snapshot to an OTLP export request.
+func (e *OTLPExporter) request(now time.Time) *colmetricpb.ExportMetricsServiceRequest {
+	snapshot := e.registry.Snapshot()
+	metrics := make([]*metricpb.Metric, 0, len(snapshot))
+	for _, fam := range snapshot {
+		if len(fam.Series) == 0 {
+			continue
+		}
+		metrics = append(metrics, e.metric(fam, now))
+	}
+	return &colmetricpb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricpb.ResourceMetrics{{
+			Resource: e.resource,
+			ScopeMetrics: []*metricpb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: scopeName},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
+
+//nolint:gocritic // the family is a copy from the snapshot
+func (e *OTLPExporter) metric(fam Family, now time.Time) *metricpb.Metric {
+	start := uint64(e.start.UnixNano())
+	ts := uint64(now.UnixNano())
+	m := &metricpb.Metric{
+		Name:        fam.Name,
+		Description: fam.Help,
+		Unit:        unit(fam.Name),
+	}
+	switch fam.Type {
+	case TypeCounter, TypeGauge:
+		points := make([]*metricpb.NumberDataPoint, 0, len(fam.Series))
+		for _, s := range fam.Series {
+			points = append(points, &metricpb.NumberDataPoint{
+				Attributes:        e.pointAttributes(s.Labels),
+				StartTimeUnixNano: start,
+				TimeUnixNano:      ts,
+				Value:             &metricpb.NumberDataPoint_AsDouble{AsDouble: s.Value},
+			})
+		}
+		if fam.Type == TypeGauge {
+			m.Data = &metricpb.Metric_Gauge{Gauge: &metricpb.Gauge{DataPoints: points}}
+			return m
+		}
+		m.Data = &metricpb.Metric_Sum{Sum: &metricpb.Sum{
+			DataPoints:             points,
+			AggregationTemporality: metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			IsMonotonic:            true,
+		}}
+	case TypeHistogram:
+		points := make([]*metricpb.HistogramDataPoint, 0, len(fam.Series))
+		for _, s := range fam.Series {
+			sum := s.Sum
+			// the snapshot bucket counts are cumulative and end with the +Inf bucket, OTLP counts each bucket separately.
+			counts := make([]uint64, len(fam.Buckets)+1)
+			var previous uint64
+			for i := range fam.Buckets {
+				counts[i] = s.BucketCounts[i] - previous
+				previous = s.BucketCounts[i]
+			}
+			counts[len(fam.Buckets)] = s.Count - previous
+			points = append(points, &metricpb.HistogramDataPoint{
+				Attributes:        e.pointAttributes(s.Labels),
+				StartTimeUnixNano: start,
+				TimeUnixNano:      ts,
+				Count:             s.Count,
+				Sum:               &sum,
+				BucketCounts:      counts,
+				ExplicitBounds:    fam.Buckets,
+			})
+		}
+		m.Data = &metricpb.Metric_Histogram{Histogram: &metricpb.Histogram{
+			DataPoints:             points,
+			AggregationTemporality: metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+		}}
+	}
+	return m
+}
+
+// pointAttributes returns the labels of the series followed by the exporter attributes.
+func (e *OTLPExporter) pointAttributes(labels []Label) []*commonpb.KeyValue {
+	attrs := make([]*commonpb.KeyValue, 0, len(labels)+len(e.attributes))
+	for _, l := range labels {
+		attrs = append(attrs, keyValue(l.Name, l.Value))
+	}
+	return append(attrs, e.attributes...)
+}
+
+func (c *otlpGRPCClient) export(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) error {
+	if len(c.headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(c.headers))
+	}
+	var callOpts []grpc.CallOption
+	if c.gzip {
+		callOpts = append(callOpts, grpc.UseCompressor(grpcgzip.Name))
+	}
+	_, err := c.client.Export(ctx, req, callOpts...)
+	return err
+}
+
+// close closes the connection, a connection that can not be closed is left to its owner.
+func (c *otlpGRPCClient) close() error {
+	if closer, ok := c.conn.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *otlpHTTPClient) export(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if c.gzip {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		_, err = zw.Write(body)
+		if err != nil {
+			return err
+		}
+		err = zw.Close()
+		if err != nil {
+			return err
+		}
+		body = b.Bytes()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range c.headers {
+		httpReq.Header.Set(k, v)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	if c.gzip {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("otlp metrics export to %s failed: %s", c.url, resp.Status)
+	}
+	return nil
+}
+
+func (c *otlpHTTPClient) close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// unit returns the UCUM unit from the suffix of the metric name.
+func unit(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_seconds"):
+		return "s"
+	case strings.HasSuffix(name, "_percent"):
+		return "%"
+	}
+	return "1"
+}
+
+// keyValues converts the map to attributes sorted by key.
+func keyValues(m map[string]string) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, keyValue(k, m[k]))
+	}
+	return kvs
+}
+
+func keyValue(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
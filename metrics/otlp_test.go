@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+)
+
+// metricsService receives the export requests in place of a collector.
+type metricsService struct {
+	colmetricpb.UnimplementedMetricsServiceServer
+	requests chan *colmetricpb.ExportMetricsServiceRequest
+}
+
+func (m *metricsService) Export(_ context.Context, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	m.requests <- req
+	return &colmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+func testRegistry() *Registry {
+	r := NewRegistry()
+	r.NewCounter("test_total", "A counter.", "destination").Add(3, "example.com")
+	r.NewGauge("test_percent", "A gauge.", "hop").Set(50, "2")
+	h := r.NewHistogram("test_seconds", "A histogram.", []float64{0.1, 1}, "destination")
+	h.Observe(0.05, "example.com")
+	h.Observe(0.5, "example.com")
+	h.Observe(5, "example.com")
+	// families without series are not exported.
+	r.NewCounter("test_empty_total", "Never incremented.")
+	return r
+}
+
+// metricsByName flattens the request so the tests do not depend on the order of the families.
+func metricsByName(t *testing.T, req *colmetricpb.ExportMetricsServiceRequest) map[string]*metricpb.Metric {
+	t.Helper()
+	if len(req.GetResourceMetrics()) != 1 || len(req.GetResourceMetrics()[0].GetScopeMetrics()) != 1 {
+		t.Fatalf("export request has unexpected layout: %v", req)
+	}
+	got := map[string]*metricpb.Metric{}
+	for _, m := range req.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics() {
+		got[m.GetName()] = m
+	}
+	return got
+}
+
+func labels(kvs []*commonpb.KeyValue) map[string]string {
+	got := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		got[kv.GetKey()] = kv.GetValue().GetStringValue()
+	}
+	return got
+}
+
+func TestOTLPExporterRequest(t *testing.T) {
+	e := newOTLPExporter(testRegistry(), nil, map[string]string{"service.name": "host/traceroute"}, map[string]string{"source": "host"})
+	req := e.request(e.start.Add(time.Minute))
+
+	resource := req.GetResourceMetrics()[0].GetResource().GetAttributes()
+	if len(resource) != 1 || resource[0].GetKey() != "service.name" || resource[0].GetValue().GetStringValue() != "host/traceroute" {
+		t.Errorf("resource attributes = %v", resource)
+	}
+
+	got := metricsByName(t, req)
+	if len(got) != 3 {
+		t.Fatalf("exported %d metrics, want 3", len(got))
+	}
+
+	sum := got["test_total"].GetSum()
+	if sum == nil || !sum.GetIsMonotonic() ||
+		sum.GetAggregationTemporality() != metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		t.Fatalf("test_total is not a cumulative monotonic sum: %v", got["test_total"])
+	}
+	point := sum.GetDataPoints()[0]
+	if point.GetAsDouble() != 3 {
+		t.Errorf("test_total value = %v, want 3", point.GetAsDouble())
+	}
+	if want := map[string]string{"destination": "example.com", "source": "host"}; !reflect.DeepEqual(labels(point.GetAttributes()), want) {
+		t.Errorf("test_total attributes = %v, want %v", labels(point.GetAttributes()), want)
+	}
+	if point.GetTimeUnixNano()-point.GetStartTimeUnixNano() != uint64(time.Minute) {
+		t.Errorf("test_total start and time are %d apart, want %d", point.GetTimeUnixNano()-point.GetStartTimeUnixNano(), time.Minute)
+	}
+
+	if gauge := got["test_percent"].GetGauge(); gauge == nil || gauge.GetDataPoints()[0].GetAsDouble() != 50 {
+		t.Errorf("test_percent = %v, want gauge of 50", got["test_percent"])
+	}
+	if unit := got["test_percent"].GetUnit(); unit != "%" {
+		t.Errorf("test_percent unit = %q, want %%", unit)
+	}
+
+	hist := got["test_seconds"].GetHistogram()
+	if hist == nil {
+		t.Fatalf("test_seconds is not a histogram: %v", got["test_seconds"])
+	}
+	hp := hist.GetDataPoints()[0]
+	if hp.GetCount() != 3 || hp.GetSum() != 5.55 {
+		t.Errorf("test_seconds count = %d sum = %v, want 3 and 5.55", hp.GetCount(), hp.GetSum())
+	}
+	if want := []uint64{1, 1, 1}; !reflect.DeepEqual(hp.GetBucketCounts(), want) {
+		t.Errorf("test_seconds buckets = %v, want %v", hp.GetBucketCounts(), want)
+	}
+	if want := []float64{0.1, 1}; !reflect.DeepEqual(hp.GetExplicitBounds(), want) {
+		t.Errorf("test_seconds bounds = %v, want %v", hp.GetExplicitBounds(), want)
+	}
+	if unit := got["test_seconds"].GetUnit(); unit != "s" {
+		t.Errorf("test_seconds unit = %q, want s", unit)
+	}
+}
+
+func TestOTLPGRPCExporter(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &metricsService{requests: make(chan *colmetricpb.ExportMetricsServiceRequest, 1)}
+	srv := grpc.NewServer()
+	colmetricpb.RegisterMetricsServiceServer(srv, svc)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	exporter := NewOTLPGRPCExporter(testRegistry(), conn, nil, nil)
+	err = exporter.Export(ctx)
+	if err != nil {
+		t.Fatalf("OTLPExporter.Export() error = %v", err)
+	}
+	if got := metricsByName(t, <-svc.requests); len(got) != 3 {
+		t.Errorf("collector received %d metrics, want 3", len(got))
+	}
+	err = exporter.Close()
+	if err != nil || conn.GetState() != connectivity.Shutdown {
+		t.Errorf("OTLPExporter.Close() error = %v, connection %s, want it shut down", err, conn.GetState())
+	}
+}
+
+func TestOTLPHTTPExporter(t *testing.T) {
+	requests := make(chan *colmetricpb.ExportMetricsServiceRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != OTLPMetricsPath || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req := &colmetricpb.ExportMetricsServiceRequest{}
+		err = proto.Unmarshal(body, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests <- req
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	err := NewOTLPHTTPExporter(testRegistry(), srv.URL+OTLPMetricsPath, srv.Client(), nil, nil).Export(ctx)
+	if err != nil {
+		t.Fatalf("OTLPExporter.Export() error = %v", err)
+	}
+	if got := metricsByName(t, <-requests); len(got) != 3 {
+		t.Errorf("collector received %d metrics, want 3", len(got))
+	}
+
+	// the collector rejecting the request is an error.
+	err = NewOTLPHTTPExporter(testRegistry(), srv.URL+"/wrong", srv.Client(), nil, nil).Export(ctx)
+	if err == nil {
+		t.Errorf("OTLPExporter.Export() to the wrong path did not return an error")
+	}
+}
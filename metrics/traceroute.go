@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+const (
+	// results of a traceroute counted by traceroute_traces_total.
+	ResultReached     string = "reached"
+	ResultUnreachable string = "unreachable"
+	ResultIncomplete  string = "incomplete"
+	ResultError       string = "error"
+
+	// causes of the errors counted by traceroute_errors_total.
+	CauseDNS        string = "dns"
+	CausePermission string = "permission"
+	CauseTimeout    string = "timeout"
+	CauseSocket     string = "socket"
+	CauseOther      string = "other"
+)
+
+var (
+	rttBuckets      = ExponentialBuckets(0.0005, 2, 14)
+	durationBuckets = ExponentialBuckets(0.1, 2, 11)
+	hopCountBuckets = LinearBuckets(1, 2, 16)
+	lagBuckets      = ExponentialBuckets(0.01, 2, 12)
+)
+
+// Traceroute records the metrics of the traceroute results.
+type Traceroute struct {
+	traces      *Counter
+	errors      *Counter
+	duration    *Histogram
+	rtt         *Histogram
+	hopRTT      *Histogram
+	hopCount    *Histogram
+	hopLoss     *Gauge
+	pathChanges *Counter
+	lag         *Histogram
+	skipped     *Counter
+}
+
+// NewTraceroute registers the traceroute metrics with the registry.
+func NewTraceroute(r *Registry) *Traceroute {
+	return &Traceroute{
+		traces: r.NewCounter("traceroute_traces_total",
+			"Number of traceroutes by result.", "destination", "ip", "protocol", "result"),
+		errors: r.NewCounter("traceroute_errors_total",
+			"Number of traceroutes that failed by cause.", "destination", "protocol", "cause"),
+		duration: r.NewHistogram("traceroute_duration_seconds",
+			"Time taken by the traceroute.", durationBuckets, "destination", "ip", "protocol"),
+		rtt: r.NewHistogram("traceroute_rtt_seconds",
+			"Round trip time of the probes answered by the destination.", rttBuckets, "destination", "ip", "protocol"),
+		hopRTT: r.NewHistogram("traceroute_hop_rtt_seconds",
+			"Round trip time of the probes by hop.", rttBuckets, "destination", "ip", "protocol", "hop"),
+		hopCount: r.NewHistogram("traceroute_hop_count",
+			"Number of hops to the destination or the last hop probed.", hopCountBuckets, "destination", "ip", "protocol"),
+		hopLoss: r.NewGauge("traceroute_hop_loss_percent",
+			"Percentage of the probes without a reply by hop in the last traceroute.", "destination", "ip", "protocol", "hop"),
+		pathChanges: r.NewCounter("traceroute_path_changes_total",
+			"Number of times the path to the destination changed between traceroutes.", "destination", "ip", "protocol"),
+		lag: r.NewHistogram("traceroute_schedule_lag_seconds",
+			"Time between when a traceroute was due and when it started.", lagBuckets, "destination", "protocol"),
+		skipped: r.NewCounter("traceroute_schedule_skipped_total",
+			"Number of traceroutes not started because the previous traceroute had not finished.", "destination", "protocol"),
+	}
+}
+
+// Observe records a finished traceroute.
+func (t *Traceroute) Observe(res *methods.TraceResult) {
+	ip := res.IP.String()
+	t.traces.Inc(res.Destination, ip, res.Protocol, Result(res))
+	t.duration.Observe(res.Duration().Seconds(), res.Destination, ip, res.Protocol)
+	t.hopCount.Observe(float64(len(res.Hops)), res.Destination, ip, res.Protocol)
+	hops := make(map[string]bool, len(res.Hops))
+	for _, hop := range res.Hops {
+		ttl := strconv.Itoa(int(hop.TTL))
+		for _, probe := range hop.Probes {
+			if !probe.Success {
+				continue
+			}
+			t.hopRTT.Observe(probe.RTT.Seconds(), res.Destination, ip, res.Protocol, ttl)
+			if methods.AddrIP(probe.Address).Equal(res.IP) {
+				t.rtt.Observe(probe.RTT.Seconds(), res.Destination, ip, res.Protocol)
+			}
+		}
+		if hop.Stats.Sent > 0 {
+			hops[ttl] = true
+			t.hopLoss.Set(hop.Stats.Loss, res.Destination, ip, res.Protocol, ttl)
+		}
+	}
+	// the loss is of the last traceroute, the hops it did not probe are removed when the path got shorter.
+	t.hopLoss.DeleteFunc(func(labelValues []string) bool {
+		return labelValues[0] == res.Destination && labelValues[1] == ip && labelValues[2] == res.Protocol &&
+			!hops[labelValues[3]]
+	})
+}
+
+// ObserveError records a traceroute that failed before it returned a result.
+func (t *Traceroute) ObserveError(destination, protocol string, err error) {
+	t.errors.Inc(destination, protocol, Cause(err))
+}
+
+// PathChanged records a change of the path to the destination.
+func (t *Traceroute) PathChanged(change *methods.PathChange) {
+	t.pathChanges.Inc(change.Destination, change.IP.String(), change.Protocol)
+}
+
+// ScheduleLag records how late a traceroute started.
+func (t *Traceroute) ScheduleLag(destination, protocol string, lag time.Duration) {
+	t.lag.Observe(lag.Seconds(), destination, protocol)
+}
+
+// Skipped records a traceroute that was not started.
+func (t *Traceroute) Skipped(destination, protocol string) {
+	t.skipped.Inc(destination, protocol)
+}
+
+// Result returns how the traceroute ended.
+func Result(res *methods.TraceResult) string {
+	switch {
+	case res.Error != "":
+		return ResultError
+	case res.ReachedDestination:
+		return ResultReached
+	}
+	if len(res.Hops) > 0 {
+		for _, probe := range res.Hops[len(res.Hops)-1].Probes {
+			if probe.Fatal() {
+				return ResultUnreachable
+			}
+		}
+	}
+	return ResultIncomplete
+}
+
+// Cause classifies the error that stopped a traceroute.
+func Cause(err error) string {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &dnsErr):
+		return CauseDNS
+	case errors.Is(err, os.ErrPermission), errors.Is(err, syscall.EPERM):
+		return CausePermission
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return CauseTimeout
+	case errors.As(err, &opErr):
+		return CauseSocket
+	}
+	return CauseOther
+}
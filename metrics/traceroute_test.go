@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+func TestTracerouteObserve(t *testing.T) {
+	rtt := 10 * time.Millisecond
+	destIP := net.ParseIP("192.0.2.1")
+	res := methods.NewTraceResult("example.com", destIP, "udp", 33434)
+	res.Finish(map[uint16][]methods.TracerouteHop{
+		1: {
+			{Success: true, Address: &net.IPAddr{IP: net.ParseIP("10.0.0.1")}, TTL: 1, RTT: &rtt},
+			{Success: false, TTL: 1},
+		},
+		2: {
+			{Success: true, Address: &net.IPAddr{IP: destIP}, TTL: 2, RTT: &rtt},
+			{Success: true, Address: &net.IPAddr{IP: destIP}, TTL: 2, RTT: &rtt},
+		},
+	}, 30, nil)
+
+	r := NewRegistry()
+	tr := NewTraceroute(r)
+	tr.Observe(res)
+	tr.PathChanged(&methods.PathChange{Destination: res.Destination, IP: res.IP, Protocol: res.Protocol})
+	tr.ObserveError("example.com", "udp", &net.DNSError{Err: "no such host", IsNotFound: true})
+	tr.ScheduleLag("example.com", "udp", 50*time.Millisecond)
+	tr.Skipped("example.com", "udp")
+
+	got := map[string]Series{}
+	for _, fam := range r.Snapshot() {
+		for _, s := range fam.Series {
+			key := fam.Name
+			for _, l := range s.Labels {
+				key += fmt.Sprintf(",%s=%s", l.Name, l.Value)
+			}
+			got[key] = s
+		}
+	}
+	labels := ",destination=example.com,ip=192.0.2.1,protocol=udp"
+	tests := []struct {
+		key   string
+		value float64
+		count uint64
+	}{
+		{key: "traceroute_traces_total" + labels + ",result=reached", value: 1},
+		{key: "traceroute_hop_loss_percent" + labels + ",hop=1", value: 50},
+		{key: "traceroute_hop_loss_percent" + labels + ",hop=2", value: 0},
+		{key: "traceroute_hop_rtt_seconds" + labels + ",hop=1", count: 1},
+		{key: "traceroute_rtt_seconds" + labels, count: 2},
+		{key: "traceroute_hop_count" + labels, count: 1},
+		{key: "traceroute_duration_seconds" + labels, count: 1},
+		{key: "traceroute_path_changes_total" + labels, value: 1},
+		{key: "traceroute_errors_total,destination=example.com,protocol=udp,cause=dns", value: 1},
+		{key: "traceroute_schedule_lag_seconds,destination=example.com,protocol=udp", value: 0, count: 1},
+		{key: "traceroute_schedule_skipped_total,destination=example.com,protocol=udp", value: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			s, ok := got[tt.key]
+			if !ok {
+				t.Fatalf("Traceroute.Observe() missing series %s", tt.key)
+			}
+			if s.Value != tt.value || s.Count != tt.count {
+				t.Errorf("Traceroute.Observe() %s = %v/%d, want %v/%d", tt.key, s.Value, s.Count, tt.value, tt.count)
+			}
+		})
+	}
+}
+
+func TestTracerouteObserveHopLoss(t *testing.T) {
+	rtt := 10 * time.Millisecond
+	result := func(destination string, hops int) *methods.TraceResult {
+		destIP := net.ParseIP("192.0.2.1")
+		res := methods.NewTraceResult(destination, destIP, "udp", 33434)
+		probes := map[uint16][]methods.TracerouteHop{}
+		for ttl := uint16(1); ttl <= uint16(hops); ttl++ {
+			addr := net.IPv4(10, 0, byte(ttl), 1)
+			if int(ttl) == hops {
+				addr = destIP
+			}
+			probes[ttl] = []methods.TracerouteHop{{Success: true, Address: &net.IPAddr{IP: addr}, TTL: ttl, RTT: &rtt}}
+		}
+		res.Finish(probes, 30, nil)
+		return res
+	}
+
+	r := NewRegistry()
+	tr := NewTraceroute(r)
+	tr.Observe(result("example.com", 3))
+	tr.Observe(result("example.net", 3))
+	// the path to example.com got shorter, the loss of its third hop is no longer reported.
+	tr.Observe(result("example.com", 2))
+
+	got := []string{}
+	for _, fam := range r.Snapshot() {
+		if fam.Name != "traceroute_hop_loss_percent" {
+			continue
+		}
+		for _, s := range fam.Series {
+			got = append(got, s.Labels[0].Value+" "+s.Labels[3].Value)
+		}
+	}
+	want := []string{"example.com 1", "example.com 2", "example.net 1", "example.net 2", "example.net 3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Traceroute.Observe() hop loss series = %v, want %v", got, want)
+	}
+}
+
+func TestCause(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "dns", err: fmt.Errorf("lookup: %w", &net.DNSError{Err: "no such host"}), want: CauseDNS},
+		{name: "permission", err: &os.SyscallError{Syscall: "socket", Err: os.ErrPermission}, want: CausePermission},
+		{name: "timeout", err: context.DeadlineExceeded, want: CauseTimeout},
+		{name: "socket", err: &net.OpError{Op: "write", Err: errors.New("network is unreachable")}, want: CauseSocket},
+		{name: "other", err: errors.New("boom"), want: CauseOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Cause(tt.err); got != tt.want {
+				t.Errorf("Cause() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResult(t *testing.T) {
+	rtt := time.Millisecond
+	unreachable := methods.NewTraceResult("example.com", net.ParseIP("192.0.2.1"), "udp", 33434)
+	unreachable.Finish(map[uint16][]methods.TracerouteHop{
+		1: {{Success: true, Address: &net.IPAddr{IP: net.ParseIP("10.0.0.1")}, TTL: 1, RTT: &rtt, ICMP: &methods.ICMPReply{Type: 3, Code: 1}}},
+	}, 30, nil)
+	incomplete := methods.NewTraceResult("example.com", net.ParseIP("192.0.2.1"), "udp", 33434)
+	incomplete.Finish(map[uint16][]methods.TracerouteHop{1: {{Success: false, TTL: 1}}}, 30, nil)
+	failed := methods.NewTraceResult("example.com", net.ParseIP("192.0.2.1"), "udp", 33434)
+	failed.Finish(nil, 30, errors.New("socket closed"))
+
+	for res, want := range map[*methods.TraceResult]string{unreachable: ResultUnreachable, incomplete: ResultIncomplete, failed: ResultError} {
+		if got := Result(res); got != want {
+			t.Errorf("Result() = %v, want %v", got, want)
+		}
+	}
+}
@@ -25,18 +25,18 @@ func New(count int) *ParallelLimiter {
 
 func (p *ParallelLimiter) Start() chan struct{} {
 	p.mu.Lock()
+	// the channels are buffered so Finished does not block when the caller stopped waiting after its
+	// context was cancelled.
 	if p.currentRunning+1 > p.maxCount {
-		waitChan := make(chan struct{})
+		waitChan := make(chan struct{}, 1)
 		p.waiting = append(p.waiting, waitChan)
 		p.mu.Unlock()
 		return waitChan
 	}
 	p.currentRunning++
 	p.mu.Unlock()
-	instantResolveChan := make(chan struct{})
-	go func() {
-		instantResolveChan <- struct{}{}
-	}()
+	instantResolveChan := make(chan struct{}, 1)
+	instantResolveChan <- struct{}{}
 	return instantResolveChan
 }
 
@@ -0,0 +1,107 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+)
+
+// Budget limits the number of probes in flight across all of the traceroutes, a traceroute takes as many
+// probes as it can send in parallel from the budget before it starts and returns them when it finishes.
+type Budget struct {
+	mutex sync.Mutex
+	size  int
+	used  int
+	// waiting is served in order so a traceroute with a large weight is not starved by smaller ones.
+	waiting []*waiter
+}
+
+type waiter struct {
+	n     int
+	ready chan struct{}
+}
+
+// NewBudget returns a budget of size probes.
+func NewBudget(size int) *Budget {
+	return &Budget{size: size}
+}
+
+// Acquire blocks until n probes are available or the context is done, n is limited to the size of the
+// budget so a traceroute that sends more probes in parallel than the budget still runs on its own.
+func (b *Budget) Acquire(ctx context.Context, n int) error {
+	n = b.limit(n)
+	b.mutex.Lock()
+	if len(b.waiting) == 0 && b.used+n <= b.size {
+		b.used += n
+		b.mutex.Unlock()
+		return nil
+	}
+	w := &waiter{n: n, ready: make(chan struct{})}
+	b.waiting = append(b.waiting, w)
+	b.mutex.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		select {
+		case <-w.ready:
+			// the probes were handed over while the context was done.
+			b.used -= n
+			b.notify()
+		default:
+			b.remove(w)
+		}
+		return ctx.Err()
+	}
+}
+
+// Release returns n probes to the budget.
+func (b *Budget) Release(n int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.used -= b.limit(n)
+	b.notify()
+}
+
+// InUse returns the number of probes taken from the budget.
+func (b *Budget) InUse() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.used
+}
+
+func (b *Budget) limit(n int) int {
+	if n > b.size {
+		return b.size
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// notify hands the probes to the waiters in order while they fit in the budget.
+func (b *Budget) notify() {
+	for len(b.waiting) > 0 {
+		w := b.waiting[0]
+		if b.used+w.n > b.size {
+			return
+		}
+		b.used += w.n
+		b.waiting = b.waiting[1:]
+		close(w.ready)
+	}
+}
+
+func (b *Budget) remove(w *waiter) {
+	for i := range b.waiting {
+		if b.waiting[i] == w {
+			b.waiting = append(b.waiting[:i], b.waiting[i+1:]...)
+			break
+		}
+	}
+	// the waiters behind a removed large waiter may fit now.
+	b.notify()
+}
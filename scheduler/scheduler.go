@@ -0,0 +1,150 @@
+// Package scheduler runs the traceroutes of the service concurrently, each on its own interval.
+package scheduler
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Job is run every Interval.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// Weight is the number of probes the job has in flight at most, it is taken from the budget.
+	Weight int
+	Run    func(ctx context.Context)
+}
+
+// Scheduler starts the jobs concurrently within the probe budget, the first run of each job is at a random
+// point of its interval so the jobs do not all start together and every run is delayed by a random jitter.
+type Scheduler struct {
+	budget *Budget
+	// jitter is the largest delay of a run as a fraction of the interval of the job.
+	jitter float64
+	// Lag is called with the time between when a run was due and when it started, a run waits for the
+	// probes of the budget so the lag grows when the budget is too small for the jobs.
+	Lag func(job *Job, lag time.Duration)
+	// Skipped is called when a run is not started because the previous run of the job has not finished.
+	Skipped func(job *Job)
+
+	mutex sync.Mutex
+	// ctx is the context of Run, the jobs are only scheduled while it is running.
+	ctx context.Context
+	wg  sync.WaitGroup
+	// cancels stops scheduling each job and cancels its run in flight.
+	cancels map[*Job]context.CancelFunc
+}
+
+// New returns a Scheduler with a budget of probes in flight and the jitter of the runs.
+func New(budget int, jitter float64) *Scheduler {
+	return &Scheduler{
+		budget:  NewBudget(budget),
+		jitter:  jitter,
+		Lag:     func(*Job, time.Duration) {},
+		Skipped: func(*Job) {},
+		cancels: map[*Job]context.CancelFunc{},
+	}
+}
+
+// Run runs the jobs until the context is done, the runs in flight are then cancelled through the context
+// passed to them and Run returns when they have finished.
+func (s *Scheduler) Run(ctx context.Context, jobs []*Job) {
+	s.mutex.Lock()
+	s.ctx = ctx
+	s.mutex.Unlock()
+	for _, job := range jobs {
+		s.Add(job)
+	}
+	<-ctx.Done()
+	// an Add in progress has added to the wait group once the lock is taken, later calls see the context is done.
+	s.mutex.Lock()
+	s.mutex.Unlock() //nolint:staticcheck // the lock only waits for Add to finish.
+	s.wg.Wait()
+}
+
+// Add schedules the job while Run is running, the job is not scheduled before Run or after it returns.
+func (s *Scheduler) Add(job *Job) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ctx == nil || s.ctx.Err() != nil {
+		return
+	}
+	if _, ok := s.cancels[job]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.cancels[job] = cancel
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.schedule(ctx, job)
+	}()
+}
+
+// Remove stops scheduling the job and cancels its run in flight.
+func (s *Scheduler) Remove(job *Job) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	cancel, ok := s.cancels[job]
+	if !ok {
+		return
+	}
+	cancel()
+	delete(s.cancels, job)
+}
+
+// schedule runs the job every interval from a random start, a run is skipped when the previous run is still
+// in flight. The schedule does not drift, the jitter only delays each run from its place in the schedule.
+func (s *Scheduler) schedule(ctx context.Context, job *Job) {
+	var running atomic.Bool
+	next := time.Now().Add(random(job.Interval))
+	for {
+		due := next.Add(random(time.Duration(s.jitter * float64(job.Interval))))
+		timer := time.NewTimer(time.Until(due))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if running.CompareAndSwap(false, true) {
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer running.Store(false)
+				s.run(ctx, job, due)
+			}()
+		} else {
+			s.Skipped(job)
+		}
+
+		// the runs that were missed while the process was suspended are not caught up.
+		next = next.Add(job.Interval)
+		for !next.After(time.Now()) {
+			next = next.Add(job.Interval)
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, job *Job, due time.Time) {
+	err := s.budget.Acquire(ctx, job.Weight)
+	if err != nil {
+		return
+	}
+	defer s.budget.Release(job.Weight)
+	s.Lag(job, time.Since(due))
+	job.Run(ctx)
+}
+
+// random returns a random duration from 0 up to d.
+func random(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	//nolint:gosec // not cryptographic, only spreads the runs.
+	return time.Duration(rand.Int63n(int64(d)))
+}
@@ -0,0 +1,189 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBudget(t *testing.T) {
+	b := NewBudget(4)
+	ctx := context.Background()
+	if err := b.Acquire(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// a waiter that does not fit blocks the ones behind it.
+	large := make(chan struct{})
+	go func() {
+		_ = b.Acquire(ctx, 2)
+		close(large)
+	}()
+	for !b.waitingFor(1) {
+		time.Sleep(time.Millisecond)
+	}
+	small, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := b.Acquire(small, 1); err == nil {
+		t.Fatalf("Budget.Acquire() overtook a waiter")
+	}
+
+	b.Release(3)
+	<-large
+	if got := b.InUse(); got != 2 {
+		t.Errorf("Budget.InUse() = %d, want 2", got)
+	}
+
+	// a weight larger than the budget takes the whole budget.
+	b.Release(2)
+	if err := b.Acquire(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.InUse(); got != 4 {
+		t.Errorf("Budget.InUse() = %d, want 4", got)
+	}
+	b.Release(10)
+	if got := b.InUse(); got != 0 {
+		t.Errorf("Budget.InUse() = %d, want 0", got)
+	}
+}
+
+func (b *Budget) waitingFor(n int) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.waiting) == n
+}
+
+func TestSchedulerBudget(t *testing.T) {
+	s := New(4, 0.5)
+	var inflight, peak atomic.Int32
+	var runs sync.Map
+	jobs := make([]*Job, 0, 6)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		name := name
+		jobs = append(jobs, &Job{
+			Name:     name,
+			Interval: 20 * time.Millisecond,
+			Weight:   2,
+			Run: func(ctx context.Context) {
+				n := inflight.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				inflight.Add(-1)
+				count, _ := runs.LoadOrStore(name, new(atomic.Int32))
+				count.(*atomic.Int32).Add(1)
+			},
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	s.Run(ctx, jobs)
+
+	if got := peak.Load(); got > 2 {
+		t.Errorf("%d jobs ran together with a budget for 2", got)
+	}
+	for _, job := range jobs {
+		count, ok := runs.Load(job.Name)
+		if !ok || count.(*atomic.Int32).Load() < 2 {
+			t.Errorf("job %s did not run on its interval", job.Name)
+		}
+	}
+}
+
+func TestSchedulerSkipped(t *testing.T) {
+	s := New(16, 0)
+	var lags, skipped atomic.Int32
+	s.Lag = func(*Job, time.Duration) { lags.Add(1) }
+	s.Skipped = func(*Job) { skipped.Add(1) }
+
+	var slow, fast atomic.Int32
+	jobs := []*Job{
+		{
+			Name:     "slow",
+			Interval: 10 * time.Millisecond,
+			Run: func(ctx context.Context) {
+				slow.Add(1)
+				<-ctx.Done()
+			},
+		},
+		{
+			Name:     "fast",
+			Interval: 10 * time.Millisecond,
+			Run:      func(context.Context) { fast.Add(1) },
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	s.Run(ctx, jobs)
+
+	if got := slow.Load(); got != 1 {
+		t.Errorf("the job in flight ran %d times, want 1", got)
+	}
+	if skipped.Load() == 0 {
+		t.Errorf("the runs of the job in flight were not reported as skipped")
+	}
+	// the slow job does not hold up the other job.
+	if got := fast.Load(); got < 5 {
+		t.Errorf("the fast job ran %d times, want at least 5", got)
+	}
+	if got := lags.Load(); got != slow.Load()+fast.Load() {
+		t.Errorf("lag was reported %d times for %d runs", got, slow.Load()+fast.Load())
+	}
+}
+
+func TestSchedulerAddRemove(t *testing.T) {
+	s := New(16, 0)
+	var first, second atomic.Int32
+	removed := &Job{
+		Name:     "removed",
+		Interval: 10 * time.Millisecond,
+		Run: func(ctx context.Context) {
+			first.Add(1)
+			// the run in flight is cancelled when the job is removed.
+			<-ctx.Done()
+		},
+	}
+	added := &Job{
+		Name:     "added",
+		Interval: 10 * time.Millisecond,
+		Run:      func(context.Context) { second.Add(1) },
+	}
+
+	// jobs are not scheduled before Run.
+	s.Add(added)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx, []*Job{removed})
+		close(done)
+	}()
+	for first.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if got := second.Load(); got != 0 {
+		t.Fatalf("a job added before Run ran %d times", got)
+	}
+
+	s.Remove(removed)
+	s.Add(added)
+	time.Sleep(60 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Run did not return after the context was done")
+	}
+	if got := first.Load(); got != 1 {
+		t.Errorf("the removed job ran %d times, want 1", got)
+	}
+	if got := second.Load(); got < 3 {
+		t.Errorf("the added job ran %d times, want at least 3", got)
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/config"
+	"github.com/jimmystewpot/traceroute/scheduler"
+	"github.com/jimmystewpot/traceroute/trace"
+	"go.uber.org/zap"
+)
+
+// handleSignals stops the service on SIGINT or SIGTERM and reloads the destinations on SIGHUP.
+func (svc *Service) handleSignals(signals <-chan os.Signal) {
+	for {
+		select {
+		case <-svc.close:
+			return
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				logger.Info("reloading the configuration",
+					zap.String("signal", sig.String()),
+				)
+				svc.Reload()
+				continue
+			}
+			logger.Warn("stopping",
+				zap.String("signal", sig.String()),
+			)
+			// a second signal kills the service when it does not stop in time.
+			signal.Reset(syscall.SIGINT, syscall.SIGTERM)
+			svc.Stop()
+			return
+		}
+	}
+}
+
+// watch reloads the destinations when the modification time or the size of the configuration file changes.
+func (svc *Service) watch(interval time.Duration) {
+	var modified time.Time
+	var size int64
+	info, err := os.Stat(svc.ConfigFile)
+	if err == nil {
+		modified, size = info.ModTime(), info.Size()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-svc.close:
+			return
+		case <-ticker.C:
+		}
+		info, err = os.Stat(svc.ConfigFile)
+		if err != nil {
+			logger.Warn("unable to watch the configuration file",
+				zap.String("file", svc.ConfigFile),
+				zap.Error(err),
+			)
+			continue
+		}
+		if info.ModTime().Equal(modified) && info.Size() == size {
+			continue
+		}
+		modified, size = info.ModTime(), info.Size()
+		logger.Info("reloading the configuration",
+			zap.String("file", svc.ConfigFile),
+		)
+		svc.Reload()
+	}
+}
+
+// reloadConfig loads the configuration file again, the destinations that were removed or changed stop being
+// traced and the ones that were added or changed are scheduled. The other sections require a restart.
+//
+//nolint:gocritic // the CLI is copied for each destination
+func (svc *Service) reloadConfig(sched *scheduler.Scheduler, hc *HealthCheck, global trace.CLI) {
+	cfg, err := config.LoadConfigFromFile(svc.ConfigFile)
+	if err == nil && len(cfg.TraceConfigDestinations) == 0 {
+		err = fmt.Errorf("no destinations to trace")
+	}
+	if err != nil {
+		logger.Warn("configuration failed to reload, the destinations are unchanged",
+			zap.String("file", svc.ConfigFile),
+			zap.Error(err),
+		)
+		return
+	}
+
+	added, removed := svc.diff(cfg.TraceConfigDestinations)
+	for _, job := range removed {
+		sched.Remove(job)
+		d, _ := svc.destination(job)
+		svc.jobsMutex.Lock()
+		delete(svc.jobs, job)
+		svc.jobsMutex.Unlock()
+		logger.Info("destination removed",
+			zap.String("destination", d.Destination),
+			zap.String("protocol", d.Protocol),
+		)
+	}
+	for _, d := range added {
+		sched.Add(svc.job(hc, global, d))
+		logger.Info("destination added",
+			zap.String("destination", d.Destination),
+			zap.String("protocol", d.Protocol),
+			zap.Duration("interval", d.Interval),
+		)
+	}
+
+	previous, current := svc.Config, *cfg
+	previous.TraceConfigDestinations, current.TraceConfigDestinations = nil, nil
+	if !reflect.DeepEqual(previous, current) {
+		logger.Warn("only the destinations are reloaded, restart the service to apply the other changes",
+			zap.String("file", svc.ConfigFile),
+		)
+	}
+	svc.Config.TraceConfigDestinations = cfg.TraceConfigDestinations
+	logger.Info("configuration reloaded",
+		zap.Int("added", len(added)),
+		zap.Int("removed", len(removed)),
+		zap.Int("destinations", len(cfg.TraceConfigDestinations)),
+	)
+}
+
+// diff returns the destinations that are not scheduled with the same settings and the jobs whose destination
+// is no longer configured with the same settings.
+func (svc *Service) diff(destinations []config.TraceConfigDestination) ([]config.TraceConfigDestination, []*scheduler.Job) {
+	svc.jobsMutex.Lock()
+	defer svc.jobsMutex.Unlock()
+	kept := make(map[*scheduler.Job]bool, len(svc.jobs))
+	var added []config.TraceConfigDestination
+	for _, d := range destinations {
+		found := false
+		for job, scheduled := range svc.jobs {
+			if !kept[job] && scheduled.Equal(d) {
+				kept[job] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			added = append(added, d)
+		}
+	}
+	var removed []*scheduler.Job
+	for job := range svc.jobs {
+		if !kept[job] {
+			removed = append(removed, job)
+		}
+	}
+	return added, removed
+}
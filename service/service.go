@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,12 +14,22 @@ import (
 	"time"
 
 	"github.com/jimmystewpot/traceroute/config"
+	"github.com/jimmystewpot/traceroute/enrich"
+	"github.com/jimmystewpot/traceroute/history"
+	"github.com/jimmystewpot/traceroute/methods"
+	"github.com/jimmystewpot/traceroute/metrics"
+	"github.com/jimmystewpot/traceroute/scheduler"
+	"github.com/jimmystewpot/traceroute/sink"
 	"github.com/jimmystewpot/traceroute/trace"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 const (
 	ServiceName string = "opentelemetry-traceroute"
+
+	// shutdownTimeout is how long the traceroutes in flight are given to finish when the service stops.
+	shutdownTimeout time.Duration = 30 * time.Second
 )
 
 var (
@@ -29,7 +40,20 @@ var (
 type Service struct {
 	Hostname string
 	Config   config.TraceConfig
-	close    chan struct{}
+	Registry *metrics.Registry
+	metrics  *metrics.Traceroute
+	// history holds the last results to each destination IP to detect path changes.
+	history *history.Store
+	// sinks receive every result in addition to the OpenTelemetry spans.
+	sinks *sink.Multi
+	// ConfigFile is loaded again to reload the destinations.
+	ConfigFile string
+	close      chan struct{}
+	closeOnce  sync.Once
+	reload     chan struct{}
+	// jobs are the scheduled destinations, they change when the configuration is reloaded.
+	jobsMutex sync.Mutex
+	jobs      map[*scheduler.Job]config.TraceConfigDestination
 }
 
 type HealthCheck struct {
@@ -40,6 +64,7 @@ type HealthCheck struct {
 	Details     HealthCheckDetails `json:"details"`
 	mutex       sync.Mutex         // for locking when writing stats
 	close       chan struct{}      // for closing down the healthcheck endpoint cleanly
+	metrics     http.Handler       // serves the prometheus metrics
 }
 
 type HealthCheckDetails struct {
@@ -49,8 +74,9 @@ type HealthCheckDetails struct {
 	DNSLatency         []time.Duration `json:"dns-latency"`
 }
 type CLI struct {
-	ConfigFile     string `help:"Load a YAML configuration file" env:"TRACE_CFGFILE" required:"ValidateConfig"`
-	ValidateConfig bool   `cmd:"" help:"Validate the configuration file format is correct" name:"validate"`
+	ConfigFile     string        `help:"Load a YAML configuration file" env:"TRACE_CFGFILE" required:"ValidateConfig"`
+	ValidateConfig bool          `cmd:"" help:"Validate the configuration file format is correct" name:"validate"`
+	Watch          time.Duration `help:"Reload the destinations when the configuration file changes, it is checked every interval" default:"0s" env:"TRACE_CFG_WATCH"`
 }
 
 func (cli *CLI) Run() error {
@@ -77,6 +103,17 @@ func (cli *CLI) Run() error {
 			zap.Error(err),
 		)
 	}
+	svc.ConfigFile = cli.ConfigFile
+
+	// SIGINT and SIGTERM stop the service, SIGHUP reloads the destinations.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go svc.handleSignals(signals)
+	if cli.Watch > 0 {
+		go svc.watch(cli.Watch)
+	}
+
 	err = svc.Start()
 	if err != nil {
 		return err
@@ -91,78 +128,392 @@ func New(cfg *config.TraceConfig) (*Service, error) {
 		return &Service{}, err
 	}
 
+	registry := metrics.NewRegistry()
 	return &Service{
 		Config:   *cfg,
 		Hostname: hostname,
+		Registry: registry,
+		metrics:  metrics.NewTraceroute(registry),
 		close:    make(chan struct{}),
+		reload:   make(chan struct{}, 1),
+		jobs:     map[*scheduler.Job]config.TraceConfigDestination{},
 	}, nil
 }
 
 func (svc *Service) Start() error {
 	svc.LogStart()
+	if len(svc.Config.TraceConfigDestinations) == 0 {
+		return fmt.Errorf("no destinations to trace")
+	}
 	hc := &HealthCheck{
 		Details: HealthCheckDetails{},
 		close:   make(chan struct{}),
+		metrics: svc.Registry,
 	}
 	go hc.RunHealthCheckSvc(svc.Config.TraceConfigHealthCheck)
+	// the deferred functions run in reverse, the health check stops after everything else is flushed.
+	defer hc.Shutdown()
+
+	// the enricher is shared by all traceroutes so the ASN table is loaded once and reverse names are cached.
+	enricher, err := svc.NewEnricher()
+	if err != nil {
+		return err
+	}
 
-	// set the interval at which the traceroutes are executed.
-	ticker := time.NewTicker(svc.Config.TraceConfigGlobal.Interval)
-	globalCfg := trace.CLI{
-		MaxHops:                  svc.Config.TraceConfigGlobal.MaxHops,
-		NQueries:                 svc.Config.TraceConfigGlobal.NQueries,
-		ParallelRequests:         svc.Config.TraceConfigGlobal.ParallelRequests,
-		Timeout:                  svc.Config.TraceConfigGlobal.Timeout,
-		TraceRoutePort:           svc.Config.TraceConfigGlobal.TraceRoutePort,
-		OpenTelemetryDestination: svc.Config.TraceConfigOtel.Destination,
-		OpenTelemetryTLS:         svc.Config.TraceConfigOtel.TLS,
-		OpenTelemetryGRPC:        svc.Config.TraceConfigOtel.GRPC,
-		OpenTelemetryPort:        svc.Config.TraceConfigOtel.Port,
-		Hostname:                 svc.Hostname,
+	svc.history, err = history.New(svc.Config.TraceConfigHistory.Size, svc.Config.TraceConfigHistory.Directory, svc.pathChanged)
+	if err != nil {
+		return err
 	}
+
+	svc.sinks, err = sink.New(svc.Config.TraceConfigSinks)
+	if err != nil {
+		return err
+	}
+	defer svc.sinks.Close()
+
+	globalCfg := svc.traceCLI(enricher)
+
+	exportMetrics, err := svc.startMetricsExporter(&globalCfg)
+	if err != nil {
+		return err
+	}
+	defer exportMetrics()
+
+	flushTraces, err := svc.startTracerProvider(&globalCfg)
+	if err != nil {
+		return err
+	}
+	defer flushTraces()
+
+	sched := svc.scheduler()
+	jobs := make([]*scheduler.Job, 0, len(svc.Config.TraceConfigDestinations))
+	for _, d := range svc.Config.TraceConfigDestinations {
+		jobs = append(jobs, svc.job(hc, globalCfg, d))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		sched.Run(ctx, jobs)
+		close(done)
+	}()
+
 	for {
 		select {
-		case <-ticker.C:
-			for i := 0; i < len(svc.Config.TraceConfigDestinations); i++ {
-				// copies the globalCfg to only update the variabels that change with
-				// each traceroute.
-				t := globalCfg
-				t.Destination = svc.Config.TraceConfigDestinations[i]
-				s := time.Now()
-				var err error
-				if svc.Config.TraceConfigGlobal.Protocol == "udp" {
-					err = t.UDP()
-				}
-				if svc.Config.TraceConfigGlobal.Protocol == "tcp" {
-					err = t.TCP()
-				}
-				if err != nil {
-					logger.Warn("error",
-						zap.String("destination", t.Destination),
-						zap.String("protocol", svc.Config.TraceConfigGlobal.Protocol),
-						zap.Error(err),
-					)
-					continue
-				}
-				logger.Info("tracing",
-					zap.String("service_name", ServiceName),
-					zap.String("destination", t.Destination),
-					zap.Duration("duration", time.Since(s)),
-				)
-			}
-
-			err := logger.Sync()
-			if err != nil {
-				logger.Warn("unable to flush logger",
-					zap.Error(err),
-				)
-			}
+		case <-svc.reload:
+			svc.reloadConfig(sched, hc, globalCfg)
 		case <-svc.close:
 			logger.Warn("svc.close",
 				zap.String("msg", "closing down gracefully"),
 			)
+			// stops scheduling and cancels the traceroutes in flight.
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(shutdownTimeout):
+				logger.Warn("traceroutes in flight did not finish",
+					zap.Duration("timeout", shutdownTimeout),
+				)
+			}
+			return nil
+		}
+	}
+}
+
+// Stop stops the service, Start returns once the traceroutes in flight are cancelled and the spans, metrics
+// and results are flushed.
+func (svc *Service) Stop() {
+	svc.closeOnce.Do(func() {
+		close(svc.close)
+	})
+}
+
+// Reload loads the destinations from the configuration file again.
+func (svc *Service) Reload() {
+	select {
+	case svc.reload <- struct{}{}:
+	default:
+		// a reload is already pending.
+	}
+}
+
+// startMetricsExporter pushes the metrics of the service registry every interval, the returned function exports
+// them a final time when the service stops.
+func (svc *Service) startMetricsExporter(global *trace.CLI) (func(), error) {
+	if !svc.Config.TraceConfigOtel.Metrics {
+		return func() {}, nil
+	}
+	exporter, err := global.NewMetricsExporter(svc.Registry)
+	if err != nil {
+		return func() {}, err
+	}
+	warn := func(err error) {
+		logger.Warn("unable to export metrics",
+			zap.String("destination", svc.Config.TraceConfigOtel.Destination),
+			zap.Error(err),
+		)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go exporter.Run(ctx, svc.Config.TraceConfigGlobal.Interval, warn)
+	return func() {
+		cancel()
+		exportCtx, exportCancel := context.WithTimeout(context.Background(), svc.Config.TraceConfigGlobal.Timeout)
+		defer exportCancel()
+		err := exporter.Export(exportCtx)
+		if err != nil {
+			warn(err)
+		}
+		err = exporter.Close()
+		if err != nil {
+			logger.Warn("unable to close the metrics exporter",
+				zap.String("destination", svc.Config.TraceConfigOtel.Destination),
+				zap.Error(err),
+			)
 		}
+	}, nil
+}
+
+// startTracerProvider sets the provider shared by the traceroutes, they run at the same time so they can not each
+// set the global provider. The returned function flushes the spans when the service stops.
+func (svc *Service) startTracerProvider(global *trace.CLI) (func(), error) {
+	tracerProvider, err := global.NewTracerProvider(svc.Config.TraceConfigGlobal.Timeout)
+	if err != nil {
+		return func() {}, err
 	}
+	global.TracerProvider = tracerProvider
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), svc.Config.TraceConfigGlobal.Timeout)
+		defer cancel()
+		err := tracerProvider.Shutdown(ctx)
+		if err != nil {
+			logger.Warn("unable to flush the traces",
+				zap.String("destination", svc.Config.TraceConfigOtel.Destination),
+				zap.Error(err),
+			)
+		}
+	}, nil
+}
+
+// scheduler returns the scheduler of the destinations, they run concurrently within the probe budget and
+// each traceroute takes as many probes from the budget as it sends in parallel.
+func (svc *Service) scheduler() *scheduler.Scheduler {
+	sched := scheduler.New(int(svc.Config.TraceConfigGlobal.ProbeBudget), svc.Config.TraceConfigGlobal.Jitter)
+	sched.Lag = func(job *scheduler.Job, lag time.Duration) {
+		d, ok := svc.destination(job)
+		if !ok {
+			return
+		}
+		svc.metrics.ScheduleLag(d.Destination, d.Protocol, lag)
+		// the jitter delays a run by less than the interval, waiting for the probe budget delays it further.
+		if lag > d.Interval {
+			logger.Warn("traceroute started late",
+				zap.String("destination", d.Destination),
+				zap.String("protocol", d.Protocol),
+				zap.Duration("lag", lag),
+				zap.Duration("interval", d.Interval),
+			)
+		}
+	}
+	sched.Skipped = func(job *scheduler.Job) {
+		d, ok := svc.destination(job)
+		if !ok {
+			return
+		}
+		svc.metrics.Skipped(d.Destination, d.Protocol)
+		logger.Warn("traceroute skipped, the previous traceroute has not finished",
+			zap.String("destination", d.Destination),
+			zap.String("protocol", d.Protocol),
+			zap.Duration("interval", d.Interval),
+		)
+	}
+	return sched
+}
+
+// job returns the job that traces the destination and keeps the destination for the scheduler callbacks.
+//
+//nolint:gocritic // the CLI is copied for each destination
+func (svc *Service) job(hc *HealthCheck, global trace.CLI, d config.TraceConfigDestination) *scheduler.Job {
+	t := destinationCLI(global, d)
+	job := &scheduler.Job{
+		Name:     d.Destination,
+		Interval: d.Interval,
+		Weight:   int(t.ParallelRequests),
+		Run: func(ctx context.Context) {
+			svc.run(ctx, hc, t, d.Protocol)
+		},
+	}
+	svc.jobsMutex.Lock()
+	defer svc.jobsMutex.Unlock()
+	svc.jobs[job] = d
+	return job
+}
+
+// destination returns the destination traced by the job, a job is forgotten when its destination is removed.
+func (svc *Service) destination(job *scheduler.Job) (config.TraceConfigDestination, bool) {
+	svc.jobsMutex.Lock()
+	defer svc.jobsMutex.Unlock()
+	d, ok := svc.jobs[job]
+	return d, ok
+}
+
+// traceCLI returns the traceroute settings shared by all of the destinations.
+func (svc *Service) traceCLI(enricher methods.Enricher) trace.CLI {
+	return trace.CLI{
+		MaxHops:                         svc.Config.TraceConfigGlobal.MaxHops,
+		NQueries:                        svc.Config.TraceConfigGlobal.NQueries,
+		ParallelRequests:                svc.Config.TraceConfigGlobal.ParallelRequests,
+		Timeout:                         svc.Config.TraceConfigGlobal.Timeout,
+		TraceRoutePort:                  svc.Config.TraceConfigGlobal.TraceRoutePort,
+		Family:                          svc.Config.TraceConfigGlobal.Family,
+		Paris:                           svc.Config.TraceConfigGlobal.Paris,
+		MDA:                             svc.Config.TraceConfigGlobal.Multipath,
+		MDAConfidence:                   svc.Config.TraceConfigGlobal.MultipathConfidence,
+		OpenTelemetryDestination:        svc.Config.TraceConfigOtel.Destination,
+		OpenTelemetryTLS:                svc.Config.TraceConfigOtel.TLS,
+		OpenTelemetryGRPC:               svc.Config.TraceConfigOtel.GRPC,
+		OpenTelemetryPort:               svc.Config.TraceConfigOtel.Port,
+		OpenTelemetryCAFile:             svc.Config.TraceConfigOtel.CAFile,
+		OpenTelemetryCertFile:           svc.Config.TraceConfigOtel.CertFile,
+		OpenTelemetryKeyFile:            svc.Config.TraceConfigOtel.KeyFile,
+		OpenTelemetryServerName:         svc.Config.TraceConfigOtel.ServerName,
+		OpenTelemetryInsecureSkipVerify: svc.Config.TraceConfigOtel.InsecureSkipVerify,
+		OpenTelemetryHeaders:            svc.Config.TraceConfigOtel.Headers,
+		OpenTelemetryCompression:        svc.Config.TraceConfigOtel.Compression,
+		OpenTelemetryTracesPath:         svc.Config.TraceConfigOtel.TracesPath,
+		OpenTelemetryMetricsPath:        svc.Config.TraceConfigOtel.MetricsPath,
+		SpanLayout:                      svc.Config.TraceConfigOtel.SpanLayout,
+		NoResolve:                       !svc.Config.TraceConfigEnrichment.ReverseDNS,
+		ASNTable:                        svc.Config.TraceConfigEnrichment.ASNTable,
+		Enricher:                        enricher,
+		PathRecorder:                    svc.history,
+		Hostname:                        svc.Hostname,
+	}
+}
+
+// destinationCLI copies the global settings and applies the settings of the destination, the destination
+// has already inherited the globals it does not override.
+//
+//nolint:gocritic // the CLI is copied so each destination has its own settings
+func destinationCLI(global trace.CLI, d config.TraceConfigDestination) trace.CLI {
+	t := global
+	t.Destination = d.Destination
+	t.TraceRoutePort = d.Port
+	t.Family = d.Family
+	t.MaxHops = d.MaxHops
+	t.NQueries = d.NQueries
+	t.Timeout = d.Timeout
+	t.Tags = d.Tags
+	return t
+}
+
+// run traces the destination and records the results, a run cancelled by the shutdown or the removal of the
+// destination is not recorded.
+func (svc *Service) run(ctx context.Context, hc *HealthCheck, t trace.CLI, protocol string) {
+	s := time.Now()
+	results, err := svc.trace(ctx, &t, protocol)
+	if methods.Cancelled(err) {
+		logger.Info("tracing cancelled",
+			zap.String("destination", t.Destination),
+			zap.String("protocol", protocol),
+			zap.Duration("duration", time.Since(s)),
+		)
+		return
+	}
+	hc.record(err == nil)
+	svc.record(t.Destination, protocol, results, err)
+	if err != nil {
+		logger.Warn("error",
+			zap.String("destination", t.Destination),
+			zap.String("protocol", protocol),
+			zap.String("family", t.Family),
+			zap.Bool("paris", t.Paris),
+			zap.Bool("multipath", t.MDA),
+			zap.Error(err),
+		)
+		return
+	}
+	logger.Info("tracing",
+		zap.String("service_name", ServiceName),
+		zap.String("destination", t.Destination),
+		zap.String("protocol", protocol),
+		zap.Any("tags", t.Tags),
+		zap.Duration("duration", time.Since(s)),
+	)
+	err = logger.Sync()
+	if err != nil {
+		logger.Warn("unable to flush logger",
+			zap.Error(err),
+		)
+	}
+}
+
+// trace runs the traceroute with the protocol of the destination.
+func (svc *Service) trace(ctx context.Context, t *trace.CLI, protocol string) ([]*methods.TraceResult, error) {
+	switch protocol {
+	case "udp":
+		return t.UDP(ctx)
+	case "tcp":
+		return t.TCP(ctx)
+	case "icmp":
+		return t.ICMP(ctx)
+	}
+	return nil, fmt.Errorf("protocol %s not understood", protocol)
+}
+
+// record updates the metrics with the results of the traceroutes to the destination.
+func (svc *Service) record(destination, protocol string, results []*methods.TraceResult, err error) {
+	if err != nil {
+		svc.metrics.ObserveError(destination, protocol, err)
+	}
+	for _, res := range results {
+		svc.metrics.Observe(res)
+		serr := svc.sinks.Write(context.Background(), res)
+		if serr != nil {
+			logger.Warn("unable to send result",
+				zap.String("destination", destination),
+				zap.Error(serr),
+			)
+		}
+	}
+}
+
+// pathChanged is called by the history when the path to a destination changed.
+func (svc *Service) pathChanged(change *methods.PathChange) {
+	svc.metrics.PathChanged(change)
+	hops := make([]string, 0, len(change.Hops))
+	for _, hop := range change.Hops {
+		hops = append(hops, hop.String())
+	}
+	logger.Warn("path changed",
+		zap.String("destination", change.Destination),
+		zap.String("ip", change.IP.String()),
+		zap.String("protocol", change.Protocol),
+		zap.Strings("hops", hops),
+		zap.Strings("previous", change.Previous),
+		zap.Strings("current", change.Current),
+		zap.Time("last-seen", change.LastSeen),
+	)
+}
+
+// NewEnricher returns the Enricher for the hop addresses configured in the enrichment section.
+func (svc *Service) NewEnricher() (*enrich.Enricher, error) {
+	var ptr *enrich.PTR
+	if svc.Config.TraceConfigEnrichment.ReverseDNS {
+		ptr = enrich.NewPTR(net.DefaultResolver, svc.Config.TraceConfigEnrichment.Timeout)
+	}
+	var table *enrich.Table
+	if svc.Config.TraceConfigEnrichment.ASNTable != "" {
+		var err error
+		table, err = enrich.LoadTable(svc.Config.TraceConfigEnrichment.ASNTable)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("loaded asn table",
+			zap.String("file", svc.Config.TraceConfigEnrichment.ASNTable),
+			zap.Int("prefixes", table.Len()),
+		)
+	}
+	return enrich.New(ptr, table), nil
 }
 
 func (svc *Service) LogStart() {
@@ -170,47 +521,90 @@ func (svc *Service) LogStart() {
 		zap.String("service_name", ServiceName),
 		zap.Dict("configuration",
 			zap.String("schema-version", svc.Config.SchemaVersion),
-			zap.Strings("destinations", svc.Config.TraceConfigDestinations),
+			zap.Array("destinations", destinations(svc.Config.TraceConfigDestinations)),
 			zap.Dict("globals",
 				zap.Uint16("max-hops", svc.Config.TraceConfigGlobal.MaxHops),
 				zap.Uint16("number-queries", svc.Config.TraceConfigGlobal.NQueries),
 				zap.Uint16("parallel-requests", svc.Config.TraceConfigGlobal.ParallelRequests),
+				zap.Uint16("probe-budget", svc.Config.TraceConfigGlobal.ProbeBudget),
+				zap.Float64("jitter", svc.Config.TraceConfigGlobal.Jitter),
 				zap.String("protocol", svc.Config.TraceConfigGlobal.Protocol),
+				zap.String("family", svc.Config.TraceConfigGlobal.Family),
+				zap.Bool("paris", svc.Config.TraceConfigGlobal.Paris),
+				zap.Bool("multipath", svc.Config.TraceConfigGlobal.Multipath),
 				zap.String("timeout", svc.Config.TraceConfigGlobal.Timeout.String()),
 			),
+			zap.Dict("enrichment",
+				zap.Bool("reverse-dns", svc.Config.TraceConfigEnrichment.ReverseDNS),
+				zap.String("timeout", svc.Config.TraceConfigEnrichment.Timeout.String()),
+				zap.String("asn-table", svc.Config.TraceConfigEnrichment.ASNTable),
+			),
+			zap.Dict("sinks",
+				zap.Bool("file", svc.Config.TraceConfigSinks.File.Enabled),
+				zap.Bool("syslog", svc.Config.TraceConfigSinks.Syslog.Enabled),
+				zap.Bool("webhook", svc.Config.TraceConfigSinks.Webhook.Enabled),
+				zap.Bool("nats", svc.Config.TraceConfigSinks.NATS.Enabled),
+			),
+			zap.Dict("history",
+				zap.Int("size", svc.Config.TraceConfigHistory.Size),
+				zap.String("directory", svc.Config.TraceConfigHistory.Directory),
+			),
 			zap.Dict("opentelemetry",
 				zap.String("destination", svc.Config.TraceConfigOtel.Destination),
 				zap.Bool("tls", svc.Config.TraceConfigOtel.TLS),
 				zap.Bool("grpc", svc.Config.TraceConfigOtel.GRPC),
 				zap.Int("port", svc.Config.TraceConfigOtel.Port),
+				zap.Bool("metrics", svc.Config.TraceConfigOtel.Metrics),
+				zap.String("ca-file", svc.Config.TraceConfigOtel.CAFile),
+				zap.String("cert-file", svc.Config.TraceConfigOtel.CertFile),
+				zap.String("server-name", svc.Config.TraceConfigOtel.ServerName),
+				zap.Bool("insecure-skip-verify", svc.Config.TraceConfigOtel.InsecureSkipVerify),
+				zap.Int("headers", len(svc.Config.TraceConfigOtel.Headers)),
+				zap.String("compression", svc.Config.TraceConfigOtel.Compression),
+				zap.String("traces-path", svc.Config.TraceConfigOtel.TracesPath),
+				zap.String("metrics-path", svc.Config.TraceConfigOtel.MetricsPath),
+				zap.String("span-layout", svc.Config.TraceConfigOtel.SpanLayout),
 			),
 		),
 	)
 }
 
+// destinations logs the destinations with the settings that are used for each of them.
+type destinations []config.TraceConfigDestination
+
+func (ds destinations) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, d := range ds {
+		d := d
+		err := enc.AppendObject(zapcore.ObjectMarshalerFunc(func(oe zapcore.ObjectEncoder) error {
+			oe.AddString("destination", d.Destination)
+			oe.AddString("protocol", d.Protocol)
+			oe.AddInt("port", d.Port)
+			oe.AddString("family", d.Family)
+			oe.AddUint16("max-hops", d.MaxHops)
+			oe.AddUint16("number-queries", d.NQueries)
+			oe.AddDuration("interval", d.Interval)
+			oe.AddDuration("timeout", d.Timeout)
+			return oe.AddReflected("tags", d.Tags)
+		}))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // RunHealthCheckSvc will launch the background process to serve health check requests.
 func (health *HealthCheck) RunHealthCheckSvc(cfg config.TraceConfigHealthCheck) {
 	if cfg.Enabled {
-		go func() {
-			sigint := make(chan os.Signal, 1)
-			signal.Notify(sigint, syscall.SIGINT, syscall.SIGTERM)
-			<-sigint
-
-			// We received an interrupt signal, shut down.
-			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-			defer cancel()
-			if err := healthCheckService.Shutdown(ctx); err != nil {
-				// Error from closing listeners, or context timeout:
-				logger.Warn("http server shutdown",
-					zap.Error(err))
-			}
-			close(health.close)
-		}()
 		// instantiate the http port
 		healthCheckService.Addr = fmt.Sprintf(":%d", cfg.Port)
 
 		// handle the http healthcheck Get request
 		http.HandleFunc(cfg.Path, health.Get)
+		// serve the prometheus metrics.
+		if health.metrics != nil {
+			http.Handle(cfg.MetricsPath, health.metrics)
+		}
 		// fallback for any other request to raise an error.
 		http.HandleFunc("/", health.invalid)
 
@@ -221,11 +615,35 @@ func (health *HealthCheck) RunHealthCheckSvc(cfg config.TraceConfigHealthCheck)
 			)
 		}
 
-		// block until healthcheck is being shutdown by the background goroutine sigint.
+		// block until healthcheck is being shutdown by the service.
 		<-health.close
 	}
 }
 
+// Shutdown stops serving the health check requests, the requests in flight are given 60 seconds to finish.
+func (health *HealthCheck) Shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	if err := healthCheckService.Shutdown(ctx); err != nil {
+		// Error from closing listeners, or context timeout:
+		logger.Warn("http server shutdown",
+			zap.Error(err))
+	}
+	close(health.close)
+}
+
+// record counts a finished traceroute.
+func (health *HealthCheck) record(success bool) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.Details.TotalTraces++
+	if success {
+		health.Details.SuccessfulTraces++
+		return
+	}
+	health.Details.UnsuccessfulTraces++
+}
+
 func (health *HealthCheck) Get(w http.ResponseWriter, req *http.Request) {
 	health.mutex.Lock()
 	defer health.mutex.Unlock()
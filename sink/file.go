@@ -0,0 +1,103 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+const fileMode os.FileMode = 0o640
+
+// File appends the results as JSON lines to a file, the file is rotated once it reaches the maximum size
+// and the oldest backups are removed.
+type File struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewFile opens the file for appending, a maxSize of 0 never rotates the file.
+func NewFile(path string, maxSize int64, maxBackups int) (*File, error) {
+	f := &File{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, fileMode)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *File) Write(_ context.Context, res *methods.TraceResult) error {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(data)) > f.maxSize {
+		err = f.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	return err
+}
+
+// rotate moves the file to the first backup, path.1, after shifting the existing backups up by one.
+func (f *File) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+	for i := f.maxBackups - 1; i > 0; i-- {
+		err = os.Rename(backup(f.path, i), backup(f.path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if f.maxBackups > 0 {
+		err = os.Rename(f.path, backup(f.path, 1))
+	} else {
+		err = os.Remove(f.path)
+	}
+	if err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func backup(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+func (f *File) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
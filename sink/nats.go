@@ -0,0 +1,162 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+// natsConnect is sent after the INFO of the server, verbose is disabled so publishing is not acknowledged.
+const natsConnect string = `CONNECT {"verbose":false,"pedantic":false,"name":"traceroute","lang":"go","protocol":0}` + "\r\n"
+
+// NATS publishes each result as JSON to a subject using the NATS text protocol, it connects on the first
+// result and reconnects on the next result after the connection fails.
+type NATS struct {
+	mutex   sync.Mutex
+	address string
+	subject string
+	timeout time.Duration
+	conn    net.Conn
+}
+
+// NewNATS returns a publisher to the NATS server at the address.
+func NewNATS(address, subject string, timeout time.Duration) *NATS {
+	return &NATS{address: address, subject: subject, timeout: timeout}
+}
+
+func (n *NATS) Write(ctx context.Context, res *methods.TraceResult) error {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.conn == nil {
+		err = n.connect(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	err = n.conn.SetWriteDeadline(time.Now().Add(n.timeout))
+	if err == nil {
+		_, err = fmt.Fprintf(n.conn, "PUB %s %d\r\n%s\r\n", n.subject, len(data), data)
+	}
+	if err != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+	return err
+}
+
+// connect reads the INFO of the server, sends CONNECT and waits for the PONG of a PING so that an
+// authorization error is returned before the first publish.
+func (n *NATS) connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: n.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", n.address)
+	if err != nil {
+		return err
+	}
+	err = conn.SetDeadline(time.Now().Add(n.timeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("nats server %s did not send INFO: %q", n.address, strings.TrimSpace(line))
+	}
+	_, err = fmt.Fprintf(conn, "%sPING\r\n", natsConnect)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	err = n.awaitPong(conn, r)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	n.conn = conn
+	go n.read(conn, r)
+	return nil
+}
+
+// awaitPong reads until the PONG, the PINGs of the server are answered and an error is returned as is.
+func (n *NATS) awaitPong(conn net.Conn, r *bufio.Reader) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			_, err = conn.Write([]byte("PONG\r\n"))
+			if err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats server %s refused the connection: %s", n.address, line)
+		}
+	}
+}
+
+// read answers the keep alive PINGs of the server until the connection fails, the connection is then
+// dropped so the next result reconnects.
+func (n *NATS) read(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		if strings.TrimSpace(line) != "PING" {
+			continue
+		}
+		n.mutex.Lock()
+		_, err = conn.Write([]byte("PONG\r\n"))
+		n.mutex.Unlock()
+		if err != nil {
+			break
+		}
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.conn == conn {
+		n.conn.Close()
+		n.conn = nil
+	}
+}
+
+func (n *NATS) Close() error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn = nil
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
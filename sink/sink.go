@@ -0,0 +1,91 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jimmystewpot/traceroute/config"
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+// Sink receives the result of every traceroute.
+type Sink interface {
+	Write(ctx context.Context, res *methods.TraceResult) error
+	Close() error
+}
+
+// Multi fans the results out to every sink added to it.
+type Multi struct {
+	names []string
+	sinks []Sink
+}
+
+// Add adds the sink, the name is used in the errors it returns.
+func (m *Multi) Add(name string, s Sink) {
+	m.names = append(m.names, name)
+	m.sinks = append(m.sinks, s)
+}
+
+// Len returns the number of sinks.
+func (m *Multi) Len() int {
+	return len(m.sinks)
+}
+
+// Write sends the result to every sink, a failing sink does not stop the result from reaching the others.
+func (m *Multi) Write(ctx context.Context, res *methods.TraceResult) error {
+	var errs []error
+	for i, s := range m.sinks {
+		err := s.Write(ctx, res)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s sink: %w", m.names[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every sink.
+func (m *Multi) Close() error {
+	var errs []error
+	for i, s := range m.sinks {
+		err := s.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s sink: %w", m.names[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// New returns the sinks that are enabled in the configuration, the values that are not defined are set to their
+// defaults. The sinks already opened are closed when one of them fails to open.
+func New(cfg config.TraceConfigSinks) (*Multi, error) {
+	cfg.SetDefaults()
+	m := &Multi{}
+	var s Sink
+	var err error
+	if cfg.File.Enabled {
+		s, err = NewFile(cfg.File.Path, *cfg.File.MaxSize, cfg.File.MaxBackups)
+		if err != nil {
+			return nil, closeOnError(m, err)
+		}
+		m.Add("file", s)
+	}
+	if cfg.Syslog.Enabled {
+		s, err = NewSyslog(cfg.Syslog.Network, cfg.Syslog.Address, cfg.Syslog.Tag)
+		if err != nil {
+			return nil, closeOnError(m, err)
+		}
+		m.Add("syslog", s)
+	}
+	if cfg.Webhook.Enabled {
+		m.Add("webhook", NewWebhook(cfg.Webhook.URL, cfg.Webhook.Headers, cfg.Webhook.Timeout))
+	}
+	if cfg.NATS.Enabled {
+		m.Add("nats", NewNATS(cfg.NATS.Address, cfg.NATS.Subject, cfg.NATS.Timeout))
+	}
+	return m, nil
+}
+
+func closeOnError(m *Multi, err error) error {
+	return errors.Join(err, m.Close())
+}
@@ -0,0 +1,303 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/config"
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+func testResult() *methods.TraceResult {
+	rtt := time.Millisecond
+	return &methods.TraceResult{
+		Destination: "example.com",
+		IP:          net.ParseIP("192.0.2.1"),
+		Protocol:    "udp",
+		Port:        33434,
+		StartTime:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Hops: []methods.TraceHop{{
+			TTL: 1,
+			Probes: []methods.TracerouteHop{{
+				Success: true,
+				Address: &net.IPAddr{IP: net.ParseIP("192.0.2.1")},
+				TTL:     1,
+				RTT:     &rtt,
+			}},
+		}},
+		ReachedDestination: true,
+	}
+}
+
+// decode checks the payload is the JSON of testResult.
+func decode(t *testing.T, data []byte) {
+	t.Helper()
+	var res methods.TraceResult
+	err := json.Unmarshal(data, &res)
+	if err != nil {
+		t.Fatalf("payload %q is not a result: %v", data, err)
+	}
+	if res.Destination != "example.com" || len(res.Hops) != 1 {
+		t.Errorf("payload decoded to %+v", res)
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	line, err := json.Marshal(testResult())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two results fit in the file before it is rotated.
+	f, err := NewFile(path, int64(2*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 7; i++ {
+		err = f.Write(context.Background(), testResult())
+		if err != nil {
+			t.Fatalf("File.Write() error = %v", err)
+		}
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{path: 1, path + ".1": 2, path + ".2": 2}
+	for file, lines := range want {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("backup was not kept: %v", err)
+		}
+		got := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(got) != lines {
+			t.Errorf("%s has %d lines, want %d", file, len(got), lines)
+		}
+		decode(t, []byte(got[0]))
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Errorf("more than 2 backups were kept")
+	}
+}
+
+func TestSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := NewSyslog("udp", conn.LocalAddr().String(), "traceroute-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	err = s.Write(context.Background(), testResult())
+	if err != nil {
+		t.Fatalf("Syslog.Write() error = %v", err)
+	}
+
+	buf := make([]byte, 64*1024)
+	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	// <30> is the info severity of the daemon facility.
+	if !strings.HasPrefix(msg, "<30>") || !strings.Contains(msg, "traceroute-test") {
+		t.Errorf("syslog message %q does not have the priority and tag", msg)
+	}
+	decode(t, []byte(msg[strings.Index(msg, "{"):]))
+}
+
+func TestWebhook(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	w := NewWebhook(srv.URL, map[string]string{"Authorization": "Bearer token"}, 5*time.Second)
+	err := w.Write(context.Background(), testResult())
+	if err != nil {
+		t.Fatalf("Webhook.Write() error = %v", err)
+	}
+	decode(t, <-bodies)
+
+	w = NewWebhook(srv.URL, nil, 5*time.Second)
+	if err := w.Write(context.Background(), testResult()); err == nil {
+		t.Errorf("Webhook.Write() did not return the error status")
+	}
+}
+
+// natsServer is a local stand-in for a NATS server that forwards the published payloads.
+func natsServer(t *testing.T, auth bool) (string, chan string) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	published := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go serveNATS(conn, auth, published)
+		}
+	}()
+	return lis.Addr().String(), published
+}
+
+func serveNATS(conn net.Conn, auth bool, published chan string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "CONNECT":
+			if auth {
+				fmt.Fprint(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+			// the keep alive of the server must be answered.
+			fmt.Fprint(conn, "PING\r\n")
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "PONG":
+			published <- "PONG"
+		case "PUB":
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return
+			}
+			payload := make([]byte, size+2)
+			_, err = io.ReadFull(r, payload)
+			if err != nil {
+				return
+			}
+			published <- fields[1] + " " + string(payload[:size])
+		}
+	}
+}
+
+func TestNATS(t *testing.T) {
+	addr, published := natsServer(t, false)
+	n := NewNATS(addr, "traceroute.results", 5*time.Second)
+	defer n.Close()
+	for i := 0; i < 2; i++ {
+		err := n.Write(context.Background(), testResult())
+		if err != nil {
+			t.Fatalf("NATS.Write() error = %v", err)
+		}
+	}
+	var payloads int
+	for payloads < 2 {
+		select {
+		case msg := <-published:
+			if msg == "PONG" {
+				continue
+			}
+			subject, payload, _ := strings.Cut(msg, " ")
+			if subject != "traceroute.results" {
+				t.Errorf("published to %s, want traceroute.results", subject)
+			}
+			decode(t, []byte(payload))
+			payloads++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("server received %d results, want 2", payloads)
+		}
+	}
+
+	addr, _ = natsServer(t, true)
+	n = NewNATS(addr, "traceroute.results", 5*time.Second)
+	if err := n.Write(context.Background(), testResult()); err == nil {
+		t.Errorf("NATS.Write() did not return the authorization error")
+	}
+}
+
+func TestNew(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+
+	cfg := config.TraceConfigSinks{
+		File:    config.TraceConfigSinkFile{Enabled: true, Path: path},
+		Webhook: config.TraceConfigSinkWebhook{Enabled: true, URL: srv.URL, Timeout: time.Second},
+		// disabled sinks are not opened.
+		NATS: config.TraceConfigSinkNATS{Address: "127.0.0.1:1"},
+	}
+	m, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() != 2 {
+		t.Fatalf("New() returned %d sinks, want 2", m.Len())
+	}
+	err = m.Write(context.Background(), testResult())
+	if err != nil {
+		t.Fatalf("Multi.Write() error = %v", err)
+	}
+	decode(t, <-bodies)
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decode(t, data)
+
+	// a failing sink is reported by name and does not stop the others.
+	cfg.Webhook.URL = "http://127.0.0.1:1"
+	m, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	err = m.Write(context.Background(), testResult())
+	if err == nil || !strings.Contains(err.Error(), "webhook sink") {
+		t.Errorf("Multi.Write() error = %v, want the webhook sink error", err)
+	}
+}
@@ -0,0 +1,36 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"log/syslog"
+
+	"github.com/jimmystewpot/traceroute/methods"
+)
+
+// Syslog sends each result as a JSON message to syslog.
+type Syslog struct {
+	writer *syslog.Writer
+}
+
+// NewSyslog connects to the syslog server at the address, the local syslog daemon is used when the
+// network and address are empty.
+func NewSyslog(network, address, tag string) (*Syslog, error) {
+	w, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &Syslog{writer: w}, nil
+}
+
+func (s *Syslog) Write(_ context.Context, res *methods.TraceResult) error {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return s.writer.Info(string(data))
+}
+
+func (s *Syslog) Close() error {
+	return s.writer.Close()
+}
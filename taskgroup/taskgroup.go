@@ -36,9 +36,14 @@ func (t *TaskGroup) Done() {
 	t.count--
 }
 
+// Wait returns once every task is done, it returns at once when the tasks were done before it was called.
 func (t *TaskGroup) Wait() {
 	doneChannel := make(chan struct{})
 	t.mu.Lock()
+	if t.count == 0 {
+		t.mu.Unlock()
+		return
+	}
 	t.done = append(t.done, doneChannel)
 	t.mu.Unlock()
 	<-doneChannel
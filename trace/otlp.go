@@ -0,0 +1,123 @@
+package trace
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// compressionGzip compresses the OpenTelemetry requests.
+	compressionGzip string = "gzip"
+
+	// default URL path of the OTLP/HTTP traces endpoint.
+	otlpTracesPath string = "/v1/traces"
+)
+
+// newTraceExporter returns the OTLP span exporter for the configured destination and protocol.
+func (cli *CLI) newTraceExporter(ctx context.Context) (*otlptrace.Exporter, error) {
+	tlsConfig, err := cli.otlpTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if cli.OpenTelemetryGRPC {
+		// GRPC Destination Configuration for the exporter
+		opts := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(cli.otlpEndpoint()),
+			otlptracegrpc.WithHeaders(cli.OpenTelemetryHeaders),
+			otlptracegrpc.WithDialOption(grpc.WithUserAgent(applicationName)),
+		}
+		if tlsConfig != nil {
+			opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig)))
+		} else {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		if cli.OpenTelemetryCompression == compressionGzip {
+			opts = append(opts, otlptracegrpc.WithCompressor(compressionGzip))
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	}
+
+	// HTTP Destination configuration for the exporter
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(cli.otlpEndpoint()),
+		otlptracehttp.WithURLPath(otlpPath(cli.OpenTelemetryTracesPath, otlpTracesPath)),
+		otlptracehttp.WithHeaders(cli.OpenTelemetryHeaders),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, otlptracehttp.WithTLSClientConfig(tlsConfig))
+	} else {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if cli.OpenTelemetryCompression == compressionGzip {
+		opts = append(opts, otlptracehttp.WithCompression(otlptracehttp.GzipCompression))
+	}
+	return otlptracehttp.New(ctx, opts...)
+}
+
+// otlpEndpoint returns the host and port of the OpenTelemetry destination.
+func (cli *CLI) otlpEndpoint() string {
+	return net.JoinHostPort(cli.OpenTelemetryDestination, fmt.Sprintf("%d", cli.OpenTelemetryPort))
+}
+
+// otlpURL returns the OTLP/HTTP URL of the path on the OpenTelemetry destination.
+func (cli *CLI) otlpURL(path, defaultPath string) string {
+	scheme := "http"
+	if cli.OpenTelemetryTLS {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, cli.otlpEndpoint(), otlpPath(path, defaultPath))
+}
+
+// otlpPath returns the path or the default path when it is not set, the service does not set the CLI defaults.
+func otlpPath(path, defaultPath string) string {
+	if path == "" {
+		return defaultPath
+	}
+	return path
+}
+
+// otlpTLSConfig returns the TLS configuration for the OpenTelemetry destination, it is nil when TLS is not used.
+func (cli *CLI) otlpTLSConfig() (*tls.Config, error) {
+	if !cli.OpenTelemetryTLS {
+		return nil, nil
+	}
+	//nolint:gosec // skipping the verification is opt in for collectors with self signed certificates.
+	cfg := &tls.Config{
+		ServerName:         cli.OpenTelemetryServerName,
+		InsecureSkipVerify: cli.OpenTelemetryInsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if cli.OpenTelemetryCAFile != "" {
+		pem, err := os.ReadFile(cli.OpenTelemetryCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cli.OpenTelemetryCAFile)
+		}
+	}
+	if (cli.OpenTelemetryCertFile == "") != (cli.OpenTelemetryKeyFile == "") {
+		return nil, errors.New("the client certificate and key files must be set together")
+	}
+	if cli.OpenTelemetryCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cli.OpenTelemetryCertFile, cli.OpenTelemetryKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,317 @@
+package trace
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/traceroute/metrics"
+	"go.opentelemetry.io/otel"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	testServerName string = "collector.test"
+	testHeader     string = "authorization"
+	testToken      string = "Bearer secret"
+)
+
+// testPKI is a private CA with a server certificate for testServerName and a client certificate.
+type testPKI struct {
+	caFile, certFile, keyFile string
+	server                    *tls.Config
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage, dnsNames []string) (certPEM, keyPEM []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "test"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			DNSNames:     dnsNames,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	}
+
+	serverCert, serverKey := issue(2, x509.ExtKeyUsageServerAuth, []string{testServerName})
+	server, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, clientKey := issue(3, x509.ExtKeyUsageClientAuth, nil)
+
+	pki := &testPKI{
+		caFile:   filepath.Join(dir, "ca.pem"),
+		certFile: filepath.Join(dir, "client.pem"),
+		keyFile:  filepath.Join(dir, "client-key.pem"),
+		server: &tls.Config{
+			Certificates: []tls.Certificate{server},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+	files := map[string][]byte{
+		pki.caFile:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		pki.certFile: clientCert,
+		pki.keyFile:  clientKey,
+	}
+	for file, data := range files {
+		err = os.WriteFile(file, data, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pki
+}
+
+// cli returns the CLI configured to send to the address with mutual TLS, a header and gzip compression.
+func (pki *testPKI) cli(t *testing.T, addr string, grpc bool) *CLI {
+	t.Helper()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &CLI{
+		Timeout:                  5 * time.Second,
+		Hostname:                 "test-host",
+		OpenTelemetryDestination: host,
+		OpenTelemetryPort:        p,
+		OpenTelemetryGRPC:        grpc,
+		OpenTelemetryTLS:         true,
+		OpenTelemetryCAFile:      pki.caFile,
+		OpenTelemetryCertFile:    pki.certFile,
+		OpenTelemetryKeyFile:     pki.keyFile,
+		OpenTelemetryServerName:  testServerName,
+		OpenTelemetryHeaders:     map[string]string{testHeader: testToken},
+		OpenTelemetryCompression: compressionGzip,
+		OpenTelemetryTracesPath:  "/otlp/v1/traces",
+		OpenTelemetryMetricsPath: "/otlp/v1/metrics",
+	}
+}
+
+// receiver is an in-process OTLP collector that records the authorization header of each request.
+type receiver struct {
+	coltracepb.UnimplementedTraceServiceServer
+	colmetricpb.UnimplementedMetricsServiceServer
+	requests chan string
+}
+
+func (r *receiver) header(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(testHeader); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (r *receiver) Export(ctx context.Context, _ *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	r.requests <- "traces " + r.header(ctx)
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+// metricsReceiver adapts the receiver to the metrics service, both services name their method Export.
+type metricsReceiver struct {
+	*receiver
+}
+
+func (r metricsReceiver) Export(ctx context.Context, _ *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	r.requests <- "metrics " + r.header(ctx)
+	return &colmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+// export sends a span and the metrics of a traceroute with the CLI settings.
+func export(t *testing.T, cli *CLI) {
+	t.Helper()
+	exportTrace, err := cli.initTraceProvider(cli.Timeout)
+	if err != nil {
+		t.Fatalf("CLI.initTraceProvider() error = %v", err)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "traceroute")
+	span.End()
+	exportTrace()
+
+	registry := metrics.NewRegistry()
+	registry.NewCounter("test_total", "A counter.").Inc()
+	exporter, err := cli.NewMetricsExporter(registry)
+	if err != nil {
+		t.Fatalf("CLI.NewMetricsExporter() error = %v", err)
+	}
+	err = exporter.Export(context.Background())
+	if err != nil {
+		t.Fatalf("OTLPExporter.Export() error = %v", err)
+	}
+	err = exporter.Close()
+	if err != nil {
+		t.Fatalf("OTLPExporter.Close() error = %v", err)
+	}
+}
+
+func expectRequests(t *testing.T, requests chan string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-requests:
+			if got != w {
+				t.Errorf("receiver got %q, want %q", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("receiver did not get %q", w)
+		}
+	}
+}
+
+func TestOTLPGRPC(t *testing.T) {
+	pki := newTestPKI(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &receiver{requests: make(chan string, 2)}
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(pki.server)))
+	coltracepb.RegisterTraceServiceServer(srv, r)
+	colmetricpb.RegisterMetricsServiceServer(srv, metricsReceiver{r})
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	export(t, pki.cli(t, lis.Addr().String(), true))
+	expectRequests(t, r.requests, "traces "+testToken, "metrics "+testToken)
+}
+
+func TestOTLPHTTP(t *testing.T) {
+	pki := newTestPKI(t)
+	requests := make(chan string, 2)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != compressionGzip {
+			http.Error(w, "request is not compressed", http.StatusBadRequest)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, err = io.Copy(io.Discard, zr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests <- r.URL.Path + " " + r.Header.Get(testHeader)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	srv.TLS = pki.server
+	srv.StartTLS()
+	defer srv.Close()
+
+	export(t, pki.cli(t, srv.Listener.Addr().String(), false))
+	expectRequests(t, requests, "/otlp/v1/traces "+testToken, "/otlp/v1/metrics "+testToken)
+}
+
+func TestOTLPTLSConfig(t *testing.T) {
+	pki := newTestPKI(t)
+	tests := []struct {
+		name    string
+		cli     CLI
+		wantNil bool
+		wantErr bool
+	}{
+		{
+			name:    "tls disabled",
+			cli:     CLI{OpenTelemetryCAFile: pki.caFile},
+			wantNil: true,
+		},
+		{
+			name: "ca and client certificate",
+			cli:  CLI{OpenTelemetryTLS: true, OpenTelemetryCAFile: pki.caFile, OpenTelemetryCertFile: pki.certFile, OpenTelemetryKeyFile: pki.keyFile},
+		},
+		{
+			name:    "certificate without key",
+			cli:     CLI{OpenTelemetryTLS: true, OpenTelemetryCertFile: pki.certFile},
+			wantErr: true,
+		},
+		{
+			name:    "ca file without certificates",
+			cli:     CLI{OpenTelemetryTLS: true, OpenTelemetryCAFile: pki.keyFile},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cli.otlpTLSConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CLI.otlpTLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (got == nil) != tt.wantNil {
+				t.Errorf("CLI.otlpTLSConfig() = %v, wantNil %v", got, tt.wantNil)
+			}
+		})
+	}
+}
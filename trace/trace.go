@@ -2,48 +2,95 @@ package trace
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"net/netip"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kong"
+	"github.com/jimmystewpot/traceroute/config"
+	"github.com/jimmystewpot/traceroute/enrich"
+	"github.com/jimmystewpot/traceroute/formatter"
 	"github.com/jimmystewpot/traceroute/methods"
+	"github.com/jimmystewpot/traceroute/methods/icmp"
 	"github.com/jimmystewpot/traceroute/methods/tcp"
 	"github.com/jimmystewpot/traceroute/methods/udp"
+	"github.com/jimmystewpot/traceroute/metrics"
+	"github.com/jimmystewpot/traceroute/sink"
 	"github.com/rs/xid"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/baggage"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 const (
 	tracerName      string = "%s/traceroute"
 	applicationName string = "github.com/jimmystewpot/traceroute"
+	// defaultReportCycles is the number of cycles of a report when --cycles is not set, the same as mtr.
+	defaultReportCycles int = 10
+
+	// address families that can be selected for a traceroute.
+	FamilyIPv4 string = "4"
+	FamilyIPv6 string = "6"
+	FamilyBoth string = "both"
 )
 
 type CLI struct {
-	MaxHops                  uint16        `help:"Set the maximum hops for the traceroute" short:"m" default:"30" env:"TRACE_MAXHOPS"`
-	NQueries                 uint16        `help:"Set the number of probes per hop to send" short:"q" default:"3" env:"TRACE_NQUERIES"`
-	ParallelRequests         uint16        `help:"Set maximum number of parallel requests in flight" short:"N" default:"16" env:"TRACE_PARALLEL"`
-	Timeout                  time.Duration `help:"Set a timeout" short:"w" default:"2s" env:"TRACE_TIMEOUT"`
-	TraceRoutePort           int           `help:"Set the port on which to traceroute" short:"p" default:"33434" env:"TRACE_SRC_PORT"`
-	OpenTelemetryDestination string        `required:"" help:"OpenTelemetry destination for traces" name:"otel-dest" default:"localhost" env:"TRACE_OTEL_DEST"`
-	OpenTelemetryTLS         bool          `help:"OpenTelemetry destination requires TLS" name:"otel-tls" default:"false" env:"TRACE_OTEL_TLS"`
-	OpenTelemetryGRPC        bool          `help:"OpenTelemetry uses GPRC protocol" name:"otel-grpc" default:"true" env:"TRACE_OTEL_GRPC"`
-	OpenTelemetryPort        int           `help:"OpenTelemetry destination port to send traces to" name:"otel-port" default:"4317" env:"TRACE_OTEL_PORT"`
-	Destination              string        `required:"" help:"IP or Hostname address to traceroute to" env:"TRACE_DESTINATION"`
-	PrintResults             bool          `required:"" help:"Print trace to stdout, NOT recommended if running in docker" default:"false" env:"TRACE_STDOUT"`
-	Hostname                 string        `hidden:""`
+	MaxHops                         uint16               `help:"Set the maximum hops for the traceroute" short:"m" default:"30" env:"TRACE_MAXHOPS"`
+	NQueries                        uint16               `help:"Set the number of probes per hop to send" short:"q" default:"3" env:"TRACE_NQUERIES"`
+	ParallelRequests                uint16               `help:"Set maximum number of parallel requests in flight" short:"N" default:"16" env:"TRACE_PARALLEL"`
+	Timeout                         time.Duration        `help:"Set a timeout" short:"w" default:"2s" env:"TRACE_TIMEOUT"`
+	TraceRoutePort                  int                  `help:"Set the port on which to traceroute" short:"p" default:"33434" env:"TRACE_SRC_PORT"`
+	Family                          string               `help:"Address family to traceroute (4, 6 or both)" enum:"4,6,both" default:"4" env:"TRACE_FAMILY"`
+	Paris                           bool                 `help:"Keep the flow identifier constant so all probes follow one ECMP path" default:"false" env:"TRACE_PARIS"`
+	MDA                             bool                 `help:"Enumerate the load balanced paths with the multipath detection algorithm" name:"mda" default:"false" env:"TRACE_MDA"`
+	MDAConfidence                   float64              `help:"Confidence that all next hops of an interface are found" name:"mda-confidence" default:"0.95" env:"TRACE_MDA_CONFIDENCE"`
+	OpenTelemetryDestination        string               `required:"" help:"OpenTelemetry destination for traces" name:"otel-dest" default:"localhost" env:"TRACE_OTEL_DEST"`
+	OpenTelemetryTLS                bool                 `help:"OpenTelemetry destination requires TLS" name:"otel-tls" default:"false" env:"TRACE_OTEL_TLS"`
+	OpenTelemetryGRPC               bool                 `help:"OpenTelemetry uses GPRC protocol" name:"otel-grpc" negatable:"" default:"true" env:"TRACE_OTEL_GRPC"`
+	OpenTelemetryPort               int                  `help:"OpenTelemetry destination port to send traces to" name:"otel-port" default:"4317" env:"TRACE_OTEL_PORT"`
+	OpenTelemetryMetrics            bool                 `help:"Export RTT, loss and trace completion metrics to the OpenTelemetry destination" name:"otel-metrics" negatable:"" default:"false" env:"TRACE_OTEL_METRICS"`
+	OpenTelemetryCAFile             string               `help:"CA certificate file used to verify the OpenTelemetry destination" name:"otel-ca-file" type:"existingfile" env:"TRACE_OTEL_CA_FILE"`
+	OpenTelemetryCertFile           string               `help:"Client certificate file presented to the OpenTelemetry destination" name:"otel-cert-file" type:"existingfile" env:"TRACE_OTEL_CERT_FILE"`
+	OpenTelemetryKeyFile            string               `help:"Key file of the client certificate" name:"otel-key-file" type:"existingfile" env:"TRACE_OTEL_KEY_FILE"`
+	OpenTelemetryServerName         string               `help:"Server name used to verify the OpenTelemetry destination certificate" name:"otel-server-name" env:"TRACE_OTEL_SERVER_NAME"`
+	OpenTelemetryInsecureSkipVerify bool                 `help:"Do not verify the OpenTelemetry destination certificate" name:"otel-insecure-skip-verify" default:"false" env:"TRACE_OTEL_INSECURE_SKIP_VERIFY"`
+	OpenTelemetryHeaders            map[string]string    `help:"Headers sent with every OpenTelemetry request (key=value;...)" name:"otel-headers" env:"TRACE_OTEL_HEADERS"`
+	OpenTelemetryCompression        string               `help:"Compression of the OpenTelemetry requests (none or gzip)" name:"otel-compression" enum:"none,gzip" default:"none" env:"TRACE_OTEL_COMPRESSION"`
+	OpenTelemetryTracesPath         string               `help:"URL path of the OTLP/HTTP traces endpoint" name:"otel-traces-path" default:"/v1/traces" env:"TRACE_OTEL_TRACES_PATH"`
+	OpenTelemetryMetricsPath        string               `help:"URL path of the OTLP/HTTP metrics endpoint" name:"otel-metrics-path" default:"/v1/metrics" env:"TRACE_OTEL_METRICS_PATH"`
+	SpanLayout                      string               `help:"Export a span per probe (probe) or a span per hop with its probes as children (hop)" name:"span-layout" enum:"probe,hop" default:"probe" env:"TRACE_SPAN_LAYOUT"`
+	Destination                     string               `required:"" help:"IP or Hostname address to traceroute to" env:"TRACE_DESTINATION"`
+	PrintResults                    bool                 `required:"" help:"Print trace to stdout, NOT recommended if running in docker" default:"false" env:"TRACE_STDOUT"`
+	Output                          string               `help:"Format of the printed trace (text, json, jsonl or csv)" short:"o" enum:"text,json,jsonl,csv" default:"text" env:"TRACE_OUTPUT"`
+	Live                            bool                 `help:"Draw the hops as the replies arrive, like mtr, the table is drawn on stderr" default:"false" env:"TRACE_LIVE"`
+	Cycles                          int                  `help:"Probe the path this many times and accumulate the hop statistics, 10 with --report" default:"0" env:"TRACE_CYCLES"`
+	Interval                        time.Duration        `help:"Time to wait between the cycles" default:"1s" env:"TRACE_INTERVAL"`
+	Report                          bool                 `help:"Print a report of the hop statistics over the cycles when they finish, like mtr --report" default:"false" env:"TRACE_REPORT"`
+	NoResolve                       bool                 `help:"Do not resolve the hop addresses to hostnames" short:"n" name:"no-resolve" default:"false" env:"TRACE_NO_RESOLVE"`
+	ASNTable                        string               `help:"CSV or pfx2as file used to look up the AS of the hop addresses" name:"asn-table" type:"existingfile" env:"TRACE_ASN_TABLE"`
+	Tags                            map[string]string    `help:"Tags added to the results and the spans" env:"TRACE_TAGS"`
+	SinkConfig                      string               `help:"YAML configuration file with the sinks to send the results to" name:"sink-config" type:"existingfile" env:"TRACE_SINK_CONFIG"`
+	Enricher                        methods.Enricher     `kong:"-"`
+	PathRecorder                    methods.PathRecorder `kong:"-"`
+	// TracerProvider is set by the service to share one provider between the traceroutes that run at the same
+	// time, without it each traceroute sets the global provider and flushes it when it finishes.
+	TracerProvider oteltrace.TracerProvider `kong:"-"`
+	Hostname       string                   `hidden:""`
+	// metrics records the results for the OTLP metrics exporter, it is nil when metrics are not exported.
+	metrics *metrics.Traceroute
 }
 
 func (cli *CLI) Run(kongctx *kong.Context) error {
@@ -53,7 +100,7 @@ func (cli *CLI) Run(kongctx *kong.Context) error {
 		return err
 	}
 
-	destinations, err := parseDestination(cli.Destination)
+	destinations, err := parseDestination(cli.Destination, cli.Family)
 	if err != nil {
 		return err
 	}
@@ -65,116 +112,277 @@ func (cli *CLI) Run(kongctx *kong.Context) error {
 	}
 	defer exportTrace()
 
-	ctx := context.Background()
+	// exportMetrics will export the metrics when the tool quits.
+	exportMetrics, err := cli.initMetricsExporter()
+	if err != nil {
+		return err
+	}
+	defer exportMetrics()
+
+	// an interrupt stops the traceroute, the hops found so far are printed and the spans are exported.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	// ctx is reset with the baggage added.
 	ctx, err = cli.initBaggage(ctx)
 	if err != nil {
 		return err
 	}
 
-	cfg := cli.translateConfig(ctx)
+	err = cli.initEnricher()
+	if err != nil {
+		return err
+	}
 
-	var res *map[uint16][]methods.TracerouteHop
-	switch kongctx.Command() {
-	case "tcp":
-		for i := 0; i < len(destinations); i++ {
-			tcpTraceroute := tcp.New(destinations[i], cfg)
-			res, err = tcpTraceroute.Start()
-		}
+	cfg := cli.translateConfig(ctx)
 
-	case "udp":
-		for i := 0; i < len(destinations); i++ {
-			tcpTraceroute := udp.New(destinations[i], true, cfg)
-			res, err = tcpTraceroute.Start()
-		}
-	default:
-		return fmt.Errorf("error command %s not understood", kongctx.Command())
+	out, err := formatter.New(cli.Output, os.Stdout)
+	if err != nil {
+		return err
 	}
 
-	// checks error from within switch statement
+	sinks, err := cli.initSinks()
 	if err != nil {
 		return err
 	}
-	if cli.PrintResults {
-		printResults(res)
+	defer sinks.Close()
+
+	displays := []*formatter.Live{cli.initLive(), cli.initReport()}
+
+	for i := 0; i < len(destinations); i++ {
+		err = cli.runCycles(ctx, kongctx.Command(), destinations[i], cfg, out, sinks, displays)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// UDP is used by the Service UDP traceroute system, it will generate a trace per destination.
-func (cli *CLI) UDP() error {
-	destinations, err := parseDestination(cli.Destination)
-	if err != nil {
-		return err
+// runCycles traceroutes the destination once per cycle, the live display and the report accumulate the probes of
+// every cycle. Each cycle is exported as its own trace, the traces share the xid of the run.
+//
+//nolint:gocritic // config is large and required
+func (cli *CLI) runCycles(ctx context.Context, command string, destination net.IP, cfg methods.TracerouteConfig,
+	out formatter.Formatter, sinks *sink.Multi, displays []*formatter.Live,
+) error {
+	finish := cli.startLive(command, destination, &cfg, displays...)
+	defer finish()
+
+	cycles := cli.cycles()
+	for cycle := 1; ; cycle++ {
+		if cycles > 1 {
+			cfg.Cycle = cycle
+		}
+		err := cli.runOnce(ctx, command, destination, cfg, out, sinks)
+		if err != nil || cycle >= cycles {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cli.Interval):
+		}
 	}
-	// exportTrace will export the spans when the tool quits.
-	exportTrace, err := cli.initTraceProvider(cli.Timeout)
-	if err != nil {
+}
+
+// cycles returns the number of times the path is probed, a report is made of 10 cycles unless set otherwise.
+func (cli *CLI) cycles() int {
+	switch {
+	case cli.Cycles > 0:
+		return cli.Cycles
+	case cli.Report:
+		return defaultReportCycles
+	}
+	return 1
+}
+
+// runOnce traceroutes the destination, the result is recorded, sent to the sinks and printed.
+//
+//nolint:gocritic // config is large and required
+func (cli *CLI) runOnce(ctx context.Context, command string, destination net.IP, cfg methods.TracerouteConfig,
+	out formatter.Formatter, sinks *sink.Multi,
+) error {
+	res, err := start(ctx, command, destination, cfg)
+	if res == nil && err != nil {
 		return err
 	}
-	defer exportTrace()
+	cli.observe(command, res, err)
+	// a sink that fails does not stop the traceroutes to the remaining destinations, the partial result of
+	// an interrupted traceroute is still written.
+	if serr := sinks.Write(context.WithoutCancel(ctx), res); serr != nil {
+		fmt.Fprintf(os.Stderr, "error sending result: %s\n", serr)
+	}
 
-	ctx := context.Background()
-	// ctx is reset with the baggage added.
-	ctx, err = cli.initBaggage(ctx)
-	if err != nil {
+	if err != nil && !methods.Cancelled(err) {
 		return err
 	}
+	if cli.PrintResults {
+		if perr := cli.printResults(out, res); perr != nil {
+			return perr
+		}
+	}
+	return err
+}
 
-	cfg := cli.translateConfig(ctx)
+// initLive returns the live display when it is enabled, the table is only redrawn when stderr is a terminal.
+func (cli *CLI) initLive() *formatter.Live {
+	if !cli.Live {
+		return nil
+	}
+	return formatter.NewLive(os.Stderr, isTerminal(os.Stderr))
+}
 
-	var res *map[uint16][]methods.TracerouteHop
-	for i := 0; i < len(destinations); i++ {
-		udpTraceroute := udp.New(destinations[i], true, cfg)
-		res, err = udpTraceroute.Start()
-		if cli.PrintResults {
-			printResults(res)
+// initReport returns the report when it is enabled, it is the live table written once to stdout after the last
+// cycle.
+func (cli *CLI) initReport() *formatter.Live {
+	if !cli.Report {
+		return nil
+	}
+	return formatter.NewLive(os.Stdout, false)
+}
+
+// startLive resets the displays for the traceroute to the destination and sends the probes of cfg to them, the
+// displays that are nil are skipped. The returned func draws the final tables.
+func (cli *CLI) startLive(command string, destination net.IP, cfg *methods.TracerouteConfig, displays ...*formatter.Live) func() {
+	started := make([]*formatter.Live, 0, len(displays))
+	for _, live := range displays {
+		if live != nil {
+			live.Start(cli.Destination, destination, command)
+			started = append(started, live)
+		}
+	}
+	if len(started) == 0 {
+		return func() {}
+	}
+	cfg.OnProbe = func(hop methods.TracerouteHop) {
+		for _, live := range started {
+			live.Probe(hop)
+		}
+	}
+	return func() {
+		for _, live := range started {
+			live.Finish()
 		}
 	}
+}
+
+// isTerminal reports whether the file is a terminal rather than a pipe or a regular file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
 	if err != nil {
-		return err
+		return false
 	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
 
-	return nil
+// start runs the traceroute to the destination with the protocol of the command until it completes or the
+// context is done, the UDP and TCP traceroutes run the multipath detection algorithm when it is enabled.
+//
+//nolint:gocritic // config is large and required
+func start(ctx context.Context, command string, destination net.IP, cfg methods.TracerouteConfig) (*methods.TraceResult, error) {
+	if cfg.Multipath {
+		return startMultipath(ctx, command, destination, cfg)
+	}
+	switch command {
+	case "tcp":
+		return tcp.New(destination, cfg).StartContext(ctx)
+	case "udp":
+		return udp.New(destination, true, cfg).StartContext(ctx)
+	case "icmp":
+		return icmp.New(destination, cfg).StartContext(ctx)
+	default:
+		return nil, fmt.Errorf("error command %s not understood", command)
+	}
+}
+
+// startMultipath runs the multipath detection algorithm to the destination with the protocol of the command, ICMP
+// has no multipath detection and is traced normally.
+//
+//nolint:gocritic // config is large and required
+func startMultipath(ctx context.Context, command string, destination net.IP, cfg methods.TracerouteConfig) (*methods.TraceResult, error) {
+	switch command {
+	case "tcp":
+		return tcp.New(destination, cfg).StartMultipathContext(ctx)
+	case "udp":
+		return udp.New(destination, true, cfg).StartMultipathContext(ctx)
+	}
+	cfg.Multipath = false
+	return start(ctx, command, destination, cfg)
+}
+
+// UDP is used by the Service UDP traceroute system, it will generate a trace per destination until the
+// context is done.
+func (cli *CLI) UDP(ctx context.Context) ([]*methods.TraceResult, error) {
+	return cli.traceroutes(ctx, "udp")
+}
+
+// TCP is used by the Service TCP traceroute system, it will generate a trace per destination until the
+// context is done.
+func (cli *CLI) TCP(ctx context.Context) ([]*methods.TraceResult, error) {
+	return cli.traceroutes(ctx, "tcp")
+}
+
+// ICMP is used by the Service ICMP traceroute system, it will generate a trace per destination until the
+// context is done.
+func (cli *CLI) ICMP(ctx context.Context) ([]*methods.TraceResult, error) {
+	return cli.traceroutes(ctx, "icmp")
 }
 
-// TCP is used by the Service TCP traceroute system, it will generate a trace per destination.
-func (cli *CLI) TCP() error {
-	destinations, err := parseDestination(cli.Destination)
+// traceroutes runs the traceroute with the protocol of the command to every address of the destination. A
+// failing address does not stop the traceroutes to the others, the errors of all of the addresses are joined.
+func (cli *CLI) traceroutes(ctx context.Context, command string) ([]*methods.TraceResult, error) {
+	destinations, err := parseDestination(cli.Destination, cli.Family)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// exportTrace will export the spans when the tool quits.
 	exportTrace, err := cli.initTraceProvider(cli.Timeout)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer exportTrace()
 
-	ctx := context.Background()
+	// exportMetrics will export the metrics when the tool quits.
+	exportMetrics, err := cli.initMetricsExporter()
+	if err != nil {
+		return nil, err
+	}
+	defer exportMetrics()
+
 	// ctx is reset with the baggage added.
 	ctx, err = cli.initBaggage(ctx)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	err = cli.initEnricher()
+	if err != nil {
+		return nil, err
 	}
 
 	cfg := cli.translateConfig(ctx)
 
-	var res *map[uint16][]methods.TracerouteHop
-	for i := 0; i < len(destinations); i++ {
-		for i := 0; i < len(destinations); i++ {
-			tcpTraceroute := tcp.New(destinations[i], cfg)
-			res, err = tcpTraceroute.Start()
+	out, err := formatter.New(cli.Output, os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*methods.TraceResult, 0, len(destinations))
+	var errs []error
+	for _, destination := range destinations {
+		res, terr := start(ctx, command, destination, cfg)
+		cli.observe(command, res, terr)
+		if res != nil {
+			results = append(results, res)
+		}
+		if terr != nil {
+			errs = append(errs, terr)
+			continue
 		}
 		if cli.PrintResults {
-			printResults(res)
+			errs = append(errs, cli.printResults(out, res))
 		}
 	}
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return results, errors.Join(errs...)
 }
 
 // translateConfig makes the configuration compatible with the root traceroute fork
@@ -183,16 +391,57 @@ func (cli *CLI) translateConfig(ctx context.Context) methods.TracerouteConfig {
 		DestinationHostname: cli.Destination,
 		LocalHostname:       cli.Hostname,
 		MaxHops:             cli.MaxHops,
-		NumMeasurements:     3,
+		NumMeasurements:     cli.NQueries,
 		ParallelRequests:    cli.ParallelRequests,
 		Port:                cli.TraceRoutePort,
 		Timeout:             cli.Timeout,
-		Tracer:              otel.Tracer(fmt.Sprintf(tracerName, cli.Hostname)),
+		Paris:               cli.Paris,
+		Multipath:           cli.MDA,
+		MultipathConfidence: cli.MDAConfidence,
+		Enricher:            cli.Enricher,
+		PathRecorder:        cli.PathRecorder,
+		Tags:                cli.Tags,
+		SpanLayout:          methods.SpanLayout(cli.SpanLayout),
+		Tracer:              cli.tracerProvider().Tracer(fmt.Sprintf(tracerName, cli.Hostname)),
 		Xid:                 xid.New(),
 		TraceCtx:            ctx,
 	}
 }
 
+// initSinks opens the sinks enabled in the sink configuration file, there are no sinks without the file.
+func (cli *CLI) initSinks() (*sink.Multi, error) {
+	if cli.SinkConfig == "" {
+		return &sink.Multi{}, nil
+	}
+	cfg, err := config.LoadSinksFromFile(cli.SinkConfig)
+	if err != nil {
+		return nil, err
+	}
+	return sink.New(*cfg)
+}
+
+// initEnricher creates the Enricher for the hop addresses unless one is already set, the service sets it so
+// the ASN table is loaded once and the reverse names are cached between traceroutes.
+func (cli *CLI) initEnricher() error {
+	if cli.Enricher != nil {
+		return nil
+	}
+	var ptr *enrich.PTR
+	if !cli.NoResolve {
+		ptr = enrich.NewPTR(net.DefaultResolver, cli.Timeout)
+	}
+	var table *enrich.Table
+	if cli.ASNTable != "" {
+		var err error
+		table, err = enrich.LoadTable(cli.ASNTable)
+		if err != nil {
+			return err
+		}
+	}
+	cli.Enricher = enrich.New(ptr, table)
+	return nil
+}
+
 // initBaggage will include the attributes globally for all spans. This works on some
 // otel recivers but not all.
 func (cli *CLI) initBaggage(ctx context.Context) (context.Context, error) {
@@ -228,9 +477,18 @@ func (cli *CLI) initBaggage(ctx context.Context) (context.Context, error) {
 	return baggage.ContextWithBaggage(ctx, bag), nil
 }
 
-// initTraceProvider is instantiated early and then run as the final function to export the trace.
-func (cli *CLI) initTraceProvider(timeout time.Duration) (func(), error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), timeout*time.Second)
+// tracerProvider returns the provider shared by the service or the global provider.
+func (cli *CLI) tracerProvider() oteltrace.TracerProvider {
+	if cli.TracerProvider != nil {
+		return cli.TracerProvider
+	}
+	return otel.GetTracerProvider()
+}
+
+// NewTracerProvider returns the provider that exports the spans to the OpenTelemetry destination.
+func (cli *CLI) NewTracerProvider(timeout time.Duration) (*sdktrace.TracerProvider, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+	defer cancel()
 
 	res, err := resource.New(ctx,
 		resource.WithAttributes(
@@ -239,65 +497,131 @@ func (cli *CLI) initTraceProvider(timeout time.Duration) (func(), error) {
 		),
 	)
 	if err != nil {
-		cancel()
-		return func() {}, err
+		return nil, err
 	}
-	var exporter *otlptrace.Exporter
-	dst := net.JoinHostPort(cli.OpenTelemetryDestination, fmt.Sprintf("%d", cli.OpenTelemetryPort))
-
-	switch cli.OpenTelemetryGRPC {
-	case true:
-		// GRPC Destination Configuration for the exporter
-		conn, gerr := grpc.DialContext(ctx, dst, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithUserAgent(applicationName))
-		if gerr != nil {
-			cancel()
-			return func() {}, err
-		}
-
-		exporter, err = otlptracegrpc.New(ctx, otlptracegrpc.WithGRPCConn(conn))
-		if err != nil {
-			cancel()
-			return func() {}, err
-		}
-	case false:
-		// HTTP Destination configuration for the exporter
-		exporter, err = otlptracehttp.New(ctx)
-		if err != nil {
-			cancel()
-			return func() {}, err
-		}
+	exporter, err := cli.newTraceExporter(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	batchSpanProcessor := sdktrace.NewBatchSpanProcessor(exporter)
-	tracerProvider := sdktrace.NewTracerProvider(
+	return sdktrace.NewTracerProvider(
 		sdktrace.WithSampler(sdktrace.AlwaysSample()),
 		sdktrace.WithResource(res),
 		sdktrace.WithSpanProcessor(batchSpanProcessor),
-	)
+	), nil
+}
+
+// initTraceProvider is instantiated early and then run as the final function to export the trace, nothing is
+// done when the provider is shared.
+func (cli *CLI) initTraceProvider(timeout time.Duration) (func(), error) {
+	if cli.TracerProvider != nil {
+		return func() {}, nil
+	}
+	tracerProvider, err := cli.NewTracerProvider(timeout)
+	if err != nil {
+		return func() {}, err
+	}
 	otel.SetTracerProvider(tracerProvider)
 
 	return func() {
 		// Shutdown will flush any remaining spans and shut down the exporter.
-		fmt.Printf("flushing TracerProvider to otel server %s", dst)
-		err := tracerProvider.Shutdown(ctx)
+		fmt.Fprintf(os.Stderr, "flushing TracerProvider to otel server %s\n", cli.otlpEndpoint())
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), timeout)
+		defer shutdownCancel()
+		err := tracerProvider.Shutdown(shutdownCtx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error flushing TracerProvider: %s\n", err)
+		}
+	}, nil
+}
+
+// initMetricsExporter records the results of the traceroutes and is run as the final function to export the metrics.
+func (cli *CLI) initMetricsExporter() (func(), error) {
+	if !cli.OpenTelemetryMetrics {
+		return func() {}, nil
+	}
+	registry := metrics.NewRegistry()
+	exporter, err := cli.NewMetricsExporter(registry)
+	if err != nil {
+		return func() {}, err
+	}
+	cli.metrics = metrics.NewTraceroute(registry)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cli.Timeout)
+		defer cancel()
+		err := exporter.Export(ctx)
 		if err != nil {
-			fmt.Printf("error flushing TracerProvider: %s", err)
+			fmt.Fprintf(os.Stderr, "error exporting metrics: %s\n", err)
+		}
+		err = exporter.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error closing the metrics exporter: %s\n", err)
 		}
-		cancel()
 	}, nil
 }
 
-// printResults will print out the results line by line for easy reading.
-func printResults(res *map[uint16][]methods.TracerouteHop) {
-	for i := uint16(0); i < uint16(len(*res)); i++ {
-		if val, ok := (*res)[i]; ok {
-			fmt.Println(i, val)
+// NewMetricsExporter returns the OTLP exporter for the metrics in the registry, it uses the same destination,
+// protocol and TLS settings as the traces and every data point is tagged with the source hostname.
+func (cli *CLI) NewMetricsExporter(registry *metrics.Registry) (*metrics.OTLPExporter, error) {
+	resource := map[string]string{
+		string(semconv.ServiceNameKey): fmt.Sprintf("%s/traceroute", cli.Hostname),
+		"application":                  applicationName,
+	}
+	attributes := map[string]string{
+		"source": cli.Hostname,
+	}
+	tlsConfig, err := cli.otlpTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	opts := []metrics.OTLPOption{metrics.WithHeaders(cli.OpenTelemetryHeaders)}
+	if cli.OpenTelemetryCompression == compressionGzip {
+		opts = append(opts, metrics.WithGzip())
+	}
+
+	if cli.OpenTelemetryGRPC {
+		creds := insecure.NewCredentials()
+		if tlsConfig != nil {
+			creds = credentials.NewTLS(tlsConfig)
+		}
+		// the connection is established in the background when the first export is sent.
+		conn, gerr := grpc.Dial(cli.otlpEndpoint(), grpc.WithTransportCredentials(creds), grpc.WithUserAgent(applicationName))
+		if gerr != nil {
+			return nil, gerr
 		}
+		return metrics.NewOTLPGRPCExporter(registry, conn, resource, attributes, opts...), nil
 	}
+	client := &http.Client{
+		Timeout:   cli.Timeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+	url := cli.otlpURL(cli.OpenTelemetryMetricsPath, metrics.OTLPMetricsPath)
+	return metrics.NewOTLPHTTPExporter(registry, url, client, resource, attributes, opts...), nil
 }
 
-// parseDestination takes a string hostname and returns the IP addresses or handles an error.
-func parseDestination(destination string) ([]net.IP, error) {
+// observe records the result of a traceroute when metrics are exported.
+func (cli *CLI) observe(protocol string, res *methods.TraceResult, err error) {
+	// a cancelled traceroute is not recorded, its result is partial.
+	if cli.metrics == nil || methods.Cancelled(err) {
+		return
+	}
+	if err != nil {
+		cli.metrics.ObserveError(cli.Destination, protocol, err)
+	}
+	if res != nil {
+		cli.metrics.Observe(res)
+	}
+}
+
+// printResults prints the result in the selected output format.
+func (cli *CLI) printResults(out formatter.Formatter, res *methods.TraceResult) error {
+	return out.Format(res)
+}
+
+// parseDestination takes a string hostname and returns the IP addresses of the requested family or handles an error.
+func parseDestination(destination string, family string) ([]net.IP, error) {
 	res, err := net.LookupIP(destination)
 	if err != nil {
 		return nil, err
@@ -311,9 +635,15 @@ func parseDestination(destination string) ([]net.IP, error) {
 		if err != nil {
 			return nil, err
 		}
-		if ip.Is4() {
+		switch {
+		case ip.Is4() && family != FamilyIPv6:
+			results = append(results, res[i])
+		case ip.Is6() && !ip.Is4In6() && family != FamilyIPv4 && family != "":
 			results = append(results, res[i])
 		}
 	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("destination %s has no addresses for family %s", destination, family)
+	}
 	return results, nil
 }
@@ -1,14 +1,29 @@
 package trace
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"net"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/jimmystewpot/traceroute/formatter"
+	"github.com/jimmystewpot/traceroute/methods"
+	"github.com/jimmystewpot/traceroute/methods/simnet"
+	"github.com/jimmystewpot/traceroute/sink"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 func TestParseDestination(t *testing.T) {
 	type args struct {
 		destination string
+		family      string
 	}
 	tests := []struct {
 		name    string
@@ -20,6 +35,7 @@ func TestParseDestination(t *testing.T) {
 			name: "validate destination",
 			args: args{
 				destination: "google.com",
+				family:      FamilyIPv4,
 			},
 			wantErr: false,
 		},
@@ -27,13 +43,14 @@ func TestParseDestination(t *testing.T) {
 			name: "invalidate destination",
 			args: args{
 				destination: "----.com",
+				family:      FamilyIPv4,
 			},
 			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := parseDestination(tt.args.destination)
+			_, err := parseDestination(tt.args.destination, tt.args.family)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("parseDestination() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -100,7 +117,7 @@ func TestCLITCP(t *testing.T) {
 				PrintResults:             tt.fields.PrintResults,
 				Hostname:                 tt.fields.Hostname,
 			}
-			if err := cli.TCP(); (err != nil) != tt.wantErr {
+			if _, err := cli.TCP(context.Background()); (err != nil) != tt.wantErr {
 				t.Errorf("CLI.TCP() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -165,9 +182,105 @@ func TestCLIUDP(t *testing.T) {
 				PrintResults:             tt.fields.PrintResults,
 				Hostname:                 tt.fields.Hostname,
 			}
-			if err := cli.UDP(); (err != nil) != tt.wantErr {
+			if _, err := cli.UDP(context.Background()); (err != nil) != tt.wantErr {
 				t.Errorf("CLI.TCP() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func TestRunCycles(t *testing.T) {
+	dest := net.ParseIP("192.0.2.1")
+	recorder := tracetest.NewSpanRecorder()
+	cli := &CLI{
+		Destination:      dest.String(),
+		Hostname:         "host",
+		MaxHops:          5,
+		NQueries:         2,
+		ParallelRequests: 6,
+		Timeout:          200 * time.Millisecond,
+		TraceRoutePort:   33434,
+		Report:           true,
+		Cycles:           3,
+		TracerProvider:   sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	}
+	cfg := cli.translateConfig(context.Background())
+	cfg.Network = simnet.New(net.ParseIP("198.51.100.1"),
+		simnet.Router{Addr: net.ParseIP("10.0.0.1")},
+		simnet.Router{Addr: dest},
+	)
+	var report bytes.Buffer
+	err := cli.runCycles(context.Background(), "udp", dest, cfg, nil, &sink.Multi{}, []*formatter.Live{nil, formatter.NewLive(&report, false)})
+	if err != nil {
+		t.Fatalf("CLI.runCycles() error = %v", err)
+	}
+
+	// the report accumulates the probes of the three cycles.
+	for _, want := range []string{" 1. 10.0.0.1 ", " 2. 192.0.2.1 "} {
+		i := strings.Index(report.String(), want)
+		if i < 0 || !strings.Contains(report.String()[i:], "0.0%    6") {
+			t.Errorf("CLI.runCycles() report = \n%s\nwant %q with 6 probes", report.String(), want)
+		}
+	}
+
+	// each cycle is its own trace, the traces share the xid.
+	cycles := map[int64]bool{}
+	traces := map[oteltrace.TraceID]bool{}
+	for _, span := range recorder.Ended() {
+		if span.Name() != "host/traceroute/192.0.2.1" {
+			continue
+		}
+		attrs := attribute.NewSet(span.Attributes()...)
+		cycle, _ := attrs.Value("cycle")
+		cycles[cycle.AsInt64()] = true
+		traces[span.SpanContext().TraceID()] = true
+		if xid, _ := attrs.Value("xid"); xid.AsString() != cfg.Xid.String() {
+			t.Errorf("cycle %d xid = %s, want %s", cycle.AsInt64(), xid.AsString(), cfg.Xid)
+		}
+	}
+	if len(cycles) != 3 || !cycles[1] || !cycles[3] || len(traces) != 3 {
+		t.Errorf("CLI.runCycles() exported cycles %v in %d traces, want 1 to 3 in 3 traces", cycles, len(traces))
+	}
+}
+
+func TestRunOnceMultipath(t *testing.T) {
+	dest := net.ParseIP("192.0.2.1")
+	cli := &CLI{
+		Destination:      dest.String(),
+		Hostname:         "host",
+		MaxHops:          5,
+		ParallelRequests: 6,
+		Timeout:          200 * time.Millisecond,
+		TraceRoutePort:   33434,
+		MDA:              true,
+		MDAConfidence:    0.95,
+		PrintResults:     true,
+		TracerProvider:   sdktrace.NewTracerProvider(),
+	}
+	cfg := cli.translateConfig(context.Background())
+	cfg.Network = simnet.New(net.ParseIP("198.51.100.1"),
+		simnet.Router{Addr: net.ParseIP("10.0.0.1")},
+		simnet.Router{Addr: dest},
+	)
+	var buf bytes.Buffer
+	out, err := formatter.New(formatter.JSON, &buf)
+	if err != nil {
+		t.Fatalf("formatter.New() error = %v", err)
+	}
+	err = cli.runOnce(context.Background(), "udp", dest, cfg, out, &sink.Multi{})
+	if err != nil {
+		t.Fatalf("CLI.runOnce() error = %v", err)
+	}
+
+	// the multipath result is printed by the formatter with the probes of every flow and the links.
+	var res methods.TraceResult
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatalf("CLI.runOnce() printed %q: %v", buf.String(), err)
+	}
+	if got := res.Path(); !reflect.DeepEqual(got, []string{"10.0.0.1", "192.0.2.1"}) || !res.ReachedDestination {
+		t.Errorf("CLI.runOnce() path = %v, reached destination %v", got, res.ReachedDestination)
+	}
+	if res.Multipath == nil || len(res.Multipath.Links) != 1 || res.Multipath.ProbesSent == 0 {
+		t.Errorf("CLI.runOnce() multipath = %+v, want a link between the hops", res.Multipath)
+	}
+}